@@ -0,0 +1,324 @@
+package main
+
+import (
+	"AwesomeProject/internal/activitypub"
+	"AwesomeProject/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type webFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+type webFingerResponse struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases"`
+	Links   []webFingerLink `json:"links"`
+}
+
+// webFingerHandler resolves acct:username@domain handles to actors (RFC 7033).
+func (app *application) webFingerHandler(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	handle, ok := strings.CutPrefix(resource, "acct:")
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("resource must be an acct: uri"))
+		return
+	}
+	username, domain, ok := strings.Cut(strings.TrimPrefix(handle, "@"), "@")
+	if !ok || !strings.EqualFold(domain, app.federation.Domain()) {
+		app.notFoundResponse(w, r, store.ErrorNotFound)
+		return
+	}
+	user, err := app.store.Users.GetByUsername(r.Context(), username)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	actorURI := app.federation.ActorURI(user.Username)
+	profileURL := app.profileURL(user)
+	response := webFingerResponse{
+		Subject: fmt.Sprintf("acct:%s@%s", user.Username, app.federation.Domain()),
+		Aliases: []string{actorURI, profileURL},
+		Links: []webFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actorURI},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: profileURL},
+		},
+	}
+	if err := writeActivityJSON(w, http.StatusOK, activitypub.JRDContentType, response); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getActorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	actor, err := app.federation.ActorDocument(r.Context(), user, app.profileURL(user))
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := writeActivityJSON(w, http.StatusOK, activitypub.ContentType, actor); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	outbox, err := app.federation.Outbox(r.Context(), user)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := writeActivityJSON(w, http.StatusOK, activitypub.ContentType, outbox); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getActorFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	followers, err := app.federation.Followers(r.Context(), user)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := writeActivityJSON(w, http.StatusOK, activitypub.ContentType, followers); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getNoteHandler dereferences the Note of a public post.
+func (app *application) getNoteHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "postID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	// Anonymous viewers only see public posts.
+	post, err := app.store.Posts.GetByID(r.Context(), id, 0)
+	if err == nil && post.UserID != user.ID {
+		err = store.ErrorNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	note := app.federation.Note(user, post)
+	note.Context = activitypub.ActivityStreams
+	if err := writeActivityJSON(w, http.StatusOK, activitypub.ContentType, note); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// inboxHandler accepts signed activities from remote servers.
+func (app *application) inboxHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, activitypub.MaxBodyBytes))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	actor, err := app.federation.VerifyRequest(ctx, r, body)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	var activity activitypub.Activity
+	if err := json.Unmarshal(body, &activity); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := app.federation.HandleInbox(ctx, user, actor, &activity); err != nil {
+		switch {
+		case errors.Is(err, activitypub.ErrInvalidActivity):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, activitypub.ErrActorMismatch):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// getRemoteRepliesHandler lists the public replies other servers sent to a post.
+func (app *application) getRemoteRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromContext(r)
+	app.listRemotePosts(w, r, post.ID, app.store.Federation.GetRemoteReplies)
+}
+
+// getRemoteMentionsHandler lists the notes other servers addressed to the current user.
+func (app *application) getRemoteMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	app.listRemotePosts(w, r, user.ID, app.store.Federation.GetRemoteMentions)
+}
+
+func (app *application) listRemotePosts(w http.ResponseWriter, r *http.Request, id int64, list func(ctx context.Context, id int64, query store.RemotePostsQuery) ([]store.RemotePost, store.PageInfo, error)) {
+	rq := store.RemotePostsQuery{
+		Limit: 20,
+	}
+	rq, err := rq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(rq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	posts, page, err := list(r.Context(), id, rq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, posts, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getRemoteFollowRequestsHandler lists the remote actors waiting for the
+// current user to approve their follow.
+func (app *application) getRemoteFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.FollowQuery{
+		Limit: 20,
+	}
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	requests, page, err := app.store.Federation.GetRemoteFollowRequests(r.Context(), user.ID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, requests, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) approveRemoteFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.answerRemoteFollowRequest(w, r, app.federation.ApproveFollow)
+}
+
+func (app *application) rejectRemoteFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	app.answerRemoteFollowRequest(w, r, app.federation.RejectFollow)
+}
+
+func (app *application) answerRemoteFollowRequest(w http.ResponseWriter, r *http.Request, answer func(ctx context.Context, user *store.User, remoteActorID int64) error) {
+	remoteActorID, err := strconv.ParseInt(chi.URLParam(r, "remoteActorID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := answer(r.Context(), user, remoteActorID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) federatedUser(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	user, err := app.store.Users.GetByUsername(r.Context(), chi.URLParam(r, "username"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+func (app *application) profileURL(user *store.User) string {
+	return fmt.Sprintf("%s/users/%s", app.config.frontendURL, user.Username)
+}
+
+func writeActivityJSON(w http.ResponseWriter, status int, contentType string, data any) error {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(data)
+}
+
+// federatePostDeletion tells the remote followers of the post's author that it is
+// gone.
+func (app *application) federatePostDeletion(ctx context.Context, post *store.Post) {
+	app.federateAsAuthor(ctx, post, "deletion", app.federation.PostDeleted)
+}
+
+// federatePostUpdate tells the remote followers of the post's author about an
+// edit, which deletes or creates the post for them when it changed whether it
+// is public.
+func (app *application) federatePostUpdate(ctx context.Context, post *store.Post, previousVisibility string) {
+	app.federateAsAuthor(ctx, post, "update", func(ctx context.Context, user *store.User, post *store.Post) error {
+		return app.federation.PostUpdated(ctx, user, post, previousVisibility)
+	})
+}
+
+// federatePostRestore creates a restored post again on the remote followers of
+// its author. Servers that keep a tombstone of the deleted note may ignore it.
+func (app *application) federatePostRestore(ctx context.Context, post *store.Post) {
+	app.federateAsAuthor(ctx, post, "restore", app.federation.PostCreated)
+}
+
+// federateAsAuthor runs federate on behalf of the author of post, logging what
+// fails. Moderators act on posts of others, so the author is looked up.
+func (app *application) federateAsAuthor(ctx context.Context, post *store.Post, action string, federate func(ctx context.Context, user *store.User, post *store.Post) error) {
+	author, err := app.store.Users.GetByID(ctx, post.UserID)
+	if err == nil {
+		err = federate(ctx, author, post)
+	}
+	if err != nil {
+		app.logger.Warnw("failed to federate post "+action, "post_id", post.ID, "error", err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"AwesomeProject/internal/analytics"
+	"AwesomeProject/internal/store"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const maxAnalyticsDays = 90
+
+// recordPostViews counts a view of kind for every post not written by the viewer.
+// Failures are logged only, view counts must never break a read.
+func (app *application) recordPostViews(ctx context.Context, kind string, viewerID int64, posts ...*store.Post) {
+	now := time.Now()
+	keys := make([]analytics.Key, 0, len(posts))
+	for _, post := range posts {
+		if post.UserID == viewerID {
+			continue
+		}
+		keys = append(keys, analytics.NewKey(post.ID, kind, now))
+	}
+	if err := app.views.Incr(ctx, keys...); err != nil {
+		app.logger.Warnw("failed to record post views", "kind", kind, "error", err.Error())
+	}
+}
+
+func (app *application) getUserAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		d, err := strconv.Atoi(value)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if d < 1 || d > maxAnalyticsDays {
+			app.badRequestResponse(w, r, errors.New("days must be between 1 and 90"))
+			return
+		}
+		days = d
+	}
+
+	user := getUserFromContext(r)
+	since := time.Now().AddDate(0, 0, -(days - 1))
+	result, err := app.store.Analytics.GetUserAnalytics(r.Context(), user.ID, since)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, result); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
@@ -2,11 +2,17 @@ package main
 
 import (
 	"AwesomeProject/docs"
+	"AwesomeProject/internal/activitypub"
+	"AwesomeProject/internal/analytics"
 	"AwesomeProject/internal/auth"
 	"AwesomeProject/internal/mailer"
+	"AwesomeProject/internal/notifications"
 	"AwesomeProject/internal/rateLimiter"
+	"AwesomeProject/internal/realtime"
 	"AwesomeProject/internal/store"
 	"AwesomeProject/internal/store/cache"
+	"AwesomeProject/internal/timeline"
+	"AwesomeProject/internal/unfurl"
 	"fmt"
 	"net/http"
 	"time"
@@ -19,13 +25,19 @@ import (
 )
 
 type application struct {
-	config       config
-	store        *store.Storage
-	cacheStorage *cache.Storage
-	logger       *zap.SugaredLogger
-	mailer       mailer.Client
-	auth         auth.Authenticator
-	rateLimiter  rateLimiter.Limiter
+	config        config
+	store         *store.Storage
+	cacheStorage  *cache.Storage
+	logger        *zap.SugaredLogger
+	mailer        mailer.Client
+	auth          auth.Authenticator
+	rateLimiter   rateLimiter.Limiter
+	previews      *unfurl.Worker
+	views         analytics.Buffer
+	timelines     *timeline.Service
+	federation    *activitypub.Service
+	events        realtime.Broker
+	notifications *notifications.Service
 }
 
 type config struct {
@@ -38,6 +50,39 @@ type config struct {
 	auth        authConfig
 	redis       redisConfig
 	rateLimiter rateLimiter.Config
+	softDelete  softDeleteConfig
+	feed        feedConfig
+	explore     exploreConfig
+	suggestions suggestionsConfig
+	federation  federationConfig
+}
+
+type federationConfig struct {
+	enabled              bool
+	baseURL              string
+	allowPrivateNetworks bool
+	deliveryInterval     time.Duration
+}
+
+type exploreConfig struct {
+	scoring         store.HotScoring
+	refreshInterval time.Duration
+	seenWindow      time.Duration
+}
+
+type suggestionsConfig struct {
+	scoring         store.SuggestionScoring
+	refreshInterval time.Duration
+}
+
+type feedConfig struct {
+	ranking  store.FeedRanking
+	timeline timeline.Config
+}
+
+type softDeleteConfig struct {
+	restoreWindow time.Duration
+	purgeInterval time.Duration
 }
 
 type redisConfig struct {
@@ -89,43 +134,163 @@ func (app *application) mount() *chi.Mux {
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
+	r.Use(app.StripAccessTokenMiddleware)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(app.RateLimiterMiddleware)
-	r.Use(middleware.Timeout(60 * time.Second))
-
-	r.Route("/v1", func(r chi.Router) {
-		r.With().Get("/health", app.healthCheckHandler)
-
-		docsUrl := fmt.Sprintf("%s/swagger/doc.json", app.config.address)
-		r.With(app.BasicAuthMiddleware()).Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsUrl)))
-
-		r.Route("/posts", func(r chi.Router) {
-			r.Use(app.AuthTokenMiddleware)
-			r.Post("/", app.createPostHandler)
-			r.Route("/{postID}", func(r chi.Router) {
-				r.Use(app.postContextMiddleware)
-				r.Get("/", app.getPostHandler)
-				r.Patch("/", app.checkPostOwnership("moderator", app.updatePostHandler))
-				r.Delete("/", app.checkPostOwnership("admin", app.deletePostHandler))
-				r.Post("/comments", app.CreateCommentHandler)
+
+	// Streams stay open far longer than the request timeout allows.
+	r.Route("/v1/stream", func(r chi.Router) {
+		r.Use(app.StreamAuthMiddleware)
+		r.Get("/", app.streamHandler)
+		r.Get("/ws", app.streamWebSocketHandler)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+
+		if app.federation != nil {
+			r.Get("/.well-known/webfinger", app.webFingerHandler)
+			r.Route("/ap/users/{username}", func(r chi.Router) {
+				r.Get("/", app.getActorHandler)
+				r.Get("/outbox", app.getOutboxHandler)
+				r.Get("/followers", app.getActorFollowersHandler)
+				r.Get("/posts/{postID}", app.getNoteHandler)
+				r.Post("/inbox", app.inboxHandler)
 			})
+		}
+
+		r.Route("/users/{username}", func(r chi.Router) {
+			r.Get("/feed.atom", app.userSyndicationHandler(syndicationAtom))
+			r.Get("/feed.rss", app.userSyndicationHandler(syndicationRSS))
+			r.Get("/feed.json", app.userSyndicationHandler(syndicationJSON))
 		})
-		r.Route("/users", func(r chi.Router) {
-			r.Use(app.AuthTokenMiddleware)
-			r.Route("/{userID}", func(r chi.Router) {
-				r.Get("/", app.getUserHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+
+		r.Route("/v1", func(r chi.Router) {
+			r.With().Get("/health", app.healthCheckHandler)
+
+			docsUrl := fmt.Sprintf("%s/swagger/doc.json", app.config.address)
+			r.With(app.BasicAuthMiddleware()).Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsUrl)))
+
+			r.Route("/posts", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Post("/", app.createPostHandler)
+				r.Route("/{postID}", func(r chi.Router) {
+					r.With(app.deletedPostContextMiddleware).Post("/restore", app.checkPostOwnership("moderator", app.restorePostHandler))
+					r.Group(func(r chi.Router) {
+						r.Use(app.postContextMiddleware)
+						r.Get("/", app.getPostHandler)
+						r.Patch("/", app.checkPostOwnership("moderator", app.updatePostHandler))
+						r.Delete("/", app.checkPostOwnership("admin", app.deletePostHandler))
+						r.Put("/lock", app.checkPostOwnership("moderator", app.lockCommentsHandler))
+						r.Delete("/lock", app.checkPostOwnership("moderator", app.unlockCommentsHandler))
+						r.Get("/comments", app.getCommentsHandler)
+						r.Get("/remote-replies", app.getRemoteRepliesHandler)
+						r.Post("/comments", app.CreateCommentHandler)
+						r.Route("/comments/{commentID}", func(r chi.Router) {
+							r.Get("/replies", app.getCommentRepliesHandler)
+							r.With(app.deletedCommentContextMiddleware).Post("/restore", app.checkCommentOwnership("moderator", app.restoreCommentHandler))
+							r.Group(func(r chi.Router) {
+								r.Use(app.commentContextMiddleware)
+								r.Patch("/", app.checkCommentOwnership("moderator", app.updateCommentHandler))
+								r.Delete("/", app.checkCommentOwnership("admin", app.deleteCommentHandler))
+							})
+						})
+						r.Put("/reaction", app.reactToPostHandler)
+						r.Delete("/reaction", app.unreactToPostHandler)
+						r.Post("/poll/votes", app.votePollHandler)
+						r.Delete("/poll/votes", app.unvotePollHandler)
+					})
+				})
 			})
-			r.Group(func(r chi.Router) {
-				r.Get("/feed", app.getUserFeedHandler)
+			r.With(app.AuthTokenMiddleware).Get("/search", app.searchHandler)
+			r.With(app.AuthTokenMiddleware).Get("/explore", app.getExploreHandler)
+			r.Route("/conversations", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/", app.getConversationsHandler)
+				r.Post("/", app.createConversationHandler)
+				r.Get("/unread-count", app.getUnreadMessagesCountHandler)
+				r.Route("/{conversationID}", func(r chi.Router) {
+					r.Use(app.conversationContextMiddleware)
+					r.Get("/", app.getConversationHandler)
+					r.Get("/messages", app.getMessagesHandler)
+					r.Post("/messages", app.createMessageHandler)
+					r.Delete("/messages/{messageID}", app.deleteMessageHandler)
+					r.Post("/read", app.markConversationReadHandler)
+				})
+			})
+			r.Route("/communities", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/", app.getCommunitiesHandler)
+				r.Post("/", app.createCommunityHandler)
+				r.Route("/{slug}", func(r chi.Router) {
+					r.Use(app.communityContextMiddleware)
+					r.Get("/", app.getCommunityHandler)
+					r.Patch("/", app.checkCommunityRole(store.CommunityRoleOwner, app.updateCommunityHandler))
+					r.Get("/feed", app.getCommunityFeedHandler)
+					r.Post("/join", app.joinCommunityHandler)
+					r.Post("/leave", app.leaveCommunityHandler)
+					r.Get("/members", app.getCommunityMembersHandler)
+					r.Route("/members/{userID}", func(r chi.Router) {
+						r.Put("/approve", app.checkCommunityRole(store.CommunityRoleModerator, app.approveCommunityMemberHandler))
+						r.Put("/invite", app.checkCommunityRole(store.CommunityRoleModerator, app.inviteCommunityMemberHandler))
+						r.Put("/role", app.checkCommunityRole(store.CommunityRoleOwner, app.setCommunityMemberRoleHandler))
+						r.Delete("/", app.checkCommunityRole(store.CommunityRoleModerator, app.removeCommunityMemberHandler))
+					})
+					r.Get("/bans", app.checkCommunityRole(store.CommunityRoleModerator, app.getCommunityBansHandler))
+					r.Put("/bans/{userID}", app.checkCommunityRole(store.CommunityRoleModerator, app.banCommunityMemberHandler))
+					r.Delete("/bans/{userID}", app.checkCommunityRole(store.CommunityRoleModerator, app.unbanCommunityMemberHandler))
+				})
+			})
+			r.Route("/notifications", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/", app.getNotificationsHandler)
+				r.Get("/unread-count", app.getUnreadNotificationsCountHandler)
+				r.Post("/read", app.markAllNotificationsReadHandler)
+				r.Post("/{notificationID}/read", app.markNotificationReadHandler)
+			})
+			r.Route("/users", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Get("/me/analytics", app.getUserAnalyticsHandler)
+				r.Get("/me/settings", app.getUserSettingsHandler)
+				r.Patch("/me/settings", app.updateUserSettingsHandler)
+				r.Get("/me/blocks", app.getBlockedUsersHandler)
+				r.Get("/me/mutes", app.getMutedUsersHandler)
+				r.Get("/me/remote-mentions", app.getRemoteMentionsHandler)
+				r.Get("/suggestions", app.getSuggestionsHandler)
+				r.Post("/suggestions/{userID}/dismiss", app.dismissSuggestionHandler)
+				r.Route("/me/follow-requests", func(r chi.Router) {
+					r.Get("/", app.getFollowRequestsHandler)
+					r.Post("/{userID}/approve", app.approveFollowRequestHandler)
+					r.Post("/{userID}/reject", app.rejectFollowRequestHandler)
+					if app.federation != nil {
+						r.Route("/remote", func(r chi.Router) {
+							r.Get("/", app.getRemoteFollowRequestsHandler)
+							r.Post("/{remoteActorID}/approve", app.approveRemoteFollowRequestHandler)
+							r.Post("/{remoteActorID}/reject", app.rejectRemoteFollowRequestHandler)
+						})
+					}
+				})
+				r.Route("/{userID}", func(r chi.Router) {
+					r.Get("/", app.getUserHandler)
+					r.Put("/follow", app.followUserHandler)
+					r.Put("/unfollow", app.unfollowUserHandler)
+					r.Put("/block", app.blockUserHandler)
+					r.Put("/unblock", app.unblockUserHandler)
+					r.Put("/mute", app.muteUserHandler)
+					r.Put("/unmute", app.unmuteUserHandler)
+					r.Get("/followers", app.getFollowersHandler)
+					r.Get("/following", app.getFollowingHandler)
+				})
+				r.Group(func(r chi.Router) {
+					r.Get("/feed", app.getUserFeedHandler)
+				})
+			})
+			r.Route("/authentication", func(r chi.Router) {
+				r.Post("/user", app.registerUserHandler)
+				r.Put("/activate/{token}", app.activateUserHandler)
+				r.Post("/token", app.createTokenHandler)
 			})
-		})
-		r.Route("/authentication", func(r chi.Router) {
-			r.Post("/user", app.registerUserHandler)
-			r.Put("/activate/{token}", app.activateUserHandler)
-			r.Post("/token", app.createTokenHandler)
 		})
 	})
 	return r
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"AwesomeProject/internal/realtime"
 	"AwesomeProject/internal/store"
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+type commentKey string
+
+const commentCtx commentKey = "comment"
+
 type CreateCommentPayload struct {
-	Content string `json:"content,omitempty,required"`
-	UserID  int64  `json:"user_id,omitempty,required"`
-	PostID  int64  `json:"post_id,omitempty,required"`
+	Content  string `json:"content" validate:"required,max=5000"`
+	ParentID *int64 `json:"parent_id" validate:"omitempty,gte=1"`
 }
 
 func (app *application) CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
@@ -21,16 +31,215 @@ func (app *application) CreateCommentHandler(w http.ResponseWriter, r *http.Requ
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	if post.CommunityID != nil {
+		banned, err := app.store.Communities.IsBanned(r.Context(), *post.CommunityID, user.ID)
+		if err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+		if banned {
+			app.forbiddenResponse(w, r, store.ErrCommunityBanned)
+			return
+		}
+	}
 	comment := store.Comment{
-		Content: payload.Content,
-		PostID:  payload.PostID,
-		UserID:  payload.UserID,
+		Content:  payload.Content,
+		PostID:   post.ID,
+		UserID:   user.ID,
+		ParentID: payload.ParentID,
+		User:     store.User{ID: user.ID, Username: user.Username},
 	}
 	err := app.store.Comments.CreateComments(r.Context(), &comment)
 	if err != nil {
-		app.internalServerErrorHandler(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrParentCommentNotFound), errors.Is(err, store.ErrMaxCommentDepth):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrCommentsLocked), errors.Is(err, store.ErrBlocked):
+			app.forbiddenResponse(w, r, err)
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
 	}
+	app.publishCommentEvent(r.Context(), realtime.EventCommentCreated, &comment, comment)
 	if err := app.jsonResponse(w, http.StatusCreated, comment); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 	}
 }
+
+type UpdateCommentPayload struct {
+	Content string `json:"content" validate:"required,max=5000"`
+}
+
+func (app *application) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromContext(r)
+	var payload UpdateCommentPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	comment.Content = payload.Content
+	if err := app.store.Comments.Update(r.Context(), comment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	app.publishCommentEvent(r.Context(), realtime.EventCommentUpdated, comment, comment)
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment := getCommentFromContext(r)
+	if err := app.store.Comments.Delete(r.Context(), comment.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	app.publishCommentEvent(r.Context(), realtime.EventCommentDeleted, comment, map[string]int64{
+		"id":      comment.ID,
+		"post_id": comment.PostID,
+	})
+	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"result": "success"}); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostFromContext(r)
+	app.commentRepliesResponse(w, r, post.ID, nil, "desc")
+}
+
+func (app *application) getCommentRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	parentID, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	post := getPostFromContext(r)
+	app.commentRepliesResponse(w, r, post.ID, &parentID, "asc")
+}
+
+func (app *application) commentRepliesResponse(w http.ResponseWriter, r *http.Request, postID int64, parentID *int64, sort string) {
+	cq := store.PaginatedCommentsQuery{
+		Limit: 20,
+		Sort:  sort,
+	}
+	cq, err := cq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(cq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	viewer := getUserFromContext(r)
+	comments, page, err := app.store.Comments.GetReplies(r.Context(), postID, parentID, viewer.ID, cq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, comments, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deleted := getCommentFromContext(r)
+	deletedAfter := time.Now().Add(-app.config.softDelete.restoreWindow)
+	if err := app.store.Comments.Restore(ctx, deleted.ID, deletedAfter); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	comment, err := app.store.Comments.GetByID(ctx, deleted.PostID, deleted.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.publishCommentEvent(ctx, realtime.EventCommentCreated, comment, comment)
+	if err := app.jsonResponse(w, http.StatusOK, comment); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) commentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		post := getPostFromContext(r)
+		comment, err := app.store.Comments.GetByID(ctx, post.ID, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// deletedCommentContextMiddleware loads a soft deleted comment of the current post
+// that is still inside the restore window.
+func (app *application) deletedCommentContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		post := getPostFromContext(r)
+		deletedAfter := time.Now().Add(-app.config.softDelete.restoreWindow)
+		comment, err := app.store.Comments.GetDeletedByID(ctx, post.ID, id, deletedAfter)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, commentCtx, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommentFromContext(r *http.Request) *store.Comment {
+	comment, _ := r.Context().Value(commentCtx).(*store.Comment)
+	return comment
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"AwesomeProject/internal/analytics"
+	"AwesomeProject/internal/store"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type communityKey string
+
+const communityCtx communityKey = "community"
+
+var communitySlugRegexp = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// communityRoleRanks orders the community roles, higher ranks may do whatever
+// lower ones may.
+var communityRoleRanks = map[string]int{
+	store.CommunityRoleMember:    1,
+	store.CommunityRoleModerator: 2,
+	store.CommunityRoleOwner:     3,
+}
+
+// communityGlobalRoles maps the community roles to the global role they are
+// worth within their community.
+var communityGlobalRoles = map[string]string{
+	store.CommunityRoleModerator: "moderator",
+	store.CommunityRoleOwner:     "admin",
+}
+
+type CreateCommunityPayload struct {
+	Slug        string   `json:"slug" validate:"required,min=3,max=50"`
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description" validate:"max=5000"`
+	Rules       []string `json:"rules" validate:"max=20,dive,required,max=500"`
+	JoinPolicy  string   `json:"join_policy" validate:"omitempty,oneof=open request invite"`
+}
+
+func (app *application) createCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateCommunityPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !communitySlugRegexp.MatchString(payload.Slug) {
+		app.badRequestResponse(w, r, errors.New("slug may only contain lowercase letters, digits and single dashes"))
+		return
+	}
+
+	user := getUserFromContext(r)
+	community := &store.Community{
+		Slug:        payload.Slug,
+		Name:        payload.Name,
+		Description: payload.Description,
+		Rules:       payload.Rules,
+		JoinPolicy:  payload.JoinPolicy,
+		CreatedBy:   &user.ID,
+	}
+	if err := app.store.Communities.Create(r.Context(), community); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateCommunitySlug):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, community); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getCommunitiesHandler(w http.ResponseWriter, r *http.Request) {
+	cq := store.CommunityQuery{
+		Limit: 20,
+	}
+	cq, err := cq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(cq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	communities, page, err := app.store.Communities.GetAll(r.Context(), user.ID, cq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, communities, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	community := getCommunityFromContext(r)
+	if err := app.jsonResponse(w, http.StatusOK, community); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type UpdateCommunityPayload struct {
+	Name        *string   `json:"name" validate:"omitempty,min=1,max=100"`
+	Description *string   `json:"description" validate:"omitempty,max=5000"`
+	Rules       *[]string `json:"rules" validate:"omitempty,max=20,dive,required,max=500"`
+	JoinPolicy  *string   `json:"join_policy" validate:"omitempty,oneof=open request invite"`
+}
+
+func (app *application) updateCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateCommunityPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	community := getCommunityFromContext(r)
+	if payload.Name != nil {
+		community.Name = *payload.Name
+	}
+	if payload.Description != nil {
+		community.Description = *payload.Description
+	}
+	if payload.Rules != nil {
+		community.Rules = *payload.Rules
+	}
+	if payload.JoinPolicy != nil {
+		community.JoinPolicy = *payload.JoinPolicy
+	}
+	if err := app.store.Communities.Update(r.Context(), community); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, community); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getCommunityFeedHandler lists the posts published into the community with the
+// filters of the user feed, newest first by default.
+func (app *application) getCommunityFeedHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.PaginatedFeedQuery{
+		Limit: 20,
+		Sort:  "desc",
+		Mode:  store.FeedModeChronological,
+	}
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	viewer := getUserFromContext(r)
+	community := getCommunityFromContext(r)
+	posts, page, err := app.store.Posts.GetCommunityFeed(ctx, viewer.ID, community.ID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	feedPosts := make([]*store.Post, len(posts))
+	for i := range posts {
+		feedPosts[i] = &posts[i].Post
+	}
+	if err := app.attachPostDetails(ctx, feedPosts, viewer.ID); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.recordPostViews(ctx, analytics.KindImpression, viewer.ID, feedPosts...)
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, posts, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// joinCommunityHandler joins an open community, asks to join one reviewing
+// requests and accepts the invitation to an invite only one.
+func (app *application) joinCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	community := getCommunityFromContext(r)
+	member, err := app.store.Communities.Join(r.Context(), community, user.ID)
+	if err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	member.Username = user.Username
+	if err := app.jsonResponse(w, http.StatusOK, member); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// leaveCommunityHandler leaves the community, withdraws a join request or
+// declines an invitation.
+func (app *application) leaveCommunityHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	community := getCommunityFromContext(r)
+	if err := app.store.Communities.RemoveMember(r.Context(), community.ID, user.ID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getCommunityMembersHandler lists the active members. Pending requests and
+// open invitations are listed to moderators only.
+func (app *application) getCommunityMembersHandler(w http.ResponseWriter, r *http.Request) {
+	mq := store.CommunityMemberQuery{
+		Limit:  20,
+		Status: store.CommunityMemberActive,
+	}
+	mq, err := mq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(mq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	community := getCommunityFromContext(r)
+	if mq.Status != store.CommunityMemberActive {
+		allowed, err := app.hasCommunityRole(ctx, getUserFromContext(r), community, store.CommunityRoleModerator)
+		if err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+		if !allowed {
+			app.forbiddenResponse(w, r, errors.New("only moderators may list pending and invited members"))
+			return
+		}
+	}
+	members, page, err := app.store.Communities.GetMembers(ctx, community.ID, mq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, members, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) approveCommunityMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	community := getCommunityFromContext(r)
+	if err := app.store.Communities.ApproveMember(ctx, community.ID, userID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	app.writeCommunityMember(w, r, community.ID, userID)
+}
+
+// inviteCommunityMemberHandler invites a user, or approves their pending request.
+func (app *application) inviteCommunityMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	community := getCommunityFromContext(r)
+	if _, err := app.store.Communities.Invite(ctx, community.ID, userID, user.ID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	app.writeCommunityMember(w, r, community.ID, userID)
+}
+
+type SetCommunityMemberRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=member moderator"`
+}
+
+func (app *application) setCommunityMemberRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	var payload SetCommunityMemberRolePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	community := getCommunityFromContext(r)
+	if err := app.store.Communities.SetMemberRole(ctx, community.ID, userID, payload.Role); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	app.writeCommunityMember(w, r, community.ID, userID)
+}
+
+// removeCommunityMemberHandler removes a member, declines a join request or
+// withdraws an invitation. Only the owner removes moderators.
+func (app *application) removeCommunityMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	community := getCommunityFromContext(r)
+	if err := app.checkCommunityTarget(ctx, getUserFromContext(r), community, userID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.store.Communities.RemoveMember(ctx, community.ID, userID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getCommunityBansHandler(w http.ResponseWriter, r *http.Request) {
+	mq := store.CommunityMemberQuery{
+		Limit:  20,
+		Status: store.CommunityMemberActive,
+	}
+	mq, err := mq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(mq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	community := getCommunityFromContext(r)
+	bans, page, err := app.store.Communities.GetBans(r.Context(), community.ID, mq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, bans, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type BanCommunityMemberPayload struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// banCommunityMemberHandler removes a user from the community and keeps them out.
+// Only the owner bans moderators.
+func (app *application) banCommunityMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	var payload BanCommunityMemberPayload
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &payload); err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	community := getCommunityFromContext(r)
+	if err := app.checkCommunityTarget(ctx, user, community, userID); err != nil && !errors.Is(err, store.ErrorNotFound) {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	ban := &store.CommunityBan{
+		CommunityID: community.ID,
+		UserID:      userID,
+		BannedBy:    &user.ID,
+		Reason:      payload.Reason,
+	}
+	if err := app.store.Communities.Ban(ctx, ban); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, ban); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) unbanCommunityMemberHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	community := getCommunityFromContext(r)
+	if err := app.store.Communities.Unban(r.Context(), community.ID, userID); err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) writeCommunityMember(w http.ResponseWriter, r *http.Request, communityID int64, userID int64) {
+	member, err := app.store.Communities.GetMember(r.Context(), communityID, userID)
+	if err != nil {
+		app.communityErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, member); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// checkCommunityTarget keeps moderators from acting on their peers: a member
+// holding the moderator role or above may only be handled by someone ranking
+// as the owner.
+func (app *application) checkCommunityTarget(ctx context.Context, user *store.User, community *store.Community, userID int64) error {
+	target, err := app.store.Communities.GetMember(ctx, community.ID, userID)
+	if err != nil {
+		return err
+	}
+	if communityRoleRanks[target.Role] < communityRoleRanks[store.CommunityRoleModerator] {
+		return nil
+	}
+	allowed, err := app.hasCommunityRole(ctx, user, community, store.CommunityRoleOwner)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.New("only the owner may act on moderators")
+	}
+	return nil
+}
+
+func (app *application) communityErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrCommunityBanned), errors.Is(err, store.ErrCommunityInviteOnly), errors.Is(err, store.ErrNotCommunityMember):
+		app.forbiddenResponse(w, r, err)
+	case errors.Is(err, store.ErrCommunityOwner):
+		app.conflictResponse(w, r, err)
+	default:
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// checkCommunityRole lets through the users who hold at least communityRole in
+// the community from context, or a global role at least as high as the one it
+// is worth.
+func (app *application) checkCommunityRole(communityRole string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+		community := getCommunityFromContext(r)
+
+		allowed, err := app.hasCommunityRole(r.Context(), user, community, communityRole)
+		if err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+		if !allowed {
+			app.methodNotAllowedResponse(w, r, errors.New("user not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) hasCommunityRole(ctx context.Context, user *store.User, community *store.Community, communityRole string) (bool, error) {
+	if m := community.Membership; m != nil && m.Status == store.CommunityMemberActive &&
+		communityRoleRanks[m.Role] >= communityRoleRanks[communityRole] {
+		return true, nil
+	}
+	return app.checkRolePrecedence(ctx, user, communityGlobalRoles[communityRole])
+}
+
+// checkCommunityPrecedence reports whether the role of user in communityID is
+// worth at least the global role roleName: community moderators moderate the
+// posts and comments of their community as global moderators would.
+func (app *application) checkCommunityPrecedence(ctx context.Context, user *store.User, communityID int64, roleName string) (bool, error) {
+	member, err := app.store.Communities.GetMember(ctx, communityID, user.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrorNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	granted, ok := communityGlobalRoles[member.Role]
+	if !ok || member.Status != store.CommunityMemberActive {
+		return false, nil
+	}
+	grantedRole, err := app.store.Roles.GetByName(ctx, granted)
+	if err != nil {
+		return false, err
+	}
+	requiredRole, err := app.store.Roles.GetByName(ctx, roleName)
+	if err != nil {
+		return false, err
+	}
+	return grantedRole.Level >= requiredRole.Level, nil
+}
+
+// communityContextMiddleware loads the community named by the slug together
+// with the current user's membership.
+func (app *application) communityContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := getUserFromContext(r)
+		community, err := app.store.Communities.GetBySlug(ctx, chi.URLParam(r, "slug"), user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, communityCtx, community)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getCommunityFromContext(r *http.Request) *store.Community {
+	community, _ := r.Context().Value(communityCtx).(*store.Community)
+	return community
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"AwesomeProject/internal/realtime"
+	"AwesomeProject/internal/store"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type conversationKey string
+
+const conversationCtx conversationKey = "conversation"
+
+type CreateConversationPayload struct {
+	ParticipantIDs []int64 `json:"participant_ids" validate:"required,min=1,max=9,dive,gte=1"`
+	Title          string  `json:"title" validate:"max=100"`
+}
+
+// createConversationHandler starts a 1:1 conversation when a single participant
+// is given and a group otherwise. Starting a 1:1 conversation that exists
+// returns it with 200.
+func (app *application) createConversationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateConversationPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	participantIDs := make([]int64, 0, len(payload.ParticipantIDs))
+	seen := map[int64]bool{user.ID: true}
+	for _, id := range payload.ParticipantIDs {
+		if !seen[id] {
+			seen[id] = true
+			participantIDs = append(participantIDs, id)
+		}
+	}
+	if len(participantIDs) == 0 {
+		app.badRequestResponse(w, r, errors.New("a conversation needs another participant"))
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Conversations.CheckCanMessage(ctx, user.ID, participantIDs); err != nil {
+		app.conversationErrorResponse(w, r, err)
+		return
+	}
+	conversation := &store.Conversation{
+		IsGroup:   len(participantIDs) > 1,
+		CreatedBy: &user.ID,
+	}
+	if conversation.IsGroup {
+		conversation.Title = payload.Title
+	}
+	created, err := app.store.Conversations.Create(ctx, conversation, participantIDs)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	if err := app.jsonResponse(w, status, conversation); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	cq := store.ConversationQuery{
+		Limit: 20,
+	}
+	cq, err := cq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(cq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	conversations, page, err := app.store.Conversations.GetByUser(r.Context(), user.ID, cq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, conversations, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getUnreadMessagesCountHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	count, err := app.store.Conversations.CountUnread(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int64{"count": count}); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getConversationHandler(w http.ResponseWriter, r *http.Request) {
+	conversation := getConversationFromContext(r)
+	if err := app.jsonResponse(w, http.StatusOK, conversation); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	cq := store.ConversationQuery{
+		Limit: 50,
+	}
+	cq, err := cq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(cq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	conversation := getConversationFromContext(r)
+	messages, page, err := app.store.Conversations.GetMessages(r.Context(), conversation.ID, user.ID, cq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, messages, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type CreateMessagePayload struct {
+	Content string `json:"content" validate:"required,max=5000"`
+}
+
+func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateMessagePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	conversation := getConversationFromContext(r)
+	// The other side of a 1:1 conversation may have tightened their policy since it started.
+	if !conversation.IsGroup {
+		if err := app.store.Conversations.CheckCanMessage(ctx, user.ID, otherParticipants(conversation, user.ID)); err != nil {
+			app.conversationErrorResponse(w, r, err)
+			return
+		}
+	}
+	// Group members blocked either way since the group started do not get the
+	// message, and a sender nobody would hear is refused.
+	recipientIDs, err := app.store.Conversations.GetRecipientIDs(ctx, conversation.ID, user.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if len(recipientIDs) == 0 {
+		app.conversationErrorResponse(w, r, store.ErrMessagingNotAllowed)
+		return
+	}
+	message := &store.Message{
+		ConversationID: conversation.ID,
+		SenderID:       user.ID,
+		Content:        payload.Content,
+	}
+	if err := app.store.Conversations.CreateMessage(ctx, message); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.publishConversationEvent(ctx, user.ID, recipientIDs, realtime.EventMessageCreated, message)
+	if err := app.jsonResponse(w, http.StatusCreated, message); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	messageID, err := strconv.ParseInt(chi.URLParam(r, "messageID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	conversation := getConversationFromContext(r)
+	if err := app.store.Conversations.DeleteMessage(r.Context(), conversation.ID, user.ID, messageID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"result": "success"}); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type MarkConversationReadPayload struct {
+	MessageID int64 `json:"message_id" validate:"gte=0"`
+}
+
+// markConversationReadHandler moves the user's read receipt to message_id, or to
+// the latest message when it is omitted, and tells the other participants.
+func (app *application) markConversationReadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MarkConversationReadPayload
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &payload); err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	conversation := getConversationFromContext(r)
+	lastRead, err := app.store.Conversations.MarkRead(ctx, conversation.ID, user.ID, payload.MessageID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	receipt := map[string]int64{
+		"conversation_id":      conversation.ID,
+		"user_id":              user.ID,
+		"last_read_message_id": lastRead,
+	}
+	// The receipt is stored, so telling the others stays best effort.
+	if recipientIDs, err := app.store.Conversations.GetRecipientIDs(ctx, conversation.ID, user.ID); err != nil {
+		app.logger.Warnw("failed to publish read receipt", "conversation_id", conversation.ID, "error", err.Error())
+	} else {
+		app.publishConversationEvent(ctx, user.ID, recipientIDs, realtime.EventMessageRead, receipt)
+	}
+	if err := app.jsonResponse(w, http.StatusOK, receipt); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// publishConversationEvent sends an event of userID to their own streams and to
+// the recipients of what they do in the conversation.
+func (app *application) publishConversationEvent(ctx context.Context, userID int64, recipientIDs []int64, eventType string, data any) {
+	topics := []string{realtime.UserTopic(userID)}
+	for _, id := range recipientIDs {
+		topics = append(topics, realtime.UserTopic(id))
+	}
+	app.publishEvent(ctx, eventType, data, topics...)
+}
+
+func (app *application) conversationErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrMessagingNotAllowed):
+		app.forbiddenResponse(w, r, err)
+	default:
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func otherParticipants(conversation *store.Conversation, userID int64) []int64 {
+	ids := []int64{}
+	for _, p := range conversation.Participants {
+		if p.UserID != userID {
+			ids = append(ids, p.UserID)
+		}
+	}
+	return ids
+}
+
+// conversationContextMiddleware loads a conversation of the current user;
+// conversations they do not take part in are reported as not found.
+func (app *application) conversationContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(chi.URLParam(r, "conversationID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		user := getUserFromContext(r)
+		conversation, err := app.store.Conversations.GetByID(ctx, id, user.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, conversationCtx, conversation)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getConversationFromContext(r *http.Request) *store.Conversation {
+	conversation, _ := r.Context().Value(conversationCtx).(*store.Conversation)
+	return conversation
+}
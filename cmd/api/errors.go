@@ -1,9 +1,28 @@
 package main
 
 import (
+	"AwesomeProject/internal/store"
+	"errors"
 	"net/http"
 )
 
+// errorCodes are the stable codes returned with errors clients act on.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{store.ErrCommentsLocked, "comments_locked"},
+}
+
+func errorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
 func (app *application) internalServerErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Errorw("Internal Server Error %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
 	writeJSONError(w, http.StatusInternalServerError, "Internal Server Error")
@@ -19,6 +38,16 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request,
 	writeJSONError(w, http.StatusNotFound, "Not Found Error")
 }
 
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Infof("Conflict Error %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+	writeJSONError(w, http.StatusConflict, err.Error())
+}
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Infof("Forbidden Error %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
+	writeJSONErrorCode(w, http.StatusForbidden, err.Error(), errorCode(err))
+}
+
 func (app *application) unauthorizedBasicErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Infof("Unauthorized Error %s path: %s error: %s", r.Method, r.URL.Path, err.Error())
 	w.Header().Set("WWW-Authenticate", `Basic realm="Restricted", charset="UTF-8"`)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"AwesomeProject/internal/analytics"
+	"AwesomeProject/internal/store"
+	"net/http"
+	"time"
+)
+
+func (app *application) getExploreHandler(w http.ResponseWriter, r *http.Request) {
+	eq := store.ExploreQuery{
+		Scope: store.ExploreScopePopular,
+		Limit: 20,
+	}
+	eq, err := eq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(eq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	viewer := getUserFromContext(r)
+	seenSince := time.Now().Add(-app.config.explore.seenWindow)
+	posts, page, err := app.store.Explore.GetExplore(ctx, viewer.ID, eq, seenSince)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	// Posts from other servers carry remote IDs, which must not be taken for
+	// local posts when attaching details, counting views or marking them seen.
+	explorePosts := make([]*store.Post, 0, len(posts))
+	postIDs := make([]int64, 0, len(posts))
+	for i := range posts {
+		if posts[i].Remote != nil {
+			continue
+		}
+		explorePosts = append(explorePosts, &posts[i].Post)
+		postIDs = append(postIDs, posts[i].ID)
+	}
+	if err := app.attachPostDetails(ctx, explorePosts, viewer.ID); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.recordPostViews(ctx, analytics.KindImpression, viewer.ID, explorePosts...)
+	// Served posts are hidden from the next explore lists, not from the pages
+	// of this one, whose cursors keep when it was first read.
+	if err := app.store.Explore.MarkSeen(ctx, viewer.ID, postIDs); err != nil {
+		app.logger.Warnw("failed to mark posts seen", "error", err.Error())
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, posts, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
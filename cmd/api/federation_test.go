@@ -0,0 +1,729 @@
+package main
+
+import (
+	"AwesomeProject/internal/activitypub"
+	"AwesomeProject/internal/rateLimiter"
+	"AwesomeProject/internal/store"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-fed/httpsig"
+	"go.uber.org/zap"
+)
+
+// TestFederationBetweenInstances runs two servers side by side and federates
+// alice on the first with bob on the second through their real routes, HTTP
+// signatures and delivery queues, keeping state in memory.
+func TestFederationBetweenInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := newInstance(t, ctx, "alice")
+	b := newInstance(t, ctx, "bob")
+	alice, bob := a.user, b.user
+	aliceURI := a.app.federation.ActorURI(alice.Username)
+	bobNote := b.addPost(&store.Post{ID: 7, UserID: bob.ID, Title: "Hello", ContentHTML: "<p>Hello</p>", Visibility: store.VisibilityPublic})
+
+	// WebFinger resolves the handle to the actor, whose document has the inbox
+	// and public key the other server needs.
+	var finger webFingerResponse
+	resource := url.QueryEscape(fmt.Sprintf("acct:bob@%s", b.app.federation.Domain()))
+	b.getJSON(t, "/.well-known/webfinger?resource="+resource, http.StatusOK, &finger)
+	bobURI := finger.Links[0].Href
+	if finger.Links[0].Rel != "self" || bobURI != b.app.federation.ActorURI(bob.Username) {
+		t.Fatalf("webfinger links = %+v, want the actor of bob", finger.Links)
+	}
+	b.getJSON(t, "/.well-known/webfinger?resource="+url.QueryEscape("acct:nobody@"+b.app.federation.Domain()), http.StatusNotFound, nil)
+	var actor activitypub.Actor
+	b.getJSON(t, strings.TrimPrefix(bobURI, b.server.URL), http.StatusOK, &actor)
+	if actor.ID != bobURI || actor.Inbox != bobURI+"/inbox" || actor.PublicKey.PublicKeyPEM == "" {
+		t.Fatalf("actor = %+v, want the inbox and key of bob", actor)
+	}
+	bobInbox := actor.Inbox
+
+	// Follow: bob's server fetches alice to verify the signature, records the
+	// follow and accepts it.
+	follow := a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "#follows/1", "type": "Follow", "actor": aliceURI, "object": bobURI,
+	})
+	b.waitReceived(t, "Follow")
+	accept := a.waitReceived(t, "Accept")
+	if accept.Actor != bobURI || !strings.Contains(string(accept.Object), follow) {
+		t.Errorf("accept = %+v, want bob accepting %s", accept, follow)
+	}
+	aliceOnB := b.federation.actorByURI(aliceURI)
+	if aliceOnB == nil || !b.federation.following(bob.ID, aliceOnB.ID) {
+		t.Fatal("bob's server did not record alice as a follower")
+	}
+
+	// Create delivery: bob's public post reaches alice's server, which keeps
+	// nothing since it is neither addressed to alice nor a reply to her.
+	if err := b.app.federation.PostCreated(ctx, bob, bobNote); err != nil {
+		t.Fatal(err)
+	}
+	create := a.waitReceived(t, "Create")
+	var note activitypub.Note
+	if err := json.Unmarshal(create.Object, &note); err != nil || note.ID != b.app.federation.NoteURI(bob.Username, bobNote.ID) {
+		t.Fatalf("create object = %s, want the note of the post", create.Object)
+	}
+	if a.federation.postCount() != 0 {
+		t.Error("alice's server stored a note that was not for any of its users")
+	}
+
+	// Edits of a public post are Updates, and changing whether it is public
+	// deletes or creates it again.
+	bobNote.Visibility = store.VisibilityFollowers
+	if err := b.app.federation.PostUpdated(ctx, bob, bobNote, store.VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := a.waitReceived(t, "Delete"); !strings.Contains(string(deleted.Object), note.ID) {
+		t.Errorf("delete object = %s, want the note of the post", deleted.Object)
+	}
+	bobNote.Visibility = store.VisibilityPublic
+	if err := b.app.federation.PostUpdated(ctx, bob, bobNote, store.VisibilityFollowers); err != nil {
+		t.Fatal(err)
+	}
+	a.waitReceived(t, "Create")
+	bobNote.Title, bobNote.Version = "Hello again", 1
+	if err := b.app.federation.PostUpdated(ctx, bob, bobNote, store.VisibilityPublic); err != nil {
+		t.Fatal(err)
+	}
+	update := a.waitReceived(t, "Update")
+	var updated activitypub.Note
+	if err := json.Unmarshal(update.Object, &updated); err != nil || updated.ID != note.ID || updated.Name != "Hello again" {
+		t.Errorf("update object = %s, want the edited note of the post", update.Object)
+	}
+
+	// A reply to bob's post is kept with it, an unrelated note is dropped.
+	replyURI := aliceURI + "/notes/1"
+	a.send(t, bobInbox, map[string]any{
+		"id": replyURI + "/activity", "type": "Create", "actor": aliceURI,
+		"object": map[string]any{
+			"id": replyURI, "type": "Note", "attributedTo": aliceURI, "content": "<p>Hi bob</p>",
+			"inReplyTo": note.ID, "to": []string{activitypub.Public}, "cc": []string{bobURI},
+			"published": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	b.waitReceived(t, "Create")
+	a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "/notes/2/activity", "type": "Create", "actor": aliceURI,
+		"object": map[string]any{
+			"id": aliceURI + "/notes/2", "type": "Note", "attributedTo": aliceURI, "content": "<p>Spam</p>",
+			"to": []string{activitypub.Public}, "published": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	b.waitReceived(t, "Create")
+	reply := b.federation.post(replyURI)
+	if reply == nil || reply.PostID == nil || *reply.PostID != bobNote.ID || !reply.Public {
+		t.Fatalf("reply = %+v, want a public reply to post %d", reply, bobNote.ID)
+	}
+	if b.federation.postCount() != 1 {
+		t.Errorf("bob's server kept %d notes, want only the reply", b.federation.postCount())
+	}
+
+	// Delete and Undo remove the reply and the follow.
+	a.send(t, bobInbox, map[string]any{
+		"id": replyURI + "#delete", "type": "Delete", "actor": aliceURI,
+		"object": map[string]any{"id": replyURI, "type": "Tombstone"},
+	})
+	b.waitReceived(t, "Delete")
+	if b.federation.post(replyURI) != nil {
+		t.Error("the deleted reply is still stored")
+	}
+	a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "#follows/1/undo", "type": "Undo", "actor": aliceURI, "object": follow,
+	})
+	b.waitReceived(t, "Undo")
+	if b.federation.following(bob.ID, aliceOnB.ID) {
+		t.Error("the undone follow is still recorded")
+	}
+
+	// Follows of a private account wait for approval and are only accepted then.
+	b.users.setPrivate(bob.ID, true)
+	a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "#follows/2", "type": "Follow", "actor": aliceURI, "object": bobURI,
+	})
+	b.waitReceived(t, "Follow")
+	if b.federation.following(bob.ID, aliceOnB.ID) || !b.federation.requested(bob.ID, aliceOnB.ID) {
+		t.Fatal("the follow of a private account was not held as a request")
+	}
+	if queued := b.federation.queued(); queued != 0 {
+		t.Fatalf("%d activities were queued for a pending follow, want none", queued)
+	}
+	if err := b.app.federation.ApproveFollow(ctx, bob, aliceOnB.ID); err != nil {
+		t.Fatal(err)
+	}
+	accept = a.waitReceived(t, "Accept")
+	if !strings.Contains(string(accept.Object), aliceURI+"#follows/2") || !b.federation.following(bob.ID, aliceOnB.ID) {
+		t.Errorf("approving did not accept the pending follow: %s", accept.Object)
+	}
+
+	// Going public accepts the follows still waiting.
+	a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "#follows/2/undo", "type": "Undo", "actor": aliceURI,
+		"object": map[string]any{"id": aliceURI + "#follows/2", "type": "Follow", "actor": aliceURI, "object": bobURI},
+	})
+	b.waitReceived(t, "Undo")
+	a.send(t, bobInbox, map[string]any{
+		"id": aliceURI + "#follows/3", "type": "Follow", "actor": aliceURI, "object": bobURI,
+	})
+	b.waitReceived(t, "Follow")
+	if !b.federation.requested(bob.ID, aliceOnB.ID) {
+		t.Fatal("the follow of a private account was not held as a request")
+	}
+	b.users.setPrivate(bob.ID, false)
+	if err := b.app.federation.ApproveAllFollows(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	accept = a.waitReceived(t, "Accept")
+	if !strings.Contains(string(accept.Object), aliceURI+"#follows/3") || !b.federation.following(bob.ID, aliceOnB.ID) {
+		t.Errorf("going public did not accept the pending follow: %s", accept.Object)
+	}
+
+	t.Run("signature rejection", func(t *testing.T) {
+		key := a.federation.key(alice.ID)
+		keyID := aliceURI + "#main-key"
+		body := []byte(fmt.Sprintf(`{"id":%q,"type":"Like","actor":%q,"object":%q}`, aliceURI+"#likes/1", aliceURI, note.ID))
+		post := func(req *http.Request) int {
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if status := post(signedRequest(t, key, keyID, bobInbox, body, signedHeaders)); status != http.StatusAccepted {
+			t.Fatalf("correctly signed request: status = %d, want 202", status)
+		}
+		unsigned, _ := http.NewRequest(http.MethodPost, bobInbox, bytes.NewReader(body))
+		unsigned.Header.Set("Content-Type", activitypub.ContentType)
+		if status := post(unsigned); status != http.StatusUnauthorized {
+			t.Errorf("unsigned request: status = %d, want 401", status)
+		}
+		tampered := signedRequest(t, key, keyID, bobInbox, body, signedHeaders)
+		tampered.Body = io.NopCloser(bytes.NewReader(bytes.Replace(body, []byte("Like"), []byte("Flag"), 1)))
+		if status := post(tampered); status != http.StatusUnauthorized {
+			t.Errorf("body changed after signing: status = %d, want 401", status)
+		}
+		for _, headers := range [][]string{
+			{httpsig.RequestTarget, "host", "date"},
+			{httpsig.RequestTarget, "date", "digest"},
+			{"host", "date", "digest"},
+		} {
+			if status := post(signedRequest(t, key, keyID, bobInbox, body, headers)); status != http.StatusUnauthorized {
+				t.Errorf("signature over %v: status = %d, want 401", headers, status)
+			}
+		}
+		otherInbox := strings.Replace(bobInbox, "/bob/", "/carol/", 1)
+		replayed := signedRequest(t, key, keyID, otherInbox, body, signedHeaders)
+		replayed.URL, _ = url.Parse(bobInbox)
+		if status := post(replayed); status != http.StatusUnauthorized {
+			t.Errorf("signature made for another inbox: status = %d, want 401", status)
+		}
+		forged := []byte(fmt.Sprintf(`{"id":"x","type":"Follow","actor":%q,"object":%q}`, bobURI, bobURI))
+		if status := post(signedRequest(t, key, keyID, bobInbox, forged, signedHeaders)); status != http.StatusForbidden {
+			t.Errorf("activity of another actor: status = %d, want 403", status)
+		}
+	})
+}
+
+var signedHeaders = []string{httpsig.RequestTarget, "host", "date", "digest"}
+
+// signedRequest signs a POST of body to inbox over headers the way remote
+// servers do.
+func signedRequest(t *testing.T, key *store.ActorKey, keyID, inbox string, body []byte, headers []string) *http.Request {
+	t.Helper()
+	block, _ := pem.Decode([]byte(key.PrivateKeyPEM))
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", activitypub.ContentType)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+	sum := sha256.Sum256(body)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	signer, _, err := httpsig.NewSigner([]httpsig.Algorithm{httpsig.RSA_SHA256}, httpsig.DigestSha256, headers, httpsig.Signature, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A nil body keeps the Digest set above, which signers skip when it
+	// is not among the signed headers.
+	if err := signer.SignRequest(private, keyID, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// instance is one server of the federation test.
+type instance struct {
+	server     *httptest.Server
+	app        *application
+	user       *store.User
+	users      *memUsers
+	posts      *memPosts
+	federation *memFederation
+
+	mu       sync.Mutex
+	received []activitypub.Activity
+	seen     int
+}
+
+func newInstance(t *testing.T, ctx context.Context, username string) *instance {
+	t.Helper()
+	inst := &instance{
+		user:       &store.User{ID: 1, Username: username, CreatedAt: "2024-01-01T00:00:00Z"},
+		users:      &memUsers{private: map[int64]bool{}},
+		posts:      &memPosts{posts: map[int64]*store.Post{}},
+		federation: newMemFederation(),
+	}
+	inst.users.users = []*store.User{inst.user}
+	inst.server = httptest.NewUnstartedServer(nil)
+	baseURL := "http://" + inst.server.Listener.Addr().String()
+
+	logger := zap.NewNop().Sugar()
+	storage := &store.Storage{Users: inst.users, Posts: inst.posts, Federation: inst.federation}
+	federation, err := activitypub.NewService(activitypub.Config{
+		BaseURL:              baseURL,
+		AllowPrivateNetworks: true,
+		Timeout:              5 * time.Second,
+	}, storage, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inst.app = &application{
+		config: config{
+			frontendURL: "https://" + username + ".example",
+			rateLimiter: rateLimiter.Config{Enabled: true},
+		},
+		store:       storage,
+		logger:      logger,
+		rateLimiter: allowAll{},
+		federation:  federation,
+	}
+	inst.server.Config.Handler = inst.recordInbox(inst.app.mount())
+	inst.server.Start()
+	t.Cleanup(inst.server.Close)
+	go federation.RunDeliveries(ctx, 10*time.Millisecond)
+	return inst
+}
+
+func (inst *instance) addPost(post *store.Post) *store.Post {
+	post.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	post.UpdatedAt = post.CreatedAt
+	inst.posts.posts[post.ID] = post
+	return post
+}
+
+// send queues activity for delivery from the instance's user to inbox and
+// returns its ID.
+func (inst *instance) send(t *testing.T, inbox string, activity map[string]any) string {
+	t.Helper()
+	payload, err := json.Marshal(activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.federation.EnqueueDeliveries(context.Background(), inst.user.ID, []string{inbox}, payload); err != nil {
+		t.Fatal(err)
+	}
+	return activity["id"].(string)
+}
+
+// recordInbox keeps the activities the instance accepted into its inboxes.
+func (inst *instance) recordInbox(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/inbox") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		var activity activitypub.Activity
+		if recorder.status == http.StatusAccepted && json.Unmarshal(body, &activity) == nil {
+			inst.mu.Lock()
+			inst.received = append(inst.received, activity)
+			inst.mu.Unlock()
+		}
+	})
+}
+
+// waitReceived waits for the next accepted activity and checks its type.
+func (inst *instance) waitReceived(t *testing.T, activityType string) activitypub.Activity {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		inst.mu.Lock()
+		if inst.seen < len(inst.received) {
+			activity := inst.received[inst.seen]
+			inst.seen++
+			inst.mu.Unlock()
+			if activity.Type != activityType {
+				t.Fatalf("received %s activity %s, want %s", activity.Type, activity.ID, activityType)
+			}
+			return activity
+		}
+		inst.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no %s activity received, delivery errors: %v", activityType, inst.federation.errors())
+	return activitypub.Activity{}
+}
+
+func (inst *instance) getJSON(t *testing.T, path string, status int, v any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, inst.server.URL+path, nil)
+	req.Header.Set("Accept", activitypub.ContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("GET %s: status = %d, want %d", path, resp.StatusCode, status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+type allowAll struct{}
+
+func (allowAll) Allow(string) (bool, time.Duration) { return true, 0 }
+
+// The in-memory stores embed the SQL ones for the methods federation never calls.
+
+type memUsers struct {
+	*store.UserStore
+	mu      sync.Mutex
+	users   []*store.User
+	private map[int64]bool
+}
+
+func (m *memUsers) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	for _, user := range m.users {
+		if user.ID == id {
+			return user, nil
+		}
+	}
+	return nil, store.ErrorNotFound
+}
+
+func (m *memUsers) GetByUsername(ctx context.Context, username string) (*store.User, error) {
+	for _, user := range m.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, store.ErrorNotFound
+}
+
+func (m *memUsers) GetSettings(ctx context.Context, userID int64) (*store.UserSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return &store.UserSettings{DMPolicy: "everyone", IsPrivate: m.private[userID]}, nil
+}
+
+func (m *memUsers) setPrivate(userID int64, private bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.private[userID] = private
+}
+
+type memPosts struct {
+	*store.PostStore
+	posts map[int64]*store.Post
+}
+
+func (m *memPosts) GetByID(ctx context.Context, id int64, viewerID int64) (*store.Post, error) {
+	post, ok := m.posts[id]
+	if !ok || (post.Visibility != store.VisibilityPublic && post.UserID != viewerID) {
+		return nil, store.ErrorNotFound
+	}
+	return post, nil
+}
+
+type memFederation struct {
+	*store.FederationStore
+	mu         sync.Mutex
+	keys       map[int64]*store.ActorKey
+	actors     []*store.RemoteActor
+	followers  map[[2]int64]string
+	requests   map[[2]int64]string
+	posts      map[string]*store.RemotePost
+	deliveries []store.Delivery
+	failures   []string
+}
+
+func newMemFederation() *memFederation {
+	return &memFederation{
+		keys:      map[int64]*store.ActorKey{},
+		followers: map[[2]int64]string{},
+		requests:  map[[2]int64]string{},
+		posts:     map[string]*store.RemotePost{},
+	}
+}
+
+func (m *memFederation) GetActorKey(ctx context.Context, userID int64) (*store.ActorKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.keys[userID]; ok {
+		return key, nil
+	}
+	return nil, store.ErrorNotFound
+}
+
+func (m *memFederation) CreateActorKey(ctx context.Context, key *store.ActorKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.keys[key.UserID]; !ok {
+		m.keys[key.UserID] = key
+	}
+	return nil
+}
+
+func (m *memFederation) key(userID int64) *store.ActorKey {
+	key, _ := m.GetActorKey(context.Background(), userID)
+	return key
+}
+
+func (m *memFederation) UpsertRemoteActor(ctx context.Context, actor *store.RemoteActor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	actor.FetchedAt = time.Now()
+	for i, stored := range m.actors {
+		if stored.URI == actor.URI {
+			actor.ID = stored.ID
+			m.actors[i] = actor
+			return nil
+		}
+	}
+	actor.ID = int64(len(m.actors) + 1)
+	m.actors = append(m.actors, actor)
+	return nil
+}
+
+func (m *memFederation) GetRemoteActorByKeyID(ctx context.Context, keyID string) (*store.RemoteActor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, actor := range m.actors {
+		if actor.KeyID == keyID {
+			return actor, nil
+		}
+	}
+	return nil, store.ErrorNotFound
+}
+
+func (m *memFederation) actorByURI(uri string) *store.RemoteActor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, actor := range m.actors {
+		if actor.URI == uri {
+			return actor
+		}
+	}
+	return nil
+}
+
+func (m *memFederation) AddRemoteFollower(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.followers[[2]int64{userID, remoteActorID}] = followActivityID
+	return nil
+}
+
+func (m *memFederation) RemoveRemoteFollower(ctx context.Context, userID int64, remoteActorID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.followers, [2]int64{userID, remoteActorID})
+	delete(m.requests, [2]int64{userID, remoteActorID})
+	return nil
+}
+
+func (m *memFederation) RequestRemoteFollow(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pair := [2]int64{userID, remoteActorID}
+	if _, ok := m.followers[pair]; ok {
+		m.followers[pair] = followActivityID
+		return false, nil
+	}
+	m.requests[pair] = followActivityID
+	return true, nil
+}
+
+func (m *memFederation) ApproveRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*store.RemoteFollowRequest, error) {
+	request, err := m.RejectRemoteFollowRequest(ctx, userID, remoteActorID)
+	if err != nil {
+		return nil, err
+	}
+	return request, m.AddRemoteFollower(ctx, userID, remoteActorID, request.FollowActivityID)
+}
+
+func (m *memFederation) ApproveAllRemoteFollowRequests(ctx context.Context, userID int64) ([]store.RemoteFollowRequest, error) {
+	m.mu.Lock()
+	var remoteActorIDs []int64
+	for pair := range m.requests {
+		if pair[0] == userID {
+			remoteActorIDs = append(remoteActorIDs, pair[1])
+		}
+	}
+	m.mu.Unlock()
+	requests := []store.RemoteFollowRequest{}
+	for _, remoteActorID := range remoteActorIDs {
+		request, err := m.ApproveRemoteFollowRequest(ctx, userID, remoteActorID)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *request)
+	}
+	return requests, nil
+}
+
+func (m *memFederation) RejectRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*store.RemoteFollowRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pair := [2]int64{userID, remoteActorID}
+	followID, ok := m.requests[pair]
+	if !ok {
+		return nil, store.ErrorNotFound
+	}
+	delete(m.requests, pair)
+	actor := m.actors[remoteActorID-1]
+	return &store.RemoteFollowRequest{
+		RemoteActorID:     actor.ID,
+		URI:               actor.URI,
+		PreferredUsername: actor.PreferredUsername,
+		Inbox:             actor.Inbox,
+		FollowActivityID:  followID,
+	}, nil
+}
+
+func (m *memFederation) following(userID, remoteActorID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.followers[[2]int64{userID, remoteActorID}]
+	return ok
+}
+
+func (m *memFederation) requested(userID, remoteActorID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.requests[[2]int64{userID, remoteActorID}]
+	return ok
+}
+
+func (m *memFederation) GetRemoteFollowerInboxes(ctx context.Context, userID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inboxes := []string{}
+	for pair := range m.followers {
+		if pair[0] == userID {
+			inboxes = append(inboxes, m.actors[pair[1]-1].Inbox)
+		}
+	}
+	return inboxes, nil
+}
+
+func (m *memFederation) CreateRemotePost(ctx context.Context, post *store.RemotePost, recipientIDs []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.posts[post.URI]; !ok {
+		m.posts[post.URI] = post
+	}
+	return nil
+}
+
+func (m *memFederation) DeleteRemotePost(ctx context.Context, remoteActorID int64, uri string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if post, ok := m.posts[uri]; ok && post.RemoteActorID == remoteActorID {
+		delete(m.posts, uri)
+	}
+	return nil
+}
+
+func (m *memFederation) post(uri string) *store.RemotePost {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.posts[uri]
+}
+
+func (m *memFederation) postCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.posts)
+}
+
+func (m *memFederation) EnqueueDeliveries(ctx context.Context, userID int64, inboxes []string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inbox := range inboxes {
+		m.deliveries = append(m.deliveries, store.Delivery{ID: time.Now().UnixNano(), UserID: userID, Inbox: inbox, Payload: payload})
+	}
+	return nil
+}
+
+func (m *memFederation) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]store.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	claimed := m.deliveries
+	m.deliveries = nil
+	return claimed, nil
+}
+
+func (m *memFederation) CompleteDelivery(ctx context.Context, id int64) error {
+	return nil
+}
+
+// RetryDelivery gives up at once: every delivery between the two instances
+// is expected to succeed the first time.
+func (m *memFederation) RetryDelivery(ctx context.Context, id int64, nextAttempt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = append(m.failures, lastError)
+	return nil
+}
+
+func (m *memFederation) queued() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.deliveries)
+}
+
+func (m *memFederation) errors() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failures
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"AwesomeProject/internal/analytics"
 	"AwesomeProject/internal/store"
 	"net/http"
 )
@@ -8,9 +9,9 @@ import (
 func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Request) {
 	// pagination, search, filters, sort
 	fq := store.PaginatedFeedQuery{
-		Limit:  20,
-		Offset: 0,
-		Sort:   "desc",
+		Limit: 20,
+		Sort:  "desc",
+		Mode:  store.FeedModeChronological,
 	}
 
 	fq, err := fq.Parse(r)
@@ -24,12 +25,35 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	ctx := r.Context()
-	posts, err := app.store.Posts.GetUserFeed(ctx, int64(101), fq)
+	viewer := getUserFromContext(r)
+	var (
+		posts []store.PostWithMetadata
+		page  store.PageInfo
+	)
+	switch fq.Mode {
+	case store.FeedModeRanked:
+		posts, page, err = app.store.Posts.GetRankedFeed(ctx, viewer.ID, fq, app.config.feed.ranking)
+	default:
+		if app.timelines != nil {
+			posts, page, err = app.timelines.Feed(ctx, viewer.ID, fq)
+		} else {
+			posts, page, err = app.store.Posts.GetUserFeed(ctx, viewer.ID, fq)
+		}
+	}
 	if err != nil {
 		app.internalServerErrorHandler(w, r, err)
 		return
 	}
-	if err := app.jsonResponse(w, http.StatusOK, posts); err != nil {
+	feedPosts := make([]*store.Post, len(posts))
+	for i := range posts {
+		feedPosts[i] = &posts[i].Post
+	}
+	if err := app.attachPostDetails(ctx, feedPosts, viewer.ID); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.recordPostViews(ctx, analytics.KindImpression, viewer.ID, feedPosts...)
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, posts, page); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 	}
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"AwesomeProject/internal/analytics"
+	"AwesomeProject/internal/store"
+	"context"
+	"time"
+)
+
+// runPurgeJob hard deletes posts and comments whose restore window has passed.
+func (app *application) runPurgeJob(ctx context.Context) {
+	ticker := time.NewTicker(app.config.softDelete.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		deletedBefore := time.Now().Add(-app.config.softDelete.restoreWindow)
+		posts, err := app.store.Posts.Purge(ctx, deletedBefore)
+		if err != nil {
+			app.logger.Errorw("failed to purge posts", "error", err.Error())
+		}
+		comments, err := app.store.Comments.Purge(ctx, deletedBefore)
+		if err != nil {
+			app.logger.Errorw("failed to purge comments", "error", err.Error())
+		}
+		if posts > 0 || comments > 0 {
+			app.logger.Infof("Purged %d posts and %d comments", posts, comments)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runViewsFlushJob moves buffered view counters to Postgres in batches.
+func (app *application) runViewsFlushJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		counters, err := app.views.Drain(ctx)
+		if err != nil {
+			app.logger.Errorw("failed to drain view counters", "error", err.Error())
+			continue
+		}
+		byPostDay := map[analytics.Key]*store.PostViewCount{}
+		for key, count := range counters {
+			dayKey := analytics.Key{PostID: key.PostID, Day: key.Day}
+			c, ok := byPostDay[dayKey]
+			if !ok {
+				c = &store.PostViewCount{PostID: key.PostID, Day: key.Day}
+				byPostDay[dayKey] = c
+			}
+			switch key.Kind {
+			case analytics.KindImpression:
+				c.Impressions += count
+			case analytics.KindView:
+				c.Views += count
+			}
+		}
+		batch := make([]store.PostViewCount, 0, len(byPostDay))
+		for _, c := range byPostDay {
+			batch = append(batch, *c)
+		}
+		if err := app.store.Analytics.AddPostViews(ctx, batch); err != nil {
+			app.logger.Errorw("failed to flush view counters", "counters", len(batch), "error", err.Error())
+			// The batch is written in one transaction, so putting the counters back
+			// retries them on the next tick without counting anything twice.
+			if err := app.views.Restore(ctx, counters); err != nil {
+				app.logger.Errorw("failed to restore view counters, dropping them", "counters", len(counters), "error", err.Error())
+			}
+		}
+	}
+}
+
+// runExploreJob recomputes the explore hot scores and forgets what users saw
+// longer ago than the seen window.
+func (app *application) runExploreJob(ctx context.Context) {
+	ticker := time.NewTicker(app.config.explore.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		scored, err := app.store.Explore.RefreshScores(ctx, app.config.explore.scoring)
+		if err != nil {
+			app.logger.Errorw("failed to refresh explore scores", "error", err.Error())
+		} else {
+			app.logger.Infof("Scored %d posts for explore", scored)
+		}
+		if _, err := app.store.Explore.PurgeSeen(ctx, time.Now().Add(-app.config.explore.seenWindow)); err != nil {
+			app.logger.Errorw("failed to purge seen posts", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSuggestionsJob recomputes the who-to-follow suggestions of every user.
+func (app *application) runSuggestionsJob(ctx context.Context) {
+	ticker := time.NewTicker(app.config.suggestions.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		stored, err := app.store.Suggestions.RefreshSuggestions(ctx, app.config.suggestions.scoring)
+		if err != nil {
+			app.logger.Errorw("failed to refresh suggestions", "error", err.Error())
+		} else {
+			app.logger.Infof("Stored %d follow suggestions", stored)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"AwesomeProject/internal/store"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
@@ -28,10 +30,16 @@ func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
 }
 
 func writeJSONError(w http.ResponseWriter, status int, message string) error {
+	return writeJSONErrorCode(w, status, message, "")
+}
+
+// writeJSONErrorCode adds a stable machine-readable code next to the message.
+func writeJSONErrorCode(w http.ResponseWriter, status int, message string, code string) error {
 	type envelope struct {
 		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
 	}
-	return writeJSON(w, status, &envelope{Error: message})
+	return writeJSON(w, status, &envelope{Error: message, Code: code})
 }
 
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
@@ -40,3 +48,30 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	}
 	return writeJSON(w, status, &envelope{Data: data})
 }
+
+// paginatedJSONResponse writes a page of a cursor paginated list with its
+// neighbour cursors in the envelope and as RFC 8288 Link header.
+func (app *application) paginatedJSONResponse(w http.ResponseWriter, r *http.Request, status int, data any, page store.PageInfo) error {
+	type envelope struct {
+		Data       any    `json:"data"`
+		NextCursor string `json:"next_cursor"`
+		PrevCursor string `json:"prev_cursor"`
+	}
+	links := []string{}
+	if page.NextCursor != "" {
+		links = append(links, pageLink(r, page.NextCursor, "next"))
+	}
+	if page.PrevCursor != "" {
+		links = append(links, pageLink(r, page.PrevCursor, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+	return writeJSON(w, status, &envelope{Data: data, NextCursor: page.NextCursor, PrevCursor: page.PrevCursor})
+}
+
+func pageLink(r *http.Request, cursor string, rel string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	return "<" + r.URL.Path + "?" + query.Encode() + `>; rel="` + rel + `"`
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"AwesomeProject/internal/activitypub"
+	"AwesomeProject/internal/analytics"
 	"AwesomeProject/internal/auth"
 	"AwesomeProject/internal/db"
 	"AwesomeProject/internal/env"
 	"AwesomeProject/internal/mailer"
+	"AwesomeProject/internal/notifications"
 	"AwesomeProject/internal/rateLimiter"
+	"AwesomeProject/internal/realtime"
 	"AwesomeProject/internal/store"
 	"AwesomeProject/internal/store/cache"
+	"AwesomeProject/internal/timeline"
+	"AwesomeProject/internal/unfurl"
+	"context"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -82,6 +89,51 @@ func main() {
 			TimeFrame:            time.Second * 5,
 			Enabled:              true,
 		},
+		softDelete: softDeleteConfig{
+			restoreWindow: time.Hour * 24 * time.Duration(env.GetInt("RESTORE_WINDOW_DAYS", 30)),
+			purgeInterval: time.Hour,
+		},
+		feed: feedConfig{
+			ranking: store.FeedRanking{
+				RecencyWeight:    env.GetFloat("FEED_RANK_RECENCY_WEIGHT", 1),
+				EngagementWeight: env.GetFloat("FEED_RANK_ENGAGEMENT_WEIGHT", 0.3),
+				AffinityWeight:   env.GetFloat("FEED_RANK_AFFINITY_WEIGHT", 0.5),
+				HalfLife:         time.Hour * time.Duration(env.GetPositiveInt("FEED_RANK_HALF_LIFE_HOURS", 12)),
+				Window:           time.Hour * 24 * time.Duration(env.GetPositiveInt("FEED_RANK_WINDOW_DAYS", 7)),
+			},
+			timeline: timeline.Config{
+				MaxLength:          env.GetInt("TIMELINE_MAX_LENGTH", timeline.DefaultMaxLength),
+				CelebrityThreshold: int64(env.GetInt("TIMELINE_CELEBRITY_THRESHOLD", timeline.DefaultCelebrityThreshold)),
+				CelebrityRefresh:   time.Minute * time.Duration(env.GetPositiveInt("TIMELINE_CELEBRITY_REFRESH_MINUTES", 10)),
+				TTL:                timeline.DefaultTTL,
+			},
+		},
+		explore: exploreConfig{
+			scoring: store.HotScoring{
+				Gravity:    env.GetFloat("EXPLORE_GRAVITY", 1.8),
+				ViewWeight: env.GetFloat("EXPLORE_VIEW_WEIGHT", 0.1),
+				Window:     time.Hour * 24 * 3,
+			},
+			refreshInterval: time.Minute * time.Duration(env.GetPositiveInt("EXPLORE_REFRESH_MINUTES", 5)),
+			seenWindow:      time.Hour * 24 * 3,
+		},
+		suggestions: suggestionsConfig{
+			scoring: store.SuggestionScoring{
+				MutualWeight:    env.GetFloat("SUGGESTIONS_MUTUAL_WEIGHT", 1),
+				TagWeight:       env.GetFloat("SUGGESTIONS_TAG_WEIGHT", 0.5),
+				PopularWeight:   env.GetFloat("SUGGESTIONS_POPULAR_WEIGHT", 0.2),
+				Window:          time.Hour * 24 * 30,
+				PopularAccounts: 50,
+				PerUser:         100,
+			},
+			refreshInterval: time.Minute * time.Duration(env.GetPositiveInt("SUGGESTIONS_REFRESH_MINUTES", 60)),
+		},
+		federation: federationConfig{
+			enabled:              env.GetBool("FEDERATION_ENABLED", false),
+			baseURL:              env.GetString("FEDERATION_BASE_URL", "http://localhost:8080"),
+			allowPrivateNetworks: env.GetBool("FEDERATION_ALLOW_PRIVATE_NETWORKS", false),
+			deliveryInterval:     time.Second * 5,
+		},
 	}
 	logger := zap.Must(zap.NewProduction()).Sugar()
 	defer logger.Sync()
@@ -112,15 +164,59 @@ func main() {
 
 	jwtAuthenticator := auth.NewJWTAuthenticator(cfg.auth.token.secret, cfg.auth.token.iss, cfg.auth.token.iss)
 	_rateLimiter := rateLimiter.NewFixedWindowRateLimiter(cfg.rateLimiter.RequestsPerTimeFrame, cfg.rateLimiter.TimeFrame)
+
+	var (
+		redisCache  *cache.Storage
+		viewsBuffer analytics.Buffer = analytics.NewMemoryBuffer()
+		timelines   *timeline.Service
+		events      realtime.Broker = realtime.NewMemoryBroker()
+	)
+	if cfg.redis.enabled {
+		redisCache = &cacheStorage
+		viewsBuffer = analytics.NewRedisBuffer(rdb)
+		timelines = timeline.NewService(rdb, &_store, cfg.feed.timeline, logger)
+		redisEvents := realtime.NewRedisBroker(rdb)
+		go redisEvents.Run(context.Background())
+		events = redisEvents
+	}
+	var federation *activitypub.Service
+	if cfg.federation.enabled {
+		federation, err = activitypub.NewService(activitypub.Config{
+			BaseURL:              cfg.federation.baseURL,
+			AllowPrivateNetworks: cfg.federation.allowPrivateNetworks,
+		}, &_store, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	previewWorker := unfurl.NewWorker(unfurl.NewFetcher(unfurl.Config{}), &_store, redisCache, logger, 1000)
 	app := &application{
-		config:       cfg,
-		store:        &_store,
-		cacheStorage: &cacheStorage,
-		logger:       logger,
-		mailer:       mailer,
-		auth:         jwtAuthenticator,
-		rateLimiter:  _rateLimiter,
+		config:        cfg,
+		store:         &_store,
+		cacheStorage:  &cacheStorage,
+		logger:        logger,
+		mailer:        mailer,
+		auth:          jwtAuthenticator,
+		rateLimiter:   _rateLimiter,
+		previews:      previewWorker,
+		views:         viewsBuffer,
+		timelines:     timelines,
+		federation:    federation,
+		events:        events,
+		notifications: notifications.NewService(&_store, redisCache, events, logger),
+	}
+	go app.runPurgeJob(context.Background())
+	go previewWorker.Run(context.Background(), 4)
+	if timelines != nil {
+		go timelines.Run(context.Background(), 4)
 	}
+	go app.runViewsFlushJob(context.Background(), 30*time.Second)
+	go app.runExploreJob(context.Background())
+	go app.runSuggestionsJob(context.Background())
+	if federation != nil {
+		go federation.RunDeliveries(context.Background(), cfg.federation.deliveryInterval)
+	}
+
 	mux := app.mount()
 	logger.Fatal(app.run(mux))
 }
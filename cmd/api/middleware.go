@@ -51,18 +51,61 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 }
 
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return app.tokenAuth(next, bearerToken, false)
+}
+
+type accessTokenKey string
+
+const accessTokenCtxKey accessTokenKey = "accessToken"
+
+// StripAccessTokenMiddleware moves the access_token query parameter into the
+// request context. It runs before the request logger so tokens never reach the
+// access logs.
+func (app *application) StripAccessTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, errors.New("empty authorization header"))
-			return
+		query := r.URL.Query()
+		if query.Has("access_token") {
+			token := query.Get("access_token")
+			query.Del("access_token")
+			r.URL.RawQuery = query.Encode()
+			r.RequestURI = r.URL.RequestURI()
+			r = r.WithContext(context.WithValue(r.Context(), accessTokenCtxKey, token))
 		}
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, errors.New("authorization header format must be Bearer"))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StreamAuthMiddleware also takes the token from the access_token query parameter,
+// as browsers cannot set headers on EventSource and WebSocket requests. The
+// request context ends when the token expires, closing the stream with it.
+func (app *application) StreamAuthMiddleware(next http.Handler) http.Handler {
+	return app.tokenAuth(next, func(r *http.Request) (string, error) {
+		if token, _ := r.Context().Value(accessTokenCtxKey).(string); token != "" {
+			return token, nil
+		}
+		return bearerToken(r)
+	}, true)
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", errors.New("empty authorization header")
+	}
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", errors.New("authorization header format must be Bearer")
+	}
+	return parts[1], nil
+}
+
+func (app *application) tokenAuth(next http.Handler, tokenFrom func(r *http.Request) (string, error), untilExpiry bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := tokenFrom(r)
+		if err != nil {
+			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
-		token := parts[1]
 		jwtToken, err := app.auth.ValidateToken(token)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
@@ -72,8 +115,19 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
+			return
 		}
 		ctx := r.Context()
+		if untilExpiry {
+			expiresAt, err := claims.GetExpirationTime()
+			if err != nil || expiresAt == nil {
+				app.unauthorizedErrorResponse(w, r, errors.New("token has no expiration time"))
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, expiresAt.Time)
+			defer cancel()
+		}
 		user, err := app.getUser(ctx, userID)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
@@ -84,7 +138,7 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		app.logger.Infof("User ID: %d, Username: %s", userID, user.Username)
-		ctx = context.WithValue(r.Context(), userCtxKey, user)
+		ctx = context.WithValue(ctx, userCtxKey, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -130,7 +184,39 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 		}
 
 		// check roles
-		allowed, err := app.checkRolePrecedence(r.Context(), user, requiredRole)
+		allowed, err := app.checkModeratorPrecedence(r.Context(), user, post, requiredRole)
+		if err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+		if !allowed {
+			app.methodNotAllowedResponse(w, r, errors.New("user not allowed"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) checkCommentOwnership(requiredRole string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+		comment := getCommentFromContext(r)
+
+		if comment == nil {
+			app.badRequestResponse(w, r, errors.New("comment not found"))
+			return
+		}
+		if user == nil {
+			app.badRequestResponse(w, r, errors.New("user not found"))
+			return
+		}
+		if comment.UserID == user.ID {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, err := app.checkModeratorPrecedence(r.Context(), user, getPostFromContext(r), requiredRole)
 		if err != nil {
 			app.internalServerErrorHandler(w, r, err)
 			return
@@ -144,6 +230,17 @@ func (app *application) checkPostOwnership(requiredRole string, next http.Handle
 	})
 }
 
+// checkModeratorPrecedence reports whether user may act on post, or on one of its
+// comments, with requiredRole: globally, or through their role in the
+// community the post was published into.
+func (app *application) checkModeratorPrecedence(ctx context.Context, user *store.User, post *store.Post, requiredRole string) (bool, error) {
+	allowed, err := app.checkRolePrecedence(ctx, user, requiredRole)
+	if err != nil || allowed || post == nil || post.CommunityID == nil {
+		return allowed, err
+	}
+	return app.checkCommunityPrecedence(ctx, user, *post.CommunityID, requiredRole)
+}
+
 func (app *application) checkRolePrecedence(ctx context.Context, user *store.User, roleName string) (bool, error) {
 	role, err := app.store.Roles.GetByName(ctx, roleName)
 	if err != nil {
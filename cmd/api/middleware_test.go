@@ -0,0 +1,69 @@
+package main
+
+import (
+	"AwesomeProject/internal/auth"
+	"AwesomeProject/internal/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+func TestStreamAccessToken(t *testing.T) {
+	authenticator := auth.NewJWTAuthenticator("secret", "gophersocial", "gophersocial")
+	app := &application{
+		logger: zap.NewNop().Sugar(),
+		auth:   authenticator,
+		store: &store.Storage{
+			Users: &memUsers{users: []*store.User{{ID: 1, Username: "gopher"}}},
+		},
+	}
+	token := func(expiresAt time.Time) string {
+		signed, err := authenticator.GenerateToken(jwt.MapClaims{
+			"sub": 1,
+			"exp": expiresAt.Unix(),
+			"aud": "gophersocial",
+			"iss": "gophersocial",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	var loggedURI string
+	var deadline time.Time
+	handler := app.StripAccessTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stands in for middleware.Logger, which logs r.RequestURI.
+		loggedURI = r.RequestURI
+		app.StreamAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, _ = r.Context().Deadline()
+			if getUserFromContext(r).ID != 1 {
+				t.Errorf("user = %+v, want the token subject", getUserFromContext(r))
+			}
+		})).ServeHTTP(w, r)
+	}))
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/stream?posts=1,2&access_token="+token(expiresAt), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if strings.Contains(loggedURI, "access_token") || !strings.Contains(loggedURI, "posts=1%2C2") {
+		t.Errorf("logged URI = %q, want the posts parameter without the token", loggedURI)
+	}
+	if !deadline.Equal(expiresAt) {
+		t.Errorf("stream deadline = %v, want the token expiry %v", deadline, expiresAt)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/stream?access_token="+token(time.Now().Add(-time.Minute)), nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expired token status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"AwesomeProject/internal/store"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type CreatePollPayload struct {
+	Options        []string  `json:"options" validate:"required,min=2,max=10,dive,required,max=100"`
+	MultipleChoice bool      `json:"multiple_choice"`
+	HideResults    bool      `json:"hide_results"`
+	ClosesAt       time.Time `json:"closes_at" validate:"required"`
+}
+
+type VotePollPayload struct {
+	OptionIDs []int64 `json:"option_ids" validate:"required,min=1,max=10,dive,gte=1"`
+}
+
+func (app *application) votePollHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VotePollPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	ctx := r.Context()
+	if err := app.store.Polls.Vote(ctx, post.ID, user.ID, payload.OptionIDs); err != nil {
+		app.pollErrorResponse(w, r, err)
+		return
+	}
+	app.pollResponse(w, r, post, user.ID)
+}
+
+func (app *application) unvotePollHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	if err := app.store.Polls.Unvote(r.Context(), post.ID, user.ID); err != nil {
+		app.pollErrorResponse(w, r, err)
+		return
+	}
+	app.pollResponse(w, r, post, user.ID)
+}
+
+func (app *application) pollResponse(w http.ResponseWriter, r *http.Request, post *store.Post, viewerID int64) {
+	polls, err := app.store.Polls.GetByPostIDs(r.Context(), []int64{post.ID}, viewerID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, polls[post.ID]); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) pollErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrPollClosed), errors.Is(err, store.ErrAlreadyVoted):
+		app.conflictResponse(w, r, err)
+	case errors.Is(err, store.ErrInvalidPollOption):
+		app.badRequestResponse(w, r, err)
+	default:
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
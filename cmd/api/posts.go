@@ -1,11 +1,13 @@
 package main
 
 import (
+	"AwesomeProject/internal/analytics"
 	"AwesomeProject/internal/store"
 	"context"
 	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -15,9 +17,12 @@ type postKey string
 const postCtx postKey = "post"
 
 type CreatePostPayload struct {
-	Title   string   `json:"title" validate:"required,max=255"`
-	Content string   `json:"content" validate:"required,max=1000"`
-	Tags    []string `json:"tags"`
+	Title       string             `json:"title" validate:"required,max=255"`
+	Content     string             `json:"content" validate:"required,max=10000"`
+	Tags        []string           `json:"tags"`
+	Visibility  string             `json:"visibility" validate:"omitempty,oneof=public followers mentioned"`
+	Poll        *CreatePollPayload `json:"poll"`
+	CommunityID *int64             `json:"community_id" validate:"omitempty,gte=1"`
 }
 
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
@@ -33,16 +38,50 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 
 	user := getUserFromContext(r)
 	post := &store.Post{
-		Title:   payload.Title,
-		Content: payload.Content,
-		UserID:  user.ID,
-		Tags:    payload.Tags,
+		Title:       payload.Title,
+		Content:     payload.Content,
+		UserID:      user.ID,
+		Tags:        payload.Tags,
+		Visibility:  payload.Visibility,
+		CommunityID: payload.CommunityID,
+	}
+	if payload.Poll != nil {
+		if !payload.Poll.ClosesAt.After(time.Now()) {
+			app.badRequestResponse(w, r, errors.New("poll closes_at must be in the future"))
+			return
+		}
+		post.Poll = &store.Poll{
+			MultipleChoice: payload.Poll.MultipleChoice,
+			HideResults:    payload.Poll.HideResults,
+			ClosesAt:       payload.Poll.ClosesAt,
+		}
+		for _, text := range payload.Poll.Options {
+			post.Poll.Options = append(post.Poll.Options, store.PollOption{Text: text})
+		}
 	}
 	ctx := r.Context()
+	if post.CommunityID != nil {
+		if err := app.store.Communities.CheckCanPost(ctx, *post.CommunityID, user.ID); err != nil {
+			app.communityErrorResponse(w, r, err)
+			return
+		}
+	}
 	if err := app.store.Posts.Create(ctx, post); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 		return
 	}
+	app.previews.Enqueue(store.ParseLinks(post.Content)...)
+	if app.timelines != nil {
+		if err := app.timelines.PostCreated(ctx, post); err != nil {
+			app.logger.Warnw("failed to fan out post", "post_id", post.ID, "error", err.Error())
+		}
+	}
+	app.publishFeedPost(ctx, post)
+	if app.federation != nil {
+		if err := app.federation.PostCreated(ctx, user, post); err != nil {
+			app.logger.Warnw("failed to federate post", "post_id", post.ID, "error", err.Error())
+		}
+	}
 
 	if err := app.jsonResponse(w, http.StatusCreated, post); err != nil {
 		app.internalServerErrorHandler(w, r, err)
@@ -51,11 +90,19 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 	post := getPostFromContext(r)
+	viewer := getUserFromContext(r)
 
-	comments, err := app.store.Comments.GetByPostID(r.Context(), post.ID)
+	comments, _, err := app.store.Comments.GetReplies(r.Context(), post.ID, nil, viewer.ID, store.PaginatedCommentsQuery{Limit: 20, Sort: "desc"})
 	if err != nil {
 		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	user := getUserFromContext(r)
+	if err := app.attachPostDetails(r.Context(), []*store.Post{post}, user.ID); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
 	}
+	app.recordPostViews(r.Context(), analytics.KindView, user.ID, post)
 
 	post.Comments = comments
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
@@ -64,9 +111,10 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpdatePostPayload struct {
-	Title   *string   `json:"title" validate:"omitempty,max=255"`
-	Content *string   `json:"content" validate:"omitempty,max=1000"`
-	Tags    *[]string `json:"tags" validate:"omitempty,dive,min=1,max=50"`
+	Title      *string   `json:"title" validate:"omitempty,max=255"`
+	Content    *string   `json:"content" validate:"omitempty,max=10000"`
+	Tags       *[]string `json:"tags" validate:"omitempty,dive,min=1,max=50"`
+	Visibility *string   `json:"visibility" validate:"omitempty,oneof=public followers mentioned"`
 }
 
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -90,9 +138,24 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 	if payload.Tags != nil {
 		post.Tags = *payload.Tags
 	}
+	previousVisibility := post.Visibility
+	if payload.Visibility != nil {
+		post.Visibility = *payload.Visibility
+	}
 
-	if err := app.store.Posts.Update(r.Context(), post); err != nil {
+	ctx := r.Context()
+	if err := app.store.Posts.Update(ctx, post); err != nil {
 		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	app.previews.Enqueue(store.ParseLinks(post.Content)...)
+	if app.timelines != nil && post.Visibility != previousVisibility {
+		if err := app.timelines.VisibilityChanged(ctx, post); err != nil {
+			app.logger.Warnw("failed to update timelines of post", "post_id", post.ID, "error", err.Error())
+		}
+	}
+	if app.federation != nil {
+		app.federatePostUpdate(ctx, post, previousVisibility)
 	}
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.internalServerErrorHandler(w, r, err)
@@ -116,11 +179,123 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
+	if app.federation != nil {
+		app.federatePostDeletion(ctx, getPostFromContext(r))
+	}
 	if err := app.jsonResponse(w, http.StatusOK, map[string]string{"result": "success"}); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 	}
 }
 
+func (app *application) restorePostHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	deleted := getPostFromContext(r)
+	deletedAfter := time.Now().Add(-app.config.softDelete.restoreWindow)
+	if err := app.store.Posts.Restore(ctx, deleted.ID, deletedAfter); err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	// Read as the author: moderators restore posts they may not be allowed to see.
+	post, err := app.store.Posts.GetByID(ctx, deleted.ID, deleted.UserID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if app.timelines != nil {
+		if err := app.timelines.PostCreated(ctx, post); err != nil {
+			app.logger.Warnw("failed to fan out restored post", "post_id", post.ID, "error", err.Error())
+		}
+	}
+	if app.federation != nil {
+		app.federatePostRestore(ctx, post)
+	}
+	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type CommentsLockPayload struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+func (app *application) lockCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.setCommentsLock(w, r, true)
+}
+
+func (app *application) unlockCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	app.setCommentsLock(w, r, false)
+}
+
+func (app *application) setCommentsLock(w http.ResponseWriter, r *http.Request, locked bool) {
+	var payload CommentsLockPayload
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &payload); err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	ctx := r.Context()
+	var err error
+	if locked {
+		err = app.store.Posts.LockComments(ctx, post.ID, user.ID, payload.Reason)
+	} else {
+		err = app.store.Posts.UnlockComments(ctx, post.ID, user.ID, payload.Reason)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrCommentsLockUnchanged):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+
+	updated, err := app.store.Posts.GetByID(ctx, post.ID, user.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, updated); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// attachPostDetails fills in the poll and link previews of posts, aggregated for viewerID.
+func (app *application) attachPostDetails(ctx context.Context, posts []*store.Post, viewerID int64) error {
+	postIDs := make([]int64, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+	}
+	polls, err := app.store.Polls.GetByPostIDs(ctx, postIDs, viewerID)
+	if err != nil {
+		return err
+	}
+	previews, err := app.store.LinkPreviews.GetByPostIDs(ctx, postIDs)
+	if err != nil {
+		return err
+	}
+	for _, post := range posts {
+		post.Poll = polls[post.ID]
+		post.Previews = previews[post.ID]
+	}
+	return nil
+}
+
+// postContextMiddleware is the single place post routes resolve and authorize a post:
+// posts the current user is not allowed to see are reported as not found.
 func (app *application) postContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -129,7 +304,12 @@ func (app *application) postContextMiddleware(next http.Handler) http.Handler {
 			app.badRequestResponse(w, r, err)
 			return
 		}
-		post, err := app.store.Posts.GetByID(ctx, id)
+		user := getUserFromContext(r)
+		if user == nil {
+			app.unauthorizedErrorResponse(w, r, errors.New("user not found"))
+			return
+		}
+		post, err := app.store.Posts.GetByID(ctx, id, user.ID)
 		if err != nil {
 			switch {
 			case errors.Is(err, store.ErrorNotFound):
@@ -145,6 +325,31 @@ func (app *application) postContextMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// deletedPostContextMiddleware loads a soft deleted post that is still inside the restore window.
+func (app *application) deletedPostContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id, err := strconv.ParseInt(chi.URLParam(r, "postID"), 10, 64)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		deletedAfter := time.Now().Add(-app.config.softDelete.restoreWindow)
+		post, err := app.store.Posts.GetDeletedByID(ctx, id, deletedAfter)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		ctx = context.WithValue(ctx, postCtx, post)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func getPostFromContext(r *http.Request) *store.Post {
 	post, _ := r.Context().Value(postCtx).(*store.Post)
 	return post
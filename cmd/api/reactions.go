@@ -0,0 +1,64 @@
+package main
+
+import (
+	"AwesomeProject/internal/store"
+	"errors"
+	"net/http"
+)
+
+type ReactPayload struct {
+	Kind string `json:"kind" validate:"required,oneof=like love laugh"`
+}
+
+func (app *application) reactToPostHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ReactPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	reaction := &store.Reaction{PostID: post.ID, UserID: user.ID, Kind: payload.Kind}
+	if err := app.store.Reactions.React(r.Context(), reaction); err != nil {
+		app.reactionErrorResponse(w, r, err)
+		return
+	}
+	app.reactionsResponse(w, r, post)
+}
+
+func (app *application) unreactToPostHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	post := getPostFromContext(r)
+	if err := app.store.Reactions.Unreact(r.Context(), post.ID, user.ID); err != nil {
+		app.reactionErrorResponse(w, r, err)
+		return
+	}
+	app.reactionsResponse(w, r, post)
+}
+
+func (app *application) reactionsResponse(w http.ResponseWriter, r *http.Request, post *store.Post) {
+	counts, err := app.store.Reactions.GetCounts(r.Context(), post.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, counts); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) reactionErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrorNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrBlocked):
+		app.forbiddenResponse(w, r, err)
+	default:
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
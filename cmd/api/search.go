@@ -0,0 +1,50 @@
+package main
+
+import (
+	"AwesomeProject/internal/store"
+	"errors"
+	"net/http"
+)
+
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	sq := store.SearchQuery{
+		Type:  store.SearchTypePosts,
+		Limit: 20,
+	}
+	sq, err := sq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(sq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	viewer := getUserFromContext(r)
+	var (
+		results any
+		page    store.PageInfo
+	)
+	switch sq.Type {
+	case store.SearchTypeUsers:
+		results, page, err = app.store.Search.SearchUsers(ctx, viewer.ID, sq)
+	case store.SearchTypeComments:
+		results, page, err = app.store.Search.SearchComments(ctx, viewer.ID, sq)
+	default:
+		results, page, err = app.store.Search.SearchPosts(ctx, viewer.ID, sq)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrEmptySearchQuery):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, results, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
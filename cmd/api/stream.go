@@ -0,0 +1,332 @@
+package main
+
+import (
+	"AwesomeProject/internal/realtime"
+	"AwesomeProject/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// streamHeartbeat keeps idle streams from being cut by proxies.
+	streamHeartbeat = 25 * time.Second
+	streamWriteWait = 10 * time.Second
+	// streamMaxPosts bounds how many posts one stream follows the comments of.
+	streamMaxPosts = 50
+	// streamEventError replies to WebSocket commands that failed, successful ones
+	// are acknowledged with "subscribed" or "unsubscribed".
+	streamEventError = "error"
+)
+
+var errTooManyStreamPosts = fmt.Errorf("a stream can follow at most %d posts", streamMaxPosts)
+
+// streamHandler sends the user's feed posts and notifications, and the comment
+// events of the posts listed in the posts query parameter, as Server-Sent Events.
+func (app *application) streamHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+	sub, _, blocked, ok := app.openStream(w, r, user)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	// Streams outlive the server write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if blocked[event.ActorID] {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+type streamCommand struct {
+	Action string `json:"action" validate:"oneof=subscribe unsubscribe"`
+	PostID int64  `json:"post_id" validate:"gte=1"`
+}
+
+// streamWebSocketHandler carries the same events over a WebSocket. Clients follow
+// the comments of further posts by sending {"action":"subscribe","post_id":1}
+// and stop with "unsubscribe".
+func (app *application) streamWebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	sub, postIDs, blocked, ok := app.openStream(w, r, user)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	upgrader := websocket.Upgrader{CheckOrigin: app.checkStreamOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	// The reader ends the stream when the client goes away.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	replies := make(chan realtime.Event, 8)
+	go func() {
+		defer cancel()
+		app.readStreamCommands(ctx, conn, sub, user, postIDs, replies)
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token expired"), time.Now().Add(streamWriteWait))
+			}
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream fell behind"), time.Now().Add(streamWriteWait))
+				return
+			}
+			if !blocked[event.ActorID] {
+				err = writeStreamEvent(conn, event)
+			}
+		case event := <-replies:
+			err = writeStreamEvent(conn, event)
+		case <-heartbeat.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (app *application) readStreamCommands(ctx context.Context, conn *websocket.Conn, sub *realtime.Subscription, user *store.User, postIDs []int64, replies chan<- realtime.Event) {
+	conn.SetReadLimit(4096)
+	extendDeadline := func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	}
+	_ = extendDeadline("")
+	conn.SetPongHandler(extendDeadline)
+
+	posts := make(map[int64]bool, len(postIDs))
+	for _, id := range postIDs {
+		posts[id] = true
+	}
+	for {
+		var command streamCommand
+		if err := conn.ReadJSON(&command); err != nil {
+			// Closed connections and malformed frames both end the stream.
+			return
+		}
+		_ = extendDeadline("")
+		err := Validate.Struct(command)
+		if err == nil && command.Action == "subscribe" && !posts[command.PostID] {
+			if len(posts) >= streamMaxPosts {
+				err = errTooManyStreamPosts
+			} else if err = app.subscribeToPost(ctx, sub, user, command.PostID); err == nil {
+				posts[command.PostID] = true
+			}
+		}
+		if err == nil && command.Action == "unsubscribe" {
+			if err = sub.Unsubscribe(ctx, realtime.PostTopic(command.PostID)); err == nil {
+				delete(posts, command.PostID)
+			}
+		}
+		var reply realtime.Event
+		if err != nil {
+			reply, _ = realtime.NewEvent(streamEventError, map[string]string{"error": err.Error()})
+		} else {
+			reply, _ = realtime.NewEvent(command.Action+"d", map[string]int64{"post_id": command.PostID})
+		}
+		select {
+		case replies <- reply:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func writeStreamEvent(conn *websocket.Conn, event realtime.Event) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(streamWriteWait)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(event)
+}
+
+// openStream subscribes user to their own events and to the comments of the
+// posts listed in the posts query parameter, which they must be able to see.
+// It also returns the users on either side of a block with user, whose events
+// the stream drops. Blocks made later apply once the client reconnects.
+func (app *application) openStream(w http.ResponseWriter, r *http.Request, user *store.User) (*realtime.Subscription, []int64, map[int64]bool, bool) {
+	ctx := r.Context()
+	postIDs, err := parseStreamPosts(r.URL.Query().Get("posts"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, nil, nil, false
+	}
+	blockedIDs, err := app.store.Blocks.GetBlockedIDs(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return nil, nil, nil, false
+	}
+	blocked := make(map[int64]bool, len(blockedIDs))
+	for _, id := range blockedIDs {
+		blocked[id] = true
+	}
+	sub, err := app.events.Subscribe(ctx, realtime.UserTopic(user.ID))
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return nil, nil, nil, false
+	}
+	for _, postID := range postIDs {
+		if err := app.subscribeToPost(ctx, sub, user, postID); err != nil {
+			sub.Close()
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return nil, nil, nil, false
+		}
+	}
+	return sub, postIDs, blocked, true
+}
+
+func (app *application) subscribeToPost(ctx context.Context, sub *realtime.Subscription, user *store.User, postID int64) error {
+	if _, err := app.store.Posts.GetByID(ctx, postID, user.ID); err != nil {
+		return err
+	}
+	return sub.Subscribe(ctx, realtime.PostTopic(postID))
+}
+
+func parseStreamPosts(value string) ([]int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) > streamMaxPosts {
+		return nil, errTooManyStreamPosts
+	}
+	ids := make([]int64, 0, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid post id %q", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// checkStreamOrigin lets the frontend and same-origin pages open WebSockets.
+// Requests without an Origin come from non-browser clients.
+func (app *application) checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+	frontend, err := url.Parse(app.config.frontendURL)
+	return err == nil && strings.EqualFold(originURL.Scheme, frontend.Scheme) && strings.EqualFold(originURL.Host, frontend.Host)
+}
+
+// publishEvent sends an event to topics. Streams are best effort, so failures
+// are only logged.
+func (app *application) publishEvent(ctx context.Context, eventType string, data any, topics ...string) {
+	event, err := realtime.NewEvent(eventType, data)
+	if err == nil {
+		err = app.events.Publish(ctx, event, topics...)
+	}
+	if err != nil {
+		app.logger.Warnw("failed to publish event", "type", eventType, "error", err.Error())
+	}
+}
+
+// publishFeedPost pushes a new post to the streams of the author's followers
+// who did not mute them. Posts for mentioned users only are left to notifications.
+func (app *application) publishFeedPost(ctx context.Context, post *store.Post) {
+	if post.Visibility == store.VisibilityMentioned {
+		return
+	}
+	followerIDs, err := app.store.Followers.GetFollowerIDs(ctx, post.UserID)
+	if err != nil {
+		app.logger.Warnw("failed to publish post", "post_id", post.ID, "error", err.Error())
+		return
+	}
+	muterIDs, err := app.store.Mutes.GetMuterIDs(ctx, post.UserID)
+	if err != nil {
+		app.logger.Warnw("failed to publish post", "post_id", post.ID, "error", err.Error())
+		return
+	}
+	muted := make(map[int64]bool, len(muterIDs))
+	for _, id := range muterIDs {
+		muted[id] = true
+	}
+	topics := make([]string, 0, len(followerIDs))
+	for _, id := range followerIDs {
+		if !muted[id] {
+			topics = append(topics, realtime.UserTopic(id))
+		}
+	}
+	app.publishEvent(ctx, realtime.EventFeedPost, post, topics...)
+}
+
+// publishCommentEvent sends an event about comment to the streams following its
+// post. Streams drop it for viewers on either side of a block with the author.
+func (app *application) publishCommentEvent(ctx context.Context, eventType string, comment *store.Comment, data any) {
+	event, err := realtime.NewEvent(eventType, data)
+	if err == nil {
+		event.ActorID = comment.UserID
+		err = app.events.Publish(ctx, event, realtime.PostTopic(comment.PostID))
+	}
+	if err != nil {
+		app.logger.Warnw("failed to publish event", "type", eventType, "error", err.Error())
+	}
+}
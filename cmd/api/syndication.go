@@ -0,0 +1,141 @@
+package main
+
+import (
+	"AwesomeProject/internal/store"
+	"AwesomeProject/internal/syndication"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	syndicationAtom = "atom"
+	syndicationRSS  = "rss"
+	syndicationJSON = "json"
+
+	syndicationItems = 20
+)
+
+// userSyndicationHandler serves the recent public posts of a user as an Atom,
+// RSS or JSON Feed document for feed readers.
+func (app *application) userSyndicationHandler(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user, err := app.store.Users.GetByUsername(ctx, chi.URLParam(r, "username"))
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrorNotFound):
+				app.notFoundResponse(w, r, err)
+			default:
+				app.internalServerErrorHandler(w, r, err)
+			}
+			return
+		}
+		posts, lastModified, err := app.store.Posts.GetPublicByUser(ctx, user.ID, syndicationItems)
+		if err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+		if lastModified.IsZero() {
+			lastModified, _ = time.Parse(time.RFC3339, user.CreatedAt)
+		}
+
+		profileURL := app.profileURL(user)
+		feed := &syndication.Feed{
+			Title:       fmt.Sprintf("%s on GopherSocial", user.Username),
+			Description: fmt.Sprintf("Public posts by %s", user.Username),
+			HomeURL:     profileURL,
+			FeedURL:     requestURL(r),
+			AuthorName:  user.Username,
+			AuthorURL:   profileURL,
+			Updated:     lastModified,
+		}
+		for _, post := range posts {
+			postURL := fmt.Sprintf("%s/posts/%d", app.config.frontendURL, post.ID)
+			published, _ := time.Parse(time.RFC3339, post.CreatedAt)
+			updated, _ := time.Parse(time.RFC3339, post.UpdatedAt)
+			feed.Items = append(feed.Items, syndication.Item{
+				ID:          postURL,
+				URL:         postURL,
+				Title:       post.Title,
+				ContentHTML: post.ContentHTML,
+				Tags:        post.Tags,
+				Published:   published,
+				Updated:     updated,
+			})
+		}
+
+		app.serveFeed(w, r, feed, format, lastModified)
+	}
+}
+
+// serveFeed renders feed in format and answers conditional requests with
+// 304 Not Modified when the client already has the rendered document.
+func (app *application) serveFeed(w http.ResponseWriter, r *http.Request, feed *syndication.Feed, format string, lastModified time.Time) {
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	switch format {
+	case syndicationRSS:
+		body, err = feed.RSS()
+		contentType = syndication.ContentTypeRSS
+	case syndicationJSON:
+		body, err = feed.JSON()
+		contentType = syndication.ContentTypeJSON
+	default:
+		body, err = feed.Atom()
+		contentType = syndication.ContentTypeAtom
+	}
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// notModified evaluates the conditional request headers. If-None-Match takes
+// precedence over If-Modified-Since as required by RFC 9110.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.Path)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"AwesomeProject/internal/syndication"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServeFeedConditionalRequests(t *testing.T) {
+	app := &application{logger: zap.NewNop().Sugar()}
+	lastModified := time.Date(2024, 3, 1, 10, 30, 0, 500, time.UTC)
+	feed := &syndication.Feed{
+		Title:   "gopher on GopherSocial",
+		FeedURL: "https://api.gopher.social/users/gopher/feed.atom",
+		Updated: lastModified,
+		Items: []syndication.Item{
+			{ID: "https://gopher.social/posts/1", Title: "Hello", Published: lastModified, Updated: lastModified},
+		},
+	}
+	serve := func(format string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/users/gopher/feed", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		app.serveFeed(w, r, feed, format, lastModified)
+		return w
+	}
+
+	contentTypes := map[string]string{
+		syndicationAtom: syndication.ContentTypeAtom,
+		syndicationRSS:  syndication.ContentTypeRSS,
+		syndicationJSON: syndication.ContentTypeJSON,
+	}
+	etags := map[string]string{}
+	for format, contentType := range contentTypes {
+		w := serve(format, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", format, w.Code)
+		}
+		if got := w.Header().Get("Content-Type"); got != contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", format, got, contentType)
+		}
+		if got := w.Header().Get("Last-Modified"); got != "Fri, 01 Mar 2024 10:30:00 GMT" {
+			t.Errorf("%s: Last-Modified = %q", format, got)
+		}
+		etag := w.Header().Get("ETag")
+		if etag == "" || w.Body.Len() == 0 {
+			t.Fatalf("%s: want an ETag and a body", format)
+		}
+		etags[format] = etag
+	}
+	if etags[syndicationAtom] == etags[syndicationRSS] || etags[syndicationAtom] == etags[syndicationJSON] {
+		t.Errorf("formats share an ETag: %v", etags)
+	}
+
+	etag := etags[syndicationAtom]
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"matching etag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"weak etag in a list", http.Header{"If-None-Match": {`"other", W/` + etag}}, http.StatusNotModified},
+		{"any etag", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"stale etag", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{"etag of another format", http.Header{"If-None-Match": {etags[syndicationRSS]}}, http.StatusOK},
+		{"modified since", http.Header{"If-Modified-Since": {"Fri, 01 Mar 2024 10:29:59 GMT"}}, http.StatusOK},
+		{"not modified since", http.Header{"If-Modified-Since": {"Fri, 01 Mar 2024 10:30:00 GMT"}}, http.StatusNotModified},
+		{"not modified since later", http.Header{"If-Modified-Since": {"Sat, 02 Mar 2024 00:00:00 GMT"}}, http.StatusNotModified},
+		{"invalid date", http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{
+			"etag takes precedence over date",
+			http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {"Sat, 02 Mar 2024 00:00:00 GMT"}},
+			http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(syndicationAtom, tt.header)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("ETag = %q, want %q", w.Header().Get("ETag"), etag)
+			}
+			if tt.want == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("304 response has a body of %d bytes", w.Body.Len())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"AwesomeProject/internal/store"
+	"context"
 	"errors"
 	"net/http"
 	"strconv"
@@ -21,7 +22,7 @@ const userCtxKey userKey = "user"
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		int	true	"User ID"
-//	@Success		200	{object}	store.User
+//	@Success		200	{object}	store.UserProfile
 //	@Failure		400	{object}	error
 //	@Failure		404	{object}	error
 //	@Failure		500	{object}	error
@@ -42,42 +43,146 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		app.unauthorizedErrorResponse(w, r, errors.New("user not found in middleware"))
 		return
 	}
-	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+	viewer := getUserFromContext(r)
+	profile, err := app.store.Followers.GetProfile(ctx, user, viewer.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, profile); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 	}
 }
 
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
-	followedUser := getUserFromContext(r)
-	followedID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	follower := getUserFromContext(r)
+	followedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	ctx := r.Context()
-	err = app.store.Followers.Follow(ctx, followedUser.ID, followedID)
+	requested, err := app.store.Followers.Follow(ctx, follower.ID, followedID)
 	if err != nil {
-		app.internalServerErrorHandler(w, r, err)
+		app.relationshipErrorResponse(w, r, err)
 		return
 	}
+	if requested {
+		if err := app.jsonResponse(w, http.StatusAccepted, map[string]string{"status": "requested"}); err != nil {
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if app.timelines != nil {
+		if err := app.timelines.Followed(ctx, follower.ID, followedID); err != nil {
+			app.logger.Warnw("failed to update timeline", "user_id", follower.ID, "error", err.Error())
+		}
+	}
 	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
 		app.internalServerErrorHandler(w, r, err)
 	}
 }
 
 func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
-	unfollowedUser := getUserFromContext(r)
-	unfollowedID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	follower := getUserFromContext(r)
+	unfollowedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	err = app.store.Followers.Unfollow(ctx, follower.ID, unfollowedID)
+	if err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if app.timelines != nil {
+		if err := app.timelines.Unfollowed(ctx, follower.ID, unfollowedID); err != nil {
+			app.logger.Warnw("failed to update timeline", "user_id", follower.ID, "error", err.Error())
+		}
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) relationshipErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrSelfFollow), errors.Is(err, store.ErrSelfBlock), errors.Is(err, store.ErrSelfMute):
+		app.badRequestResponse(w, r, err)
+	case errors.Is(err, store.ErrAlreadyFollowing), errors.Is(err, store.ErrFollowAlreadyRequested),
+		errors.Is(err, store.ErrAlreadyBlocked), errors.Is(err, store.ErrAlreadyMuted):
+		app.conflictResponse(w, r, err)
+	case errors.Is(err, store.ErrBlocked):
+		app.forbiddenResponse(w, r, err)
+	case errors.Is(err, store.ErrorNotFound):
+		app.notFoundResponse(w, r, err)
+	default:
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// blockUserHandler blocks a user, which also ends the follows between the two.
+func (app *application) blockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blockedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	ctx := r.Context()
-	err = app.store.Followers.Unfollow(ctx, unfollowedUser.ID, unfollowedID)
+	user := getUserFromContext(r)
+	if err := app.store.Blocks.Block(ctx, user.ID, blockedID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if app.timelines != nil {
+		if err := app.timelines.Unfollowed(ctx, user.ID, blockedID); err != nil {
+			app.logger.Warnw("failed to update timeline", "user_id", user.ID, "error", err.Error())
+		}
+		if err := app.timelines.Unfollowed(ctx, blockedID, user.ID); err != nil {
+			app.logger.Warnw("failed to update timeline", "user_id", blockedID, "error", err.Error())
+		}
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) unblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	blockedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
 	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := app.store.Blocks.Unblock(r.Context(), user.ID, blockedID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
 		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) muteUserHandler(w http.ResponseWriter, r *http.Request) {
+	mutedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := app.store.Mutes.Mute(r.Context(), user.ID, mutedID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
 		return
 	}
 	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
@@ -85,6 +190,293 @@ func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+func (app *application) unmuteUserHandler(w http.ResponseWriter, r *http.Request) {
+	mutedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := app.store.Mutes.Unmute(r.Context(), user.ID, mutedID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getBlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listRelatedUsers(w, r, app.store.Blocks.GetBlocked)
+}
+
+func (app *application) getMutedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listRelatedUsers(w, r, app.store.Mutes.GetMuted)
+}
+
+func (app *application) listRelatedUsers(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID int64, query store.FollowQuery) ([]store.RelatedUser, store.PageInfo, error)) {
+	fq := store.FollowQuery{
+		Limit: 20,
+	}
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	users, page, err := list(r.Context(), user.ID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, users, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getFollowRequestsHandler lists the users waiting for the current user to
+// approve their follow.
+func (app *application) getFollowRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.FollowQuery{
+		Limit: 20,
+	}
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	requests, page, err := app.store.Followers.GetFollowRequests(r.Context(), user.ID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, requests, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) approveFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requesterID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	if err := app.store.Followers.ApproveFollowRequest(ctx, user.ID, requesterID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if app.timelines != nil {
+		if err := app.timelines.Followed(ctx, requesterID, user.ID); err != nil {
+			app.logger.Warnw("failed to update timeline", "user_id", requesterID, "error", err.Error())
+		}
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) rejectFollowRequestHandler(w http.ResponseWriter, r *http.Request) {
+	requesterID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := app.store.Followers.RejectFollowRequest(r.Context(), user.ID, requesterID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// getSuggestionsHandler lists the users suggested for the current user to
+// follow, best first.
+func (app *application) getSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.FollowQuery{
+		Limit: 20,
+	}
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	suggestions, page, err := app.store.Suggestions.GetSuggestions(r.Context(), user.ID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, suggestions, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) dismissSuggestionHandler(w http.ResponseWriter, r *http.Request) {
+	suggestedID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if err := app.store.Suggestions.Dismiss(r.Context(), user.ID, suggestedID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Followers.GetFollowers)
+}
+
+func (app *application) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	app.listFollows(w, r, app.store.Followers.GetFollowing)
+}
+
+func (app *application) listFollows(w http.ResponseWriter, r *http.Request, list func(ctx context.Context, userID int64, query store.FollowQuery) ([]store.FollowEntry, store.PageInfo, error)) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	fq := store.FollowQuery{
+		Limit: 20,
+	}
+	fq, err = fq.Parse(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := app.store.Users.GetByID(ctx, userID); err != nil {
+		app.relationshipErrorResponse(w, r, err)
+		return
+	}
+	entries, page, err := list(ctx, userID, fq)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if err := app.paginatedJSONResponse(w, r, http.StatusOK, entries, page); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+func (app *application) getUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	settings, err := app.store.Users.GetSettings(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrorNotFound):
+			app.notFoundResponse(w, r, err)
+		default:
+			app.internalServerErrorHandler(w, r, err)
+		}
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, settings); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+type UpdateUserSettingsPayload struct {
+	DMPolicy  *string `json:"dm_policy" validate:"omitempty,oneof=everyone following nobody"`
+	IsPrivate *bool   `json:"is_private"`
+}
+
+func (app *application) updateUserSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateUserSettingsPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := getUserFromContext(r)
+	settings, err := app.store.Users.GetSettings(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	if payload.DMPolicy != nil {
+		settings.DMPolicy = *payload.DMPolicy
+	}
+	if payload.IsPrivate != nil {
+		settings.IsPrivate = *payload.IsPrivate
+	}
+	if err := app.store.Users.UpdateSettings(ctx, user.ID, settings); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+		return
+	}
+	// Requests left pending by a private account are approved once it is public.
+	// This runs on every update of a public account, so saving the settings
+	// again picks up requests that a failure or a racing follow left behind.
+	if !settings.IsPrivate {
+		if err := app.approveAllFollowRequests(ctx, user); err != nil {
+			app.internalServerErrorHandler(w, r, err)
+			return
+		}
+	}
+	if err := app.jsonResponse(w, http.StatusOK, settings); err != nil {
+		app.internalServerErrorHandler(w, r, err)
+	}
+}
+
+// approveAllFollowRequests approves the pending requests of local users and of
+// remote actors to user, sending the Accepts the remote servers wait for.
+func (app *application) approveAllFollowRequests(ctx context.Context, user *store.User) error {
+	requesterIDs, err := app.store.Followers.ApproveAllFollowRequests(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if app.timelines != nil {
+		for _, requesterID := range requesterIDs {
+			if err := app.timelines.Followed(ctx, requesterID, user.ID); err != nil {
+				app.logger.Warnw("failed to update timeline", "user_id", requesterID, "error", err.Error())
+			}
+		}
+	}
+	if app.federation != nil {
+		return app.federation.ApproveAllFollows(ctx, user)
+	}
+	return nil
+}
+
 func getUserFromContext(r *http.Request) *store.User {
 	user, _ := r.Context().Value(userCtxKey).(*store.User)
 	return user
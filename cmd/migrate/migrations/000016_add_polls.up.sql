@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS polls (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL UNIQUE,
+    multiple_choice boolean NOT NULL DEFAULT FALSE,
+    hide_results boolean NOT NULL DEFAULT FALSE,
+    closes_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+    id bigserial PRIMARY KEY,
+    poll_id bigint NOT NULL,
+    position int NOT NULL,
+    text varchar(100) NOT NULL,
+
+    UNIQUE (poll_id, position),
+    FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id bigint NOT NULL,
+    option_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    single_choice boolean NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (option_id, user_id),
+    FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+    FOREIGN KEY (option_id) REFERENCES poll_options (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- one vote per user on single choice polls
+CREATE UNIQUE INDEX IF NOT EXISTS idx_poll_votes_single_choice ON poll_votes (poll_id, user_id) WHERE single_choice;
+CREATE INDEX IF NOT EXISTS idx_poll_votes_poll_user ON poll_votes (poll_id, user_id);
+
+-- one ballot per user and poll, whatever the number of options it selects
+CREATE TABLE IF NOT EXISTS poll_ballots (
+    poll_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (poll_id, user_id),
+    FOREIGN KEY (poll_id) REFERENCES polls (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
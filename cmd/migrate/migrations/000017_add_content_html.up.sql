@@ -0,0 +1,2 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS content_html text;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS content_html text;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_followers_user_created_at;
+DROP INDEX IF EXISTS idx_comments_created_at;
+DROP TABLE IF EXISTS post_views_daily;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS post_views_daily (
+    post_id bigint NOT NULL,
+    day date NOT NULL,
+    impressions bigint NOT NULL DEFAULT 0,
+    views bigint NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (post_id, day),
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_views_daily_day ON post_views_daily (day);
+CREATE INDEX IF NOT EXISTS idx_comments_created_at ON comments (created_at);
+CREATE INDEX IF NOT EXISTS idx_followers_user_created_at ON followers (user_id, created_at);
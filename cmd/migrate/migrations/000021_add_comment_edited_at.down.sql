@@ -0,0 +1 @@
+ALTER TABLE comments DROP COLUMN edited_at;
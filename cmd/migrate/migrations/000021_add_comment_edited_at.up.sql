@@ -0,0 +1 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at timestamp(0) with time zone;
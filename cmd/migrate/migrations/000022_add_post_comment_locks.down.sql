@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS post_lock_events;
+ALTER TABLE posts DROP COLUMN comments_lock_reason;
+ALTER TABLE posts DROP COLUMN comments_locked_by;
+ALTER TABLE posts DROP COLUMN comments_locked_at;
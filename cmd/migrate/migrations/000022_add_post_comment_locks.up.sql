@@ -0,0 +1,17 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comments_locked_at timestamp(0) with time zone;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comments_locked_by bigint REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comments_lock_reason text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS post_lock_events (
+    id bigserial PRIMARY KEY,
+    post_id bigint NOT NULL,
+    actor_id bigint,
+    action varchar(10) NOT NULL CHECK (action IN ('lock', 'unlock')),
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_lock_events_post_id ON post_lock_events (post_id, created_at);
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_posts_user_created_at_id ON posts (user_id, created_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_posts_created_at_id ON posts (created_at DESC, id DESC) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_followers_follower_id ON followers (follower_id, user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS post_seen;
+DROP TABLE IF EXISTS explore_scores;
@@ -0,0 +1,26 @@
+-- A row scores either a local post or a public note received from another
+-- server. remote_post_id references remote_posts from the federation migration.
+CREATE TABLE IF NOT EXISTS explore_scores (
+    id bigserial PRIMARY KEY,
+    post_id bigint,
+    remote_post_id bigint,
+    score double precision NOT NULL,
+    computed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    CHECK ((post_id IS NULL) <> (remote_post_id IS NULL)),
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_explore_scores_score ON explore_scores (score DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS post_seen (
+    user_id bigint NOT NULL,
+    post_id bigint NOT NULL,
+    seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_seen_seen_at ON post_seen (seen_at);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS deliveries;
+ALTER TABLE explore_scores DROP CONSTRAINT IF EXISTS explore_scores_remote_post_id_fkey;
+DROP TABLE IF EXISTS remote_post_recipients;
+DROP TABLE IF EXISTS remote_posts;
+DROP TABLE IF EXISTS remote_follow_requests;
+DROP TABLE IF EXISTS remote_followers;
+DROP TABLE IF EXISTS remote_actors;
+DROP TABLE IF EXISTS actor_keys;
@@ -0,0 +1,91 @@
+CREATE TABLE IF NOT EXISTS actor_keys (
+    user_id bigint PRIMARY KEY,
+    public_key_pem text NOT NULL,
+    private_key_pem text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS remote_actors (
+    id bigserial PRIMARY KEY,
+    uri text NOT NULL UNIQUE,
+    key_id text NOT NULL UNIQUE,
+    inbox text NOT NULL,
+    preferred_username text NOT NULL DEFAULT '',
+    public_key_pem text NOT NULL,
+    fetched_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS remote_followers (
+    user_id bigint NOT NULL,
+    remote_actor_id bigint NOT NULL,
+    follow_activity_id text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, remote_actor_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (remote_actor_id) REFERENCES remote_actors (id) ON DELETE CASCADE
+);
+
+-- user_id is the private account remote_actor_id asked to follow with the
+-- Follow activity follow_activity_id, which is answered once it is decided.
+CREATE TABLE IF NOT EXISTS remote_follow_requests (
+    user_id bigint NOT NULL,
+    remote_actor_id bigint NOT NULL,
+    follow_activity_id text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, remote_actor_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (remote_actor_id) REFERENCES remote_actors (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS remote_posts (
+    id bigserial PRIMARY KEY,
+    remote_actor_id bigint NOT NULL,
+    uri text NOT NULL UNIQUE,
+    url text NOT NULL DEFAULT '',
+    content_html text NOT NULL,
+    in_reply_to text NOT NULL DEFAULT '',
+    -- the local post the note replies to
+    post_id bigint,
+    -- whether the note is addressed to everyone, otherwise it only reaches
+    -- the local users in remote_post_recipients
+    public boolean NOT NULL DEFAULT FALSE,
+    published_at timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (remote_actor_id) REFERENCES remote_actors (id) ON DELETE CASCADE,
+    FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_remote_posts_post_id ON remote_posts (post_id, created_at) WHERE post_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS remote_post_recipients (
+    remote_post_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+
+    PRIMARY KEY (user_id, remote_post_id),
+    FOREIGN KEY (remote_post_id) REFERENCES remote_posts (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE explore_scores
+    ADD CONSTRAINT explore_scores_remote_post_id_fkey FOREIGN KEY (remote_post_id) REFERENCES remote_posts (id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS deliveries (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    inbox text NOT NULL,
+    payload jsonb NOT NULL,
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_error text NOT NULL DEFAULT '',
+    failed_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_deliveries_next_attempt_at ON deliveries (next_attempt_at) WHERE failed_at IS NULL;
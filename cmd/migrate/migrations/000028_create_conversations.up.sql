@@ -0,0 +1,52 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS dm_policy varchar(20) NOT NULL DEFAULT 'everyone';
+
+CREATE TABLE IF NOT EXISTS conversations (
+    id bigserial PRIMARY KEY,
+    created_by bigint,
+    is_group boolean NOT NULL DEFAULT FALSE,
+    title varchar(100) NOT NULL DEFAULT '',
+    -- direct_key is "<lower user id>:<higher user id>" for 1:1 conversations, so a pair only ever has one.
+    direct_key varchar(50) UNIQUE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_message_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    joined_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_read_message_id bigint NOT NULL DEFAULT 0,
+    last_read_at timestamp(0) with time zone,
+
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_participants_user_id ON conversation_participants (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id bigserial PRIMARY KEY,
+    conversation_id bigint NOT NULL,
+    sender_id bigint NOT NULL,
+    content text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (conversation_id) REFERENCES conversations (id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id, id DESC);
+
+-- Messages deleted by a participant stay visible to the others.
+CREATE TABLE IF NOT EXISTS message_deletions (
+    message_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    deleted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+ALTER TABLE posts DROP COLUMN IF EXISTS community_id;
+DROP TABLE IF EXISTS community_bans;
+DROP TABLE IF EXISTS community_members;
+DROP TABLE IF EXISTS communities;
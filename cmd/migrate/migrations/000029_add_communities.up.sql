@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS communities (
+    id bigserial PRIMARY KEY,
+    slug varchar(50) NOT NULL UNIQUE,
+    name varchar(100) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    rules text[] NOT NULL DEFAULT '{}',
+    join_policy varchar(20) NOT NULL DEFAULT 'open',
+    created_by bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL
+);
+
+-- A member row is a join request while pending and an invitation while invited.
+CREATE TABLE IF NOT EXISTS community_members (
+    community_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    role varchar(20) NOT NULL DEFAULT 'member',
+    status varchar(20) NOT NULL DEFAULT 'active',
+    invited_by bigint,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (community_id, user_id),
+    FOREIGN KEY (community_id) REFERENCES communities (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (invited_by) REFERENCES users (id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_members_user_id ON community_members (user_id);
+
+CREATE TABLE IF NOT EXISTS community_bans (
+    community_id bigint NOT NULL,
+    user_id bigint NOT NULL,
+    banned_by bigint,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (community_id, user_id),
+    FOREIGN KEY (community_id) REFERENCES communities (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (banned_by) REFERENCES users (id) ON DELETE SET NULL
+);
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS community_id bigint REFERENCES communities (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_posts_community_id ON posts (community_id, created_at DESC, id DESC) WHERE community_id IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS suggestion_dismissals;
+DROP TABLE IF EXISTS user_suggestions;
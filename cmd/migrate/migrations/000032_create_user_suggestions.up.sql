@@ -0,0 +1,26 @@
+-- Precomputed who-to-follow suggestions for user_id, refreshed periodically.
+CREATE TABLE IF NOT EXISTS user_suggestions (
+    user_id bigint NOT NULL,
+    suggested_id bigint NOT NULL,
+    score double precision NOT NULL,
+    mutual_count bigint NOT NULL DEFAULT 0,
+    shared_tags text[] NOT NULL DEFAULT '{}',
+    computed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, suggested_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (suggested_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_suggestions_score ON user_suggestions (user_id, score DESC, suggested_id DESC);
+
+-- user_id does not want suggested_id suggested again.
+CREATE TABLE IF NOT EXISTS suggestion_dismissals (
+    user_id bigint NOT NULL,
+    suggested_id bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (user_id, suggested_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (suggested_id) REFERENCES users (id) ON DELETE CASCADE
+);
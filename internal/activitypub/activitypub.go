@@ -0,0 +1,179 @@
+package activitypub
+
+import (
+	"AwesomeProject/internal/store"
+	"AwesomeProject/internal/unfurl"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ContentType     = "application/activity+json"
+	LDContentType   = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+	JRDContentType  = "application/jrd+json"
+	ActivityStreams = "https://www.w3.org/ns/activitystreams"
+	SecurityV1      = "https://w3id.org/security/v1"
+	Public          = "https://www.w3.org/ns/activitystreams#Public"
+
+	DefaultTimeout = 10 * time.Second
+	MaxBodyBytes   = 1024 * 1024
+)
+
+var (
+	ErrInvalidActivity = errors.New("activitypub: invalid activity")
+	ErrActorMismatch   = errors.New("activitypub: activity actor does not match signature")
+)
+
+type Config struct {
+	// BaseURL is the public origin of this server, e.g. https://gopher.social.
+	BaseURL string
+	// AllowPrivateNetworks lets the server talk to instances on private
+	// addresses, for running federated instances side by side locally.
+	AllowPrivateNetworks bool
+	Timeout              time.Duration
+}
+
+// Service federates local users with other ActivityPub servers: it renders their
+// actors and outboxes, processes activities posted to their inboxes and queues
+// activities for delivery to remote followers.
+type Service struct {
+	cfg    Config
+	domain string
+	store  *store.Storage
+	client *http.Client
+	logger *zap.SugaredLogger
+}
+
+func NewService(cfg Config, storage *store.Storage, logger *zap.SugaredLogger) (*Service, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("activitypub: invalid base url %q", cfg.BaseURL)
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		client.Transport = unfurl.NewPublicTransport(cfg.Timeout)
+	}
+	return &Service{
+		cfg:    cfg,
+		domain: base.Host,
+		store:  storage,
+		client: client,
+		logger: logger,
+	}, nil
+}
+
+// Domain is the host part of local account handles.
+func (s *Service) Domain() string {
+	return s.domain
+}
+
+func (s *Service) ActorURI(username string) string {
+	return fmt.Sprintf("%s/ap/users/%s", s.cfg.BaseURL, url.PathEscape(username))
+}
+
+func (s *Service) NoteURI(username string, postID int64) string {
+	return fmt.Sprintf("%s/posts/%d", s.ActorURI(username), postID)
+}
+
+type Actor struct {
+	Context           any       `json:"@context,omitempty"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name,omitempty"`
+	URL               string    `json:"url,omitempty"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox,omitempty"`
+	Followers         string    `json:"followers,omitempty"`
+	Published         string    `json:"published,omitempty"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPEM string `json:"publicKeyPem"`
+}
+
+// Activity is an incoming or outgoing activity. Object is kept raw because it
+// may be an embedded object or a bare ID.
+type Activity struct {
+	Context   any             `json:"@context,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Object    json.RawMessage `json:"object"`
+	Published string          `json:"published,omitempty"`
+	To        []string        `json:"to,omitempty"`
+	CC        []string        `json:"cc,omitempty"`
+}
+
+type Note struct {
+	Context      any      `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo"`
+	Name         string   `json:"name,omitempty"`
+	Content      string   `json:"content"`
+	Source       *Source  `json:"source,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	InReplyTo    string   `json:"inReplyTo,omitempty"`
+	Published    string   `json:"published"`
+	Updated      string   `json:"updated,omitempty"`
+	To           []string `json:"to,omitempty"`
+	CC           []string `json:"cc,omitempty"`
+	Tag          []Tag    `json:"tag,omitempty"`
+}
+
+type Source struct {
+	Content   string `json:"content"`
+	MediaType string `json:"mediaType"`
+}
+
+type Tag struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+// objectID returns the ID of an object given either embedded or by reference.
+func objectID(raw json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &object); err == nil {
+		return object.ID
+	}
+	return ""
+}
+
+// objectType returns the type of an embedded object, or "" for references.
+func objectType(raw json.RawMessage) string {
+	var object struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return ""
+	}
+	return object.Type
+}
@@ -0,0 +1,97 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// MaxDeliveryAttempts is how often an activity is posted before giving up,
+	// spreading the attempts over about a day and a half.
+	MaxDeliveryAttempts = 10
+	deliveryBatch       = 20
+	deliveryLease       = time.Minute * 5
+)
+
+// RunDeliveries posts queued activities to remote inboxes every interval until
+// ctx is done. Failed deliveries are retried with exponential backoff.
+func (s *Service) RunDeliveries(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deliveries, err := s.store.Federation.ClaimDeliveries(ctx, deliveryBatch, deliveryLease)
+		if err != nil {
+			s.logger.Errorw("failed to claim deliveries", "error", err.Error())
+			continue
+		}
+		for _, d := range deliveries {
+			err := s.deliver(ctx, d.UserID, d.Inbox, d.Payload)
+			if err == nil {
+				if err := s.store.Federation.CompleteDelivery(ctx, d.ID); err != nil {
+					s.logger.Errorw("failed to complete delivery", "id", d.ID, "error", err.Error())
+				}
+				continue
+			}
+			var nextAttempt time.Time
+			if d.Attempts+1 < MaxDeliveryAttempts {
+				nextAttempt = time.Now().Add(retryDelay(d.Attempts))
+			}
+			s.logger.Infow("failed to deliver activity", "id", d.ID, "inbox", d.Inbox, "attempts", d.Attempts+1, "error", err.Error())
+			if err := s.store.Federation.RetryDelivery(ctx, d.ID, nextAttempt, err.Error()); err != nil {
+				s.logger.Errorw("failed to reschedule delivery", "id", d.ID, "error", err.Error())
+			}
+		}
+	}
+}
+
+// deliver posts payload to inbox, signed with the key of userID.
+func (s *Service) deliver(ctx context.Context, userID int64, inbox string, payload []byte) error {
+	user, err := s.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	key, err := s.userKey(ctx, userID)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+	if err := signRequest(req, s.ActorURI(user.Username)+"#main-key", key.PrivateKeyPEM, payload); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxBodyBytes))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("activitypub: inbox responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// retryDelay is the backoff after attempts earlier failures: one minute,
+// doubling up to twelve hours.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Minute << attempts
+	if delay > 12*time.Hour || delay <= 0 {
+		return 12 * time.Hour
+	}
+	return delay
+}
@@ -0,0 +1,221 @@
+package activitypub
+
+import (
+	"AwesomeProject/internal/markdown"
+	"AwesomeProject/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandleInbox processes an activity that actor posted to the inbox of user.
+// Unsupported activity types are accepted and ignored.
+func (s *Service) HandleInbox(ctx context.Context, user *store.User, actor *store.RemoteActor, activity *Activity) error {
+	if activity.Actor != actor.URI {
+		return ErrActorMismatch
+	}
+	switch activity.Type {
+	case "Follow":
+		return s.handleFollow(ctx, user, actor, activity)
+	case "Undo":
+		return s.handleUndo(ctx, user, actor, activity)
+	case "Create":
+		return s.handleCreate(ctx, user, actor, activity)
+	case "Delete":
+		return s.handleDelete(ctx, actor, activity)
+	default:
+		return nil
+	}
+}
+
+// handleFollow maps a remote follow into the followers graph and accepts it.
+// Follows of private accounts wait for the account to approve them and are
+// only answered then.
+func (s *Service) handleFollow(ctx context.Context, user *store.User, actor *store.RemoteActor, activity *Activity) error {
+	if objectID(activity.Object) != s.ActorURI(user.Username) {
+		return ErrInvalidActivity
+	}
+	settings, err := s.store.Users.GetSettings(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if settings.IsPrivate {
+		requested, err := s.store.Federation.RequestRemoteFollow(ctx, user.ID, actor.ID, activity.ID)
+		if err != nil || requested {
+			return err
+		}
+	} else if err := s.store.Federation.AddRemoteFollower(ctx, user.ID, actor.ID, activity.ID); err != nil {
+		return err
+	}
+	return s.answerFollow(ctx, user, "Accept", actor, activity.ID)
+}
+
+// ApproveFollow accepts the pending follow request of a remote actor.
+func (s *Service) ApproveFollow(ctx context.Context, user *store.User, remoteActorID int64) error {
+	request, err := s.store.Federation.ApproveRemoteFollowRequest(ctx, user.ID, remoteActorID)
+	if err != nil {
+		return err
+	}
+	return s.answerFollow(ctx, user, "Accept", request.Actor(), request.FollowActivityID)
+}
+
+// ApproveAllFollows accepts every pending follow request of remote actors, as
+// when user stops being a private account.
+func (s *Service) ApproveAllFollows(ctx context.Context, user *store.User) error {
+	requests, err := s.store.Federation.ApproveAllRemoteFollowRequests(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range requests {
+		if err := s.answerFollow(ctx, user, "Accept", requests[i].Actor(), requests[i].FollowActivityID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RejectFollow rejects the pending follow request of a remote actor.
+func (s *Service) RejectFollow(ctx context.Context, user *store.User, remoteActorID int64) error {
+	request, err := s.store.Federation.RejectRemoteFollowRequest(ctx, user.ID, remoteActorID)
+	if err != nil {
+		return err
+	}
+	return s.answerFollow(ctx, user, "Reject", request.Actor(), request.FollowActivityID)
+}
+
+// answerFollow queues an Accept or Reject of the Follow followID that actor
+// sent to user.
+func (s *Service) answerFollow(ctx context.Context, user *store.User, answer string, actor *store.RemoteActor, followID string) error {
+	userURI := s.ActorURI(user.Username)
+	object, err := json.Marshal(map[string]string{"id": followID, "type": "Follow", "actor": actor.URI, "object": userURI})
+	if err != nil {
+		return err
+	}
+	return s.enqueue(ctx, user.ID, []string{actor.Inbox}, &Activity{
+		Context: ActivityStreams,
+		ID:      fmt.Sprintf("%s#%ss/%d/%d", userURI, strings.ToLower(answer), actor.ID, time.Now().UnixNano()),
+		Type:    answer,
+		Actor:   userURI,
+		Object:  object,
+	})
+}
+
+func (s *Service) handleUndo(ctx context.Context, user *store.User, actor *store.RemoteActor, activity *Activity) error {
+	// Servers undo follows either with the embedded Follow or with its ID only;
+	// the signature already proves the follower, so both remove the follow.
+	switch objectType(activity.Object) {
+	case "Follow", "":
+		return s.store.Federation.RemoveRemoteFollower(ctx, user.ID, actor.ID)
+	default:
+		return nil
+	}
+}
+
+// handleCreate stores notes that are addressed to user, or publicly reply to a
+// public local post, so they can be listed with it. Other notes are ignored.
+func (s *Service) handleCreate(ctx context.Context, user *store.User, actor *store.RemoteActor, activity *Activity) error {
+	var note Note
+	if err := json.Unmarshal(activity.Object, &note); err != nil {
+		return ErrInvalidActivity
+	}
+	if note.Type != "Note" && note.Type != "Article" {
+		return nil
+	}
+	if note.ID == "" || note.AttributedTo != actor.URI {
+		return ErrInvalidActivity
+	}
+	audience := make(map[string]bool)
+	for _, uris := range [][]string{note.To, note.CC, activity.To, activity.CC} {
+		for _, uri := range uris {
+			audience[uri] = true
+		}
+	}
+	addressed := audience[s.ActorURI(user.Username)]
+	public := audience[Public]
+	postID, err := s.localPostID(ctx, note.InReplyTo)
+	if err != nil {
+		return err
+	}
+	if !addressed && (postID == 0 || !public) {
+		return nil
+	}
+
+	published, err := time.Parse(time.RFC3339, note.Published)
+	if err != nil {
+		published = time.Now()
+	}
+	post := &store.RemotePost{
+		RemoteActorID: actor.ID,
+		URI:           note.ID,
+		URL:           note.URL,
+		ContentHTML:   markdown.Sanitize(note.Content),
+		InReplyTo:     note.InReplyTo,
+		Public:        public,
+		PublishedAt:   published,
+	}
+	if postID != 0 {
+		post.PostID = &postID
+	}
+	var recipientIDs []int64
+	if addressed {
+		recipientIDs = []int64{user.ID}
+	}
+	return s.store.Federation.CreateRemotePost(ctx, post, recipientIDs)
+}
+
+// localPostID returns the ID of the public local post whose Note is uri, or 0
+// when uri is not one.
+func (s *Service) localPostID(ctx context.Context, uri string) (int64, error) {
+	rest, ok := strings.CutPrefix(uri, s.cfg.BaseURL+"/ap/users/")
+	if !ok {
+		return 0, nil
+	}
+	escapedUsername, rawID, ok := strings.Cut(rest, "/posts/")
+	if !ok {
+		return 0, nil
+	}
+	username, err := url.PathUnescape(escapedUsername)
+	if err != nil {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return 0, nil
+	}
+	author, err := s.store.Users.GetByUsername(ctx, username)
+	if err != nil {
+		if errors.Is(err, store.ErrorNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// Anonymous viewers only see public posts.
+	post, err := s.store.Posts.GetByID(ctx, id, 0)
+	if err != nil {
+		if errors.Is(err, store.ErrorNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if post.UserID != author.ID {
+		return 0, nil
+	}
+	return post.ID, nil
+}
+
+// handleDelete removes a remote post, or the whole actor when it deletes itself.
+func (s *Service) handleDelete(ctx context.Context, actor *store.RemoteActor, activity *Activity) error {
+	id := objectID(activity.Object)
+	if id == "" {
+		return ErrInvalidActivity
+	}
+	if id == actor.URI {
+		return s.store.Federation.DeleteRemoteActor(ctx, actor.ID)
+	}
+	return s.store.Federation.DeleteRemotePost(ctx, actor.ID, id)
+}
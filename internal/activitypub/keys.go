@@ -0,0 +1,86 @@
+package activitypub
+
+import (
+	"AwesomeProject/internal/store"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const keyBits = 2048
+
+// userKey returns the keypair of userID, generating one on first use.
+func (s *Service) userKey(ctx context.Context, userID int64) (*store.ActorKey, error) {
+	key, err := s.store.Federation.GetActorKey(ctx, userID)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, store.ErrorNotFound) {
+		return nil, err
+	}
+	generated, err := generateKey(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Federation.CreateActorKey(ctx, generated); err != nil {
+		return nil, err
+	}
+	// Another request may have stored its key first, always use the stored one.
+	return s.store.Federation.GetActorKey(ctx, userID)
+}
+
+func generateKey(userID int64) (*store.ActorKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return &store.ActorKey{
+		UserID:        userID,
+		PublicKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})),
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("activitypub: invalid private key pem")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("activitypub: unsupported private key type %T", key)
+	}
+	return private, nil
+}
+
+// parsePublicKey reads PKIX and PKCS #1 RSA public keys, remote servers use both.
+func parsePublicKey(data string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("activitypub: invalid public key pem")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("activitypub: unsupported public key type %T", key)
+		}
+		return public, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
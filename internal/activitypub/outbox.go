@@ -0,0 +1,211 @@
+package activitypub
+
+import (
+	"AwesomeProject/internal/store"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// OutboxItems is how many recent posts the outbox lists.
+const OutboxItems = 20
+
+// ActorDocument renders the actor of user with its public key.
+func (s *Service) ActorDocument(ctx context.Context, user *store.User, profileURL string) (*Actor, error) {
+	key, err := s.userKey(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	id := s.ActorURI(user.Username)
+	return &Actor{
+		Context:           []string{ActivityStreams, SecurityV1},
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: user.Username,
+		Name:              user.Username,
+		URL:               profileURL,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		Published:         user.CreatedAt,
+		PublicKey: PublicKey{
+			ID:           id + "#main-key",
+			Owner:        id,
+			PublicKeyPEM: key.PublicKeyPEM,
+		},
+	}, nil
+}
+
+// Outbox renders the Create activities of the recent public posts of user.
+func (s *Service) Outbox(ctx context.Context, user *store.User) (*OrderedCollection, error) {
+	posts, _, err := s.store.Posts.GetPublicByUser(ctx, user.ID, OutboxItems)
+	if err != nil {
+		return nil, err
+	}
+	collection := &OrderedCollection{
+		Context:      ActivityStreams,
+		ID:           s.ActorURI(user.Username) + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(posts),
+		OrderedItems: []any{},
+	}
+	for i := range posts {
+		activity, err := s.createActivity(s.Note(user, &posts[i]))
+		if err != nil {
+			return nil, err
+		}
+		activity.Context = nil
+		collection.OrderedItems = append(collection.OrderedItems, activity)
+	}
+	return collection, nil
+}
+
+// Followers renders the size of the remote followers collection of user.
+func (s *Service) Followers(ctx context.Context, user *store.User) (*OrderedCollection, error) {
+	count, err := s.store.Federation.CountRemoteFollowers(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &OrderedCollection{
+		Context:    ActivityStreams,
+		ID:         s.ActorURI(user.Username) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: int(count),
+	}, nil
+}
+
+// PostCreated queues the Create activity of a public post for the remote
+// followers of its author. Other visibilities are not federated, and the posts
+// of private accounts are addressed to their approved followers only.
+func (s *Service) PostCreated(ctx context.Context, user *store.User, post *store.Post) error {
+	if post.Visibility != store.VisibilityPublic {
+		return nil
+	}
+	note, err := s.addressedNote(ctx, user, post)
+	if err != nil {
+		return err
+	}
+	activity, err := s.createActivity(note)
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, user, activity)
+}
+
+// PostUpdated queues what the remote followers of its author need after post
+// was edited from previousVisibility: an Update while it stays public, a
+// Create when it became public and a Delete when it stopped being public.
+func (s *Service) PostUpdated(ctx context.Context, user *store.User, post *store.Post, previousVisibility string) error {
+	switch {
+	case previousVisibility != store.VisibilityPublic:
+		return s.PostCreated(ctx, user, post)
+	case post.Visibility != store.VisibilityPublic:
+		return s.deleteNote(ctx, user, post)
+	}
+	note, err := s.addressedNote(ctx, user, post)
+	if err != nil {
+		return err
+	}
+	activity, err := s.createActivity(note)
+	if err != nil {
+		return err
+	}
+	// Each edit is a new activity, the version tells them apart.
+	activity.ID = fmt.Sprintf("%s#updates/%d", note.ID, post.Version)
+	activity.Type = "Update"
+	return s.publish(ctx, user, activity)
+}
+
+// PostDeleted queues a Delete of post for the remote followers of its author.
+func (s *Service) PostDeleted(ctx context.Context, user *store.User, post *store.Post) error {
+	if post.Visibility != store.VisibilityPublic {
+		return nil
+	}
+	return s.deleteNote(ctx, user, post)
+}
+
+func (s *Service) deleteNote(ctx context.Context, user *store.User, post *store.Post) error {
+	noteID := s.NoteURI(user.Username, post.ID)
+	object, err := json.Marshal(map[string]string{"id": noteID, "type": "Tombstone"})
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, user, &Activity{
+		Context: ActivityStreams,
+		ID:      noteID + "#delete",
+		Type:    "Delete",
+		Actor:   s.ActorURI(user.Username),
+		Object:  object,
+		To:      []string{Public},
+	})
+}
+
+// Note renders post as the Note object its activities carry.
+func (s *Service) Note(user *store.User, post *store.Post) *Note {
+	actorID := s.ActorURI(user.Username)
+	note := &Note{
+		ID:           s.NoteURI(user.Username, post.ID),
+		Type:         "Note",
+		AttributedTo: actorID,
+		Name:         post.Title,
+		Content:      post.ContentHTML,
+		Source:       &Source{Content: post.Content, MediaType: "text/markdown"},
+		Published:    post.CreatedAt,
+		To:           []string{Public},
+		CC:           []string{actorID + "/followers"},
+	}
+	if post.UpdatedAt != "" && post.UpdatedAt != post.CreatedAt {
+		note.Updated = post.UpdatedAt
+	}
+	for _, tag := range post.Tags {
+		note.Tag = append(note.Tag, Tag{Type: "Hashtag", Name: "#" + tag})
+	}
+	return note
+}
+
+// addressedNote renders post for the remote followers of user. The posts of
+// private accounts are addressed to their approved followers only.
+func (s *Service) addressedNote(ctx context.Context, user *store.User, post *store.Post) (*Note, error) {
+	settings, err := s.store.Users.GetSettings(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	note := s.Note(user, post)
+	if settings.IsPrivate {
+		note.To, note.CC = []string{s.ActorURI(user.Username) + "/followers"}, nil
+	}
+	return note, nil
+}
+
+func (s *Service) createActivity(note *Note) (*Activity, error) {
+	object, err := json.Marshal(note)
+	if err != nil {
+		return nil, err
+	}
+	return &Activity{
+		Context:   ActivityStreams,
+		ID:        note.ID + "/activity",
+		Type:      "Create",
+		Actor:     note.AttributedTo,
+		Object:    object,
+		Published: note.Published,
+		To:        note.To,
+		CC:        note.CC,
+	}, nil
+}
+
+func (s *Service) publish(ctx context.Context, user *store.User, activity *Activity) error {
+	inboxes, err := s.store.Federation.GetRemoteFollowerInboxes(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(ctx, user.ID, inboxes, activity)
+}
+
+func (s *Service) enqueue(ctx context.Context, userID int64, inboxes []string, activity *Activity) error {
+	payload, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return s.store.Federation.EnqueueDeliveries(ctx, userID, inboxes, payload)
+}
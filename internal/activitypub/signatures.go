@@ -0,0 +1,211 @@
+package activitypub
+
+import (
+	"AwesomeProject/internal/store"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-fed/httpsig"
+)
+
+// MaxClockSkew bounds how far the Date of a signed request may be from now.
+const MaxClockSkew = 12 * time.Hour
+
+// actorRefreshAfter is how long a cached remote actor is trusted before a failing
+// signature makes us refetch it, in case its key was rotated.
+const actorRefreshAfter = time.Minute * 10
+
+var (
+	ErrInvalidSignature = errors.New("activitypub: invalid http signature")
+	ErrInvalidDigest    = errors.New("activitypub: digest does not match body")
+	ErrStaleRequest     = errors.New("activitypub: request date is out of range")
+)
+
+var signedHeaders = []string{httpsig.RequestTarget, "host", "date", "digest"}
+
+// signRequest signs req and its body with the key of the local actor keyID.
+func signRequest(req *http.Request, keyID string, privateKeyPEM string, body []byte) error {
+	key, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	signer, _, err := httpsig.NewSigner(
+		[]httpsig.Algorithm{httpsig.RSA_SHA256},
+		httpsig.DigestSha256,
+		signedHeaders,
+		httpsig.Signature,
+		0,
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+	return signer.SignRequest(key, keyID, req, body)
+}
+
+// VerifyRequest checks the HTTP signature, date and body digest of an inbox
+// request and returns the remote actor that signed it. The signature must cover
+// the request target, host, date and digest.
+func (s *Service) VerifyRequest(ctx context.Context, r *http.Request, body []byte) (*store.RemoteActor, error) {
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil || time.Since(date).Abs() > MaxClockSkew {
+		return nil, ErrStaleRequest
+	}
+	if err := verifyDigest(r.Header.Get("Digest"), body); err != nil {
+		return nil, err
+	}
+	verifier, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	// A signature over fewer headers could be replayed against another inbox,
+	// with another body or long after it was made.
+	if !signsHeaders(r, signedHeaders...) {
+		return nil, ErrInvalidSignature
+	}
+
+	keyID := verifier.KeyId()
+	actor, err := s.store.Federation.GetRemoteActorByKeyID(ctx, keyID)
+	if errors.Is(err, store.ErrorNotFound) {
+		actor, err = s.fetchActorByKeyID(ctx, keyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if verifyWith(verifier, actor) == nil {
+		return actor, nil
+	}
+	if time.Since(actor.FetchedAt) < actorRefreshAfter {
+		return nil, ErrInvalidSignature
+	}
+	actor, err = s.fetchActorByKeyID(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyWith(verifier, actor); err != nil {
+		return nil, ErrInvalidSignature
+	}
+	return actor, nil
+}
+
+func verifyWith(verifier httpsig.Verifier, actor *store.RemoteActor) error {
+	key, err := parsePublicKey(actor.PublicKeyPEM)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(key, httpsig.RSA_SHA256)
+}
+
+func verifyDigest(header string, body []byte) error {
+	sum := sha256.Sum256(body)
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	for _, part := range strings.Split(header, ",") {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok && strings.EqualFold(algorithm, "SHA-256") && value == expected {
+			return nil
+		}
+	}
+	return ErrInvalidDigest
+}
+
+// signsHeaders reports whether the Signature header covers every header in names.
+func signsHeaders(r *http.Request, names ...string) bool {
+	signature := r.Header.Get("Signature")
+	start := strings.Index(signature, `headers="`)
+	if start < 0 {
+		return false
+	}
+	rest := signature[start+len(`headers="`):]
+	end := strings.Index(rest, `"`)
+	if end < 0 {
+		return false
+	}
+	signed := strings.Fields(strings.ToLower(rest[:end]))
+	for _, name := range names {
+		found := false
+		for _, header := range signed {
+			if header == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// fetchActorByKeyID dereferences the actor owning keyID and caches it.
+func (s *Service) fetchActorByKeyID(ctx context.Context, keyID string) (*store.RemoteActor, error) {
+	keyURL, err := url.Parse(keyID)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	keyURL.Fragment = ""
+	actor, err := s.FetchActor(ctx, keyURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if actor.KeyID != keyID {
+		return nil, ErrInvalidSignature
+	}
+	return actor, nil
+}
+
+// FetchActor dereferences a remote actor document and stores it.
+func (s *Service) FetchActor(ctx context.Context, uri string) (*store.RemoteActor, error) {
+	if u, err := url.Parse(uri); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("activitypub: invalid actor uri %q", uri)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+", "+LDContentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("activitypub: fetching actor %s: unexpected status %d", uri, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	var document Actor
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&document); err != nil {
+		return nil, err
+	}
+	// The document must describe the actor we asked for, and the key must be its own.
+	if document.ID != uri || document.Inbox == "" || document.PublicKey.Owner != document.ID || document.PublicKey.PublicKeyPEM == "" {
+		return nil, fmt.Errorf("activitypub: invalid actor document at %s", uri)
+	}
+	if _, err := parsePublicKey(document.PublicKey.PublicKeyPEM); err != nil {
+		return nil, err
+	}
+	actor := &store.RemoteActor{
+		URI:               document.ID,
+		KeyID:             document.PublicKey.ID,
+		Inbox:             document.Inbox,
+		PreferredUsername: document.PreferredUsername,
+		PublicKeyPEM:      document.PublicKey.PublicKeyPEM,
+	}
+	if err := s.store.Federation.UpsertRemoteActor(ctx, actor); err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
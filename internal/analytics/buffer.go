@@ -0,0 +1,88 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KindImpression = "impression"
+	KindView       = "view"
+)
+
+// Key identifies one counter: a kind of view of a post on a given day (UTC).
+type Key struct {
+	PostID int64
+	Day    string
+	Kind   string
+}
+
+func NewKey(postID int64, kind string, at time.Time) Key {
+	return Key{PostID: postID, Day: at.UTC().Format(time.DateOnly), Kind: kind}
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%d:%s:%s", k.PostID, k.Day, k.Kind)
+}
+
+func parseKey(field string) (Key, error) {
+	parts := strings.Split(field, ":")
+	if len(parts) != 3 {
+		return Key{}, fmt.Errorf("invalid counter key %q", field)
+	}
+	postID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{PostID: postID, Day: parts[1], Kind: parts[2]}, nil
+}
+
+// Buffer accumulates view counters between flushes to Postgres.
+type Buffer interface {
+	Incr(ctx context.Context, keys ...Key) error
+	// Drain returns every counter accumulated so far and resets them.
+	Drain(ctx context.Context) (map[Key]int64, error)
+	// Restore adds drained counters back, for when they could not be flushed.
+	Restore(ctx context.Context, counters map[Key]int64) error
+}
+
+type MemoryBuffer struct {
+	sync.Mutex
+	counters map[Key]int64
+}
+
+func NewMemoryBuffer() *MemoryBuffer {
+	return &MemoryBuffer{
+		counters: make(map[Key]int64),
+	}
+}
+
+func (b *MemoryBuffer) Incr(_ context.Context, keys ...Key) error {
+	b.Lock()
+	defer b.Unlock()
+	for _, key := range keys {
+		b.counters[key]++
+	}
+	return nil
+}
+
+func (b *MemoryBuffer) Drain(_ context.Context) (map[Key]int64, error) {
+	b.Lock()
+	defer b.Unlock()
+	counters := b.counters
+	b.counters = make(map[Key]int64)
+	return counters, nil
+}
+
+func (b *MemoryBuffer) Restore(_ context.Context, counters map[Key]int64) error {
+	b.Lock()
+	defer b.Unlock()
+	for key, count := range counters {
+		b.counters[key] += count
+	}
+	return nil
+}
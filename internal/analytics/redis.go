@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisBufferKey = "post-view-counters"
+
+// RedisBuffer keeps counters in a Redis hash so every API instance shares one buffer.
+type RedisBuffer struct {
+	rdb *redis.Client
+}
+
+func NewRedisBuffer(rdb *redis.Client) *RedisBuffer {
+	return &RedisBuffer{rdb: rdb}
+}
+
+func (b *RedisBuffer) Incr(ctx context.Context, keys ...Key) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := b.rdb.Pipeline()
+	for _, key := range keys {
+		pipe.HIncrBy(ctx, redisBufferKey, key.String(), 1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBuffer) Restore(ctx context.Context, counters map[Key]int64) error {
+	if len(counters) == 0 {
+		return nil
+	}
+	pipe := b.rdb.Pipeline()
+	for key, count := range counters {
+		pipe.HIncrBy(ctx, redisBufferKey, key.String(), count)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Drain renames the hash before reading it, so increments racing with the
+// flush land in a fresh hash instead of being lost.
+func (b *RedisBuffer) Drain(ctx context.Context) (map[Key]int64, error) {
+	flushKey := redisBufferKey + ":flushing:" + uuid.New().String()
+	if err := b.rdb.Rename(ctx, redisBufferKey, flushKey).Err(); err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return map[Key]int64{}, nil
+		}
+		return nil, err
+	}
+	fields, err := b.rdb.HGetAll(ctx, flushKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[Key]int64, len(fields))
+	for field, value := range fields {
+		key, err := parseKey(field)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[key] += count
+	}
+	return counters, b.rdb.Del(ctx, flushKey).Err()
+}
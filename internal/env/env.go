@@ -25,6 +25,16 @@ func GetInt(key string, fallback int) int {
 	return intValue
 }
 
+// GetPositiveInt is GetInt for values that must be above zero, such as
+// durations used as divisors or ticker intervals.
+func GetPositiveInt(key string, fallback int) int {
+	intValue := GetInt(key, fallback)
+	if intValue <= 0 {
+		return fallback
+	}
+	return intValue
+}
+
 func GetBool(key string, fallback bool) bool {
 	value, ok := os.LookupEnv(key)
 	if !ok {
@@ -36,3 +46,15 @@ func GetBool(key string, fallback bool) bool {
 	}
 	return boolValue
 }
+
+func GetFloat(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+	return floatValue
+}
@@ -0,0 +1,252 @@
+package notifications
+
+import (
+	"AwesomeProject/internal/realtime"
+	"AwesomeProject/internal/store"
+	"AwesomeProject/internal/store/cache"
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// Service turns store operations into notifications for the users they concern.
+// Each notification is pushed to the recipient's stream and their cached unread
+// count is dropped.
+type Service struct {
+	store  *store.Storage
+	cache  *cache.Storage
+	events realtime.Broker
+	logger *zap.SugaredLogger
+}
+
+// NewService returns a Service hooked into the store operations of storage that
+// produce notifications. cache may be nil, unread counts are then always read
+// from Postgres.
+func NewService(storage *store.Storage, cache *cache.Storage, events realtime.Broker, logger *zap.SugaredLogger) *Service {
+	s := &Service{store: storage, cache: cache, events: events, logger: logger}
+	if storage.Hooks != nil {
+		s.hook(storage.Hooks)
+	}
+	return s
+}
+
+// hook registers the producers on the store operations they follow. The
+// operations already succeeded by then, so failures are only logged.
+func (s *Service) hook(hooks *store.Hooks) {
+	hooks.Followed = func(ctx context.Context, followerID int64, userID int64, requested bool) {
+		var err error
+		if requested {
+			err = s.FollowRequested(ctx, followerID, userID)
+		} else {
+			err = s.Followed(ctx, followerID, userID)
+		}
+		s.warn(err, "failed to notify follow", "user_id", userID)
+	}
+	hooks.PostSaved = func(ctx context.Context, post *store.Post) {
+		s.warn(s.PostMentioned(ctx, post), "failed to notify mentions", "post_id", post.ID)
+	}
+	hooks.CommentCreated = func(ctx context.Context, comment *store.Comment) {
+		s.warn(s.CommentCreated(ctx, comment), "failed to notify comment", "comment_id", comment.ID)
+	}
+	hooks.Reacted = func(ctx context.Context, reaction *store.Reaction) {
+		s.warn(s.Reacted(ctx, reaction), "failed to notify reaction", "post_id", reaction.PostID)
+	}
+	// Notifications about deleted posts and comments are hidden, which changes
+	// the unread counts of their recipients both ways.
+	hooks.PostDeleted = func(ctx context.Context, postID int64, deleted bool) {
+		s.warn(s.forgetUnreadCountsAbout(ctx, postID, 0), "failed to forget unread counts", "post_id", postID)
+	}
+	hooks.CommentDeleted = func(ctx context.Context, commentID int64, deleted bool) {
+		s.warn(s.forgetUnreadCountsAbout(ctx, 0, commentID), "failed to forget unread counts", "comment_id", commentID)
+	}
+}
+
+func (s *Service) warn(err error, msg string, keysAndValues ...any) {
+	if err != nil {
+		s.logger.Warnw(msg, append(keysAndValues, "error", err.Error())...)
+	}
+}
+
+// Followed notifies userID of their new follower.
+func (s *Service) Followed(ctx context.Context, followerID int64, userID int64) error {
+	return s.notify(ctx, store.NotificationEvent{
+		Type:     store.NotificationFollow,
+		GroupKey: store.NotificationFollow,
+		ActorID:  followerID,
+	}, userID)
+}
+
+// FollowRequested notifies the private account userID of a pending follow request.
+func (s *Service) FollowRequested(ctx context.Context, requesterID int64, userID int64) error {
+	return s.notify(ctx, store.NotificationEvent{
+		Type:     store.NotificationFollowRequest,
+		GroupKey: store.NotificationFollowRequest,
+		ActorID:  requesterID,
+	}, userID)
+}
+
+// CommentCreated notifies the author of the parent comment of a reply, and the
+// author of the post of a comment unless the reply already told them.
+func (s *Service) CommentCreated(ctx context.Context, comment *store.Comment) error {
+	post, err := s.store.Posts.GetByID(ctx, comment.PostID, comment.UserID)
+	if err != nil {
+		return err
+	}
+	postID, commentID := post.ID, comment.ID
+	repliedTo := int64(0)
+	if comment.ParentID != nil {
+		parent, err := s.store.Comments.GetByID(ctx, post.ID, *comment.ParentID)
+		if err != nil {
+			return err
+		}
+		repliedTo = parent.UserID
+		err = s.notify(ctx, store.NotificationEvent{
+			Type:      store.NotificationReply,
+			GroupKey:  fmt.Sprintf("%s:%d", store.NotificationReply, parent.ID),
+			ActorID:   comment.UserID,
+			PostID:    &postID,
+			CommentID: &commentID,
+		}, parent.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	if post.UserID == repliedTo {
+		return nil
+	}
+	return s.notify(ctx, store.NotificationEvent{
+		Type:      store.NotificationComment,
+		GroupKey:  fmt.Sprintf("%s:%d", store.NotificationComment, post.ID),
+		ActorID:   comment.UserID,
+		PostID:    &postID,
+		CommentID: &commentID,
+	}, post.UserID)
+}
+
+// PostMentioned notifies the users mentioned in post who can see it. It runs on
+// create and on every edit, users already told about the post are skipped.
+func (s *Service) PostMentioned(ctx context.Context, post *store.Post) error {
+	if len(store.ParseMentions(post.Content)) == 0 {
+		return nil
+	}
+	recipientIDs, err := s.store.Notifications.GetMentionedUserIDs(ctx, post.ID)
+	if err != nil {
+		return err
+	}
+	postID := post.ID
+	return s.notify(ctx, store.NotificationEvent{
+		Type:     store.NotificationMention,
+		GroupKey: fmt.Sprintf("%s:%d", store.NotificationMention, post.ID),
+		ActorID:  post.UserID,
+		PostID:   &postID,
+	}, recipientIDs...)
+}
+
+// Reacted notifies the author of a post of a new reaction to it. Reactions to
+// the same post coalesce, so the author reads "5 people reacted to your post".
+func (s *Service) Reacted(ctx context.Context, reaction *store.Reaction) error {
+	post, err := s.store.Posts.GetByID(ctx, reaction.PostID, reaction.UserID)
+	if err != nil {
+		return err
+	}
+	postID := post.ID
+	return s.notify(ctx, store.NotificationEvent{
+		Type:     store.NotificationReaction,
+		GroupKey: fmt.Sprintf("%s:%d", store.NotificationReaction, post.ID),
+		ActorID:  reaction.UserID,
+		PostID:   &postID,
+	}, post.UserID)
+}
+
+// UnreadCount returns how many unread notifications userID has.
+func (s *Service) UnreadCount(ctx context.Context, userID int64) (int64, error) {
+	if s.cache != nil {
+		if count, ok, err := s.cache.UnreadNotifications.Get(ctx, userID); err == nil && ok {
+			return count, nil
+		}
+	}
+	count, err := s.store.Notifications.CountUnread(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if s.cache != nil {
+		_ = s.cache.UnreadNotifications.Set(ctx, userID, count)
+	}
+	return count, nil
+}
+
+func (s *Service) MarkRead(ctx context.Context, userID int64, id int64) error {
+	if err := s.store.Notifications.MarkRead(ctx, userID, id); err != nil {
+		return err
+	}
+	return s.forgetUnreadCounts(ctx, userID)
+}
+
+func (s *Service) MarkAllRead(ctx context.Context, userID int64) error {
+	if _, err := s.store.Notifications.MarkAllRead(ctx, userID); err != nil {
+		return err
+	}
+	return s.forgetUnreadCounts(ctx, userID)
+}
+
+func (s *Service) notify(ctx context.Context, event store.NotificationEvent, recipientIDs ...int64) error {
+	ids, err := s.store.Notifications.Notify(ctx, event, unique(recipientIDs))
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	notifications, err := s.store.Notifications.GetByIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+	userIDs := make([]int64, len(notifications))
+	for i := range notifications {
+		userIDs[i] = notifications[i].UserID
+	}
+	if err := s.forgetUnreadCounts(ctx, userIDs...); err != nil {
+		return err
+	}
+	for i := range notifications {
+		n := &notifications[i]
+		published, err := realtime.NewEvent(realtime.EventNotification, n)
+		if err != nil {
+			return err
+		}
+		if err := s.events.Publish(ctx, published, realtime.UserTopic(n.UserID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forgetUnreadCountsAbout drops the cached unread counts of the users with
+// unread notifications about postID or commentID.
+func (s *Service) forgetUnreadCountsAbout(ctx context.Context, postID int64, commentID int64) error {
+	if s.cache == nil {
+		return nil
+	}
+	userIDs, err := s.store.Notifications.GetUnreadRecipientIDs(ctx, postID, commentID)
+	if err != nil || len(userIDs) == 0 {
+		return err
+	}
+	return s.forgetUnreadCounts(ctx, userIDs...)
+}
+
+func (s *Service) forgetUnreadCounts(ctx context.Context, userIDs ...int64) error {
+	if s.cache == nil {
+		return nil
+	}
+	return s.cache.UnreadNotifications.Delete(ctx, userIDs...)
+}
+
+func unique(ids []int64) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	result := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
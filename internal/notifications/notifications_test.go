@@ -0,0 +1,69 @@
+package notifications
+
+import (
+	"AwesomeProject/internal/realtime"
+	"AwesomeProject/internal/store"
+	"context"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// The fakes embed the SQL stores for the methods the producers never call.
+
+type fakePosts struct {
+	*store.PostStore
+	posts map[int64]*store.Post
+}
+
+func (f *fakePosts) GetByID(ctx context.Context, id int64, viewerID int64) (*store.Post, error) {
+	if post, ok := f.posts[id]; ok {
+		return post, nil
+	}
+	return nil, store.ErrorNotFound
+}
+
+type notified struct {
+	event        store.NotificationEvent
+	recipientIDs []int64
+}
+
+type fakeNotifications struct {
+	*store.NotificationStore
+	notified []notified
+}
+
+func (f *fakeNotifications) Notify(ctx context.Context, event store.NotificationEvent, recipientIDs []int64) ([]int64, error) {
+	f.notified = append(f.notified, notified{event, recipientIDs})
+	return nil, nil
+}
+
+func TestHooksProduceNotifications(t *testing.T) {
+	notifications := &fakeNotifications{}
+	storage := &store.Storage{
+		Posts:         &fakePosts{posts: map[int64]*store.Post{7: {ID: 7, UserID: 1}}},
+		Notifications: notifications,
+		Hooks:         &store.Hooks{},
+	}
+	NewService(storage, nil, realtime.NewMemoryBroker(), zap.NewNop().Sugar())
+	ctx := context.Background()
+
+	storage.Hooks.Reacted(ctx, &store.Reaction{PostID: 7, UserID: 2, Kind: store.ReactionLike})
+	storage.Hooks.Reacted(ctx, &store.Reaction{PostID: 7, UserID: 3, Kind: store.ReactionLove})
+	storage.Hooks.Followed(ctx, 2, 1, false)
+	storage.Hooks.Followed(ctx, 3, 1, true)
+	storage.Hooks.CommentCreated(ctx, &store.Comment{ID: 9, PostID: 7, UserID: 2})
+
+	postID, commentID := int64(7), int64(9)
+	want := []notified{
+		{store.NotificationEvent{Type: store.NotificationReaction, GroupKey: "reaction:7", ActorID: 2, PostID: &postID}, []int64{1}},
+		{store.NotificationEvent{Type: store.NotificationReaction, GroupKey: "reaction:7", ActorID: 3, PostID: &postID}, []int64{1}},
+		{store.NotificationEvent{Type: store.NotificationFollow, GroupKey: store.NotificationFollow, ActorID: 2}, []int64{1}},
+		{store.NotificationEvent{Type: store.NotificationFollowRequest, GroupKey: store.NotificationFollowRequest, ActorID: 3}, []int64{1}},
+		{store.NotificationEvent{Type: store.NotificationComment, GroupKey: "comment:7", ActorID: 2, PostID: &postID, CommentID: &commentID}, []int64{1}},
+	}
+	if !reflect.DeepEqual(notifications.notified, want) {
+		t.Errorf("notified:\n%+v\nwant:\n%+v", notifications.notified, want)
+	}
+}
@@ -0,0 +1,214 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	EventFeedPost       = "feed.post"
+	EventNotification   = "notification"
+	EventCommentCreated = "comment.created"
+	EventCommentUpdated = "comment.updated"
+	EventCommentDeleted = "comment.deleted"
+	EventMessageCreated = "message.created"
+	EventMessageRead    = "message.read"
+)
+
+// SubscriptionBuffer is how many events a subscriber may fall behind before it
+// is closed. Clients reconnect and catch up over the REST API.
+const SubscriptionBuffer = 64
+
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	// ActorID is the user behind the event, when streams must drop it for
+	// subscribers on either side of a block with them.
+	ActorID int64 `json:"actor_id,omitempty"`
+}
+
+func NewEvent(eventType string, data any) (Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{Type: eventType, Data: payload}, nil
+}
+
+// UserTopic carries the events addressed to one user: feed items, notifications
+// and the messages of their conversations.
+func UserTopic(userID int64) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// PostTopic carries the comment events of one post to the users viewing it.
+func PostTopic(postID int64) string {
+	return fmt.Sprintf("post:%d", postID)
+}
+
+// Broker fans events out to the subscribers of their topics.
+type Broker interface {
+	Publish(ctx context.Context, event Event, topics ...string) error
+	Subscribe(ctx context.Context, topics ...string) (*Subscription, error)
+}
+
+// Subscription receives the events of the topics it is subscribed to until it is
+// closed, either by its owner or by the broker when it falls behind.
+type Subscription struct {
+	hub    *hub
+	events chan Event
+	topics map[string]bool
+	closed bool
+}
+
+// Events is closed once the subscription is.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Subscribe(ctx context.Context, topics ...string) error {
+	return s.hub.add(ctx, s, topics)
+}
+
+func (s *Subscription) Unsubscribe(ctx context.Context, topics ...string) error {
+	return s.hub.remove(ctx, s, topics)
+}
+
+func (s *Subscription) Close() {
+	s.hub.close(s)
+}
+
+// hub tracks the local subscribers of every topic. watch and unwatch, when set,
+// are told about topics gaining their first or losing their last subscriber so a
+// broker can forward them from elsewhere.
+type hub struct {
+	mu      sync.Mutex
+	topics  map[string]map[*Subscription]struct{}
+	watch   func(ctx context.Context, topics []string) error
+	unwatch func(ctx context.Context, topics []string) error
+}
+
+func newHub() *hub {
+	return &hub{topics: make(map[string]map[*Subscription]struct{})}
+}
+
+func (h *hub) subscribe(ctx context.Context, topics []string) (*Subscription, error) {
+	sub := &Subscription{
+		hub:    h,
+		events: make(chan Event, SubscriptionBuffer),
+		topics: make(map[string]bool),
+	}
+	if err := h.add(ctx, sub, topics); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (h *hub) add(ctx context.Context, sub *Subscription, topics []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if sub.closed {
+		return nil
+	}
+	var first []string
+	for _, topic := range topics {
+		if !sub.topics[topic] && len(h.topics[topic]) == 0 {
+			first = append(first, topic)
+		}
+	}
+	if len(first) > 0 && h.watch != nil {
+		if err := h.watch(ctx, first); err != nil {
+			return err
+		}
+	}
+	for _, topic := range topics {
+		if h.topics[topic] == nil {
+			h.topics[topic] = make(map[*Subscription]struct{})
+		}
+		h.topics[topic][sub] = struct{}{}
+		sub.topics[topic] = true
+	}
+	return nil
+}
+
+func (h *hub) remove(ctx context.Context, sub *Subscription, topics []string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.removeLocked(ctx, sub, topics)
+}
+
+func (h *hub) removeLocked(ctx context.Context, sub *Subscription, topics []string) error {
+	var last []string
+	for _, topic := range topics {
+		if !sub.topics[topic] {
+			continue
+		}
+		delete(sub.topics, topic)
+		delete(h.topics[topic], sub)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+			last = append(last, topic)
+		}
+	}
+	if len(last) > 0 && h.unwatch != nil {
+		return h.unwatch(ctx, last)
+	}
+	return nil
+}
+
+func (h *hub) close(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closeLocked(sub)
+}
+
+func (h *hub) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	topics := make([]string, 0, len(sub.topics))
+	for topic := range sub.topics {
+		topics = append(topics, topic)
+	}
+	// Forwarding stops on a best effort basis, a stale forward only costs traffic.
+	_ = h.removeLocked(context.Background(), sub, topics)
+	sub.closed = true
+	close(sub.events)
+}
+
+// dispatch hands event to the local subscribers of topic without blocking; a
+// subscriber whose buffer is full is closed instead.
+func (h *hub) dispatch(topic string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.topics[topic] {
+		select {
+		case sub.events <- event:
+		default:
+			h.closeLocked(sub)
+		}
+	}
+}
+
+// MemoryBroker delivers events within this process only. It is used when Redis
+// is disabled, which also means a single API instance.
+type MemoryBroker struct {
+	hub *hub
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{hub: newHub()}
+}
+
+func (b *MemoryBroker) Publish(_ context.Context, event Event, topics ...string) error {
+	for _, topic := range topics {
+		b.hub.dispatch(topic, event)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, topics ...string) (*Subscription, error) {
+	return b.hub.subscribe(ctx, topics)
+}
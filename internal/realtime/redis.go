@@ -0,0 +1,86 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisChannelPrefix = "realtime:"
+
+// RedisBroker fans events out across API instances over Redis pub/sub. Each
+// instance holds a single Redis subscription covering the topics its own
+// subscribers need and dispatches incoming messages locally.
+type RedisBroker struct {
+	rdb    *redis.Client
+	pubsub *redis.PubSub
+	hub    *hub
+}
+
+func NewRedisBroker(rdb *redis.Client) *RedisBroker {
+	b := &RedisBroker{
+		rdb:    rdb,
+		pubsub: rdb.Subscribe(context.Background()),
+		hub:    newHub(),
+	}
+	b.hub.watch = func(ctx context.Context, topics []string) error {
+		return b.pubsub.Subscribe(ctx, redisChannels(topics)...)
+	}
+	b.hub.unwatch = func(ctx context.Context, topics []string) error {
+		return b.pubsub.Unsubscribe(ctx, redisChannels(topics)...)
+	}
+	return b
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event Event, topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	pipe := b.rdb.Pipeline()
+	for _, topic := range topics {
+		pipe.Publish(ctx, redisChannelPrefix+topic, payload)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, topics ...string) (*Subscription, error) {
+	return b.hub.subscribe(ctx, topics)
+}
+
+// Run dispatches the messages of the Redis subscription until ctx is done. The
+// client reconnects and resubscribes on its own when the connection drops.
+func (b *RedisBroker) Run(ctx context.Context) {
+	defer b.pubsub.Close()
+
+	messages := b.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			b.hub.dispatch(strings.TrimPrefix(msg.Channel, redisChannelPrefix), event)
+		}
+	}
+}
+
+func redisChannels(topics []string) []string {
+	channels := make([]string, len(topics))
+	for i, topic := range topics {
+		channels[i] = redisChannelPrefix + topic
+	}
+	return channels
+}
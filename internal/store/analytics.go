@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type PostViewCount struct {
+	PostID      int64
+	Day         string
+	Impressions int64
+	Views       int64
+}
+
+type UserAnalytics struct {
+	Since  string           `json:"since"`
+	Totals AnalyticsTotals  `json:"totals"`
+	Posts  []PostAnalytics  `json:"posts"`
+	Days   []DailyAnalytics `json:"days"`
+}
+
+type AnalyticsTotals struct {
+	Impressions  int64 `json:"impressions"`
+	Views        int64 `json:"views"`
+	Comments     int64 `json:"comments"`
+	NewFollowers int64 `json:"new_followers"`
+}
+
+type PostAnalytics struct {
+	PostID      int64  `json:"post_id"`
+	Title       string `json:"title"`
+	CreatedAt   string `json:"created_at"`
+	Impressions int64  `json:"impressions"`
+	Views       int64  `json:"views"`
+	Comments    int64  `json:"comments"`
+}
+
+type DailyAnalytics struct {
+	Day          string `json:"day"`
+	Impressions  int64  `json:"impressions"`
+	Views        int64  `json:"views"`
+	Comments     int64  `json:"comments"`
+	NewFollowers int64  `json:"new_followers"`
+}
+
+type AnalyticsStore struct {
+	db *sql.DB
+}
+
+// AddPostViews adds a batch of buffered counters to the daily totals.
+// Counters of posts purged in the meantime are skipped.
+func (store *AnalyticsStore) AddPostViews(ctx context.Context, counts []PostViewCount) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO post_views_daily (post_id, day, impressions, views)
+		SELECT $1, $2, $3, $4 WHERE EXISTS (SELECT 1 FROM posts WHERE id = $1)
+		ON CONFLICT (post_id, day) DO UPDATE SET
+			impressions = post_views_daily.impressions + EXCLUDED.impressions,
+			views = post_views_daily.views + EXCLUDED.views
+	`
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		stmt, err := tx.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, count := range counts {
+			if _, err := stmt.ExecContext(ctx, count.PostID, count.Day, count.Impressions, count.Views); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetUserAnalytics returns per-post and per-day activity on userID's posts since the given day.
+func (store *AnalyticsStore) GetUserAnalytics(ctx context.Context, userID int64, since time.Time) (*UserAnalytics, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	since = since.UTC().Truncate(24 * time.Hour)
+	analytics := &UserAnalytics{
+		Since: since.Format(time.DateOnly),
+		Posts: []PostAnalytics{},
+		Days:  []DailyAnalytics{},
+	}
+
+	postsQuery := `
+		SELECT p.id, p.title, p.created_at,
+			COALESCE(SUM(v.impressions), 0), COALESCE(SUM(v.views), 0),
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.deleted_at IS NULL AND c.created_at >= $2)
+		FROM posts p
+		LEFT JOIN post_views_daily v ON v.post_id = p.id AND v.day >= $2::date
+		WHERE p.user_id = $1 AND p.deleted_at IS NULL
+		GROUP BY p.id
+		ORDER BY p.created_at DESC
+		LIMIT 100
+	`
+	rows, err := store.db.QueryContext(ctx, postsQuery, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			p         PostAnalytics
+			createdAt time.Time
+		)
+		if err := rows.Scan(&p.PostID, &p.Title, &createdAt, &p.Impressions, &p.Views, &p.Comments); err != nil {
+			return nil, err
+		}
+		p.CreatedAt = createdAt.Format(time.RFC3339)
+		analytics.Posts = append(analytics.Posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	daysQuery := `
+		SELECT d::date, COALESCE(v.impressions, 0), COALESCE(v.views, 0), COALESCE(c.comments, 0), COALESCE(f.followers, 0)
+		FROM generate_series($2::date, CURRENT_DATE, interval '1 day') d
+		LEFT JOIN (
+			SELECT v.day, SUM(v.impressions) AS impressions, SUM(v.views) AS views
+			FROM post_views_daily v JOIN posts p ON p.id = v.post_id
+			WHERE p.user_id = $1 AND v.day >= $2::date
+			GROUP BY v.day
+		) v ON v.day = d::date
+		LEFT JOIN (
+			SELECT c.created_at::date AS day, COUNT(*) AS comments
+			FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE p.user_id = $1 AND c.deleted_at IS NULL AND c.created_at >= $2
+			GROUP BY 1
+		) c ON c.day = d::date
+		LEFT JOIN (
+			SELECT f.created_at::date AS day, COUNT(*) AS followers
+			FROM followers f
+			WHERE f.user_id = $1 AND f.created_at >= $2
+			GROUP BY 1
+		) f ON f.day = d::date
+		ORDER BY d
+	`
+	dayRows, err := store.db.QueryContext(ctx, daysQuery, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer dayRows.Close()
+	for dayRows.Next() {
+		var (
+			d   DailyAnalytics
+			day time.Time
+		)
+		if err := dayRows.Scan(&day, &d.Impressions, &d.Views, &d.Comments, &d.NewFollowers); err != nil {
+			return nil, err
+		}
+		d.Day = day.Format(time.DateOnly)
+		analytics.Days = append(analytics.Days, d)
+		analytics.Totals.Impressions += d.Impressions
+		analytics.Totals.Views += d.Views
+		analytics.Totals.Comments += d.Comments
+		analytics.Totals.NewFollowers += d.NewFollowers
+	}
+	return analytics, dayRows.Err()
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSelfBlock      = errors.New("You cannot block yourself")
+	ErrAlreadyBlocked = errors.New("Already blocked this user")
+	ErrBlocked        = errors.New("You cannot interact with this user")
+)
+
+// RelatedUser is a user listed among the blocks or the mutes of another.
+type RelatedUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+// notBlocked is the SQL condition that neither the user in userA nor the one in
+// userB blocked the other.
+func notBlocked(userA, userB string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM user_blocks ub
+		WHERE (ub.user_id = %[1]s AND ub.blocked_id = %[2]s) OR (ub.user_id = %[2]s AND ub.blocked_id = %[1]s)
+	)`, userA, userB)
+}
+
+type BlockStore struct {
+	db *sql.DB
+}
+
+// Block makes userID block blockedID and drops the follows and follow requests
+// between them in either direction. It returns ErrSelfBlock, ErrorNotFound
+// when blockedID is not an active user and ErrAlreadyBlocked.
+func (store *BlockStore) Block(ctx context.Context, userID int64, blockedID int64) error {
+	if userID == blockedID {
+		return ErrSelfBlock
+	}
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO user_blocks (user_id, blocked_id)
+			SELECT $1, u.id FROM users u WHERE u.id = $2 AND u.is_activated
+		`
+		res, err := tx.ExecContext(ctx, query, userID, blockedID)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrAlreadyBlocked
+			}
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrorNotFound
+		}
+
+		query = `
+			DELETE FROM followers
+			WHERE (user_id = $1 AND follower_id = $2) OR (user_id = $2 AND follower_id = $1)
+		`
+		if _, err := tx.ExecContext(ctx, query, userID, blockedID); err != nil {
+			return err
+		}
+		query = `
+			DELETE FROM follow_requests
+			WHERE (user_id = $1 AND requester_id = $2) OR (user_id = $2 AND requester_id = $1)
+		`
+		_, err = tx.ExecContext(ctx, query, userID, blockedID)
+		return err
+	})
+}
+
+// Unblock returns ErrorNotFound when userID did not block blockedID. Follows
+// dropped by the block are not restored.
+func (store *BlockStore) Unblock(ctx context.Context, userID int64, blockedID int64) error {
+	query := `DELETE FROM user_blocks WHERE user_id = $1 AND blocked_id = $2`
+	return deleteRelation(ctx, store.db, query, userID, blockedID)
+}
+
+// GetBlocked lists the users userID blocked, most recent first.
+func (store *BlockStore) GetBlocked(ctx context.Context, userID int64, fq FollowQuery) ([]RelatedUser, PageInfo, error) {
+	return queryRelatedUsers(ctx, store.db, "user_blocks", "blocked_id", userID, fq)
+}
+
+// GetBlockedIDs returns the users on either side of a block with userID.
+func (store *BlockStore) GetBlockedIDs(ctx context.Context, userID int64) ([]int64, error) {
+	query := `
+		SELECT blocked_id FROM user_blocks WHERE user_id = $1
+		UNION
+		SELECT user_id FROM user_blocks WHERE blocked_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func deleteRelation(ctx context.Context, db *sql.DB, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// queryRelatedUsers lists the users in relatedCol of the rows of table owned by
+// userID.
+func queryRelatedUsers(ctx context.Context, db *sql.DB, table string, relatedCol string, userID int64, fq FollowQuery) ([]RelatedUser, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(fq.Cursor, "desc", "r.created_at", "r."+relatedCol, 3)
+	query := `
+		SELECT u.id, u.username, r.created_at
+		FROM ` + table + ` r
+		JOIN users u ON u.id = r.` + relatedCol + `
+		WHERE r.user_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{userID, fq.Limit + 1}, cursorArgs...)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	users := []RelatedUser{}
+	for rows.Next() {
+		var u RelatedUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.CreatedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	users, page := paginate(users, fq.Limit, fq.Cursor, func(u RelatedUser) Cursor {
+		return cursorAt(u.CreatedAt, u.ID)
+	})
+	return users, page, nil
+}
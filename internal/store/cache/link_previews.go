@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"AwesomeProject/internal/store"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const LinkPreviewExpDate = time.Hour * 24
+
+type LinkPreviewStore struct {
+	rbd *redis.Client
+}
+
+func (s *LinkPreviewStore) Get(ctx context.Context, url string) (*store.LinkPreview, error) {
+	data, err := s.rbd.Get(ctx, linkPreviewKey(url)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var preview store.LinkPreview
+	if err := json.Unmarshal([]byte(data), &preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+func (s *LinkPreviewStore) Set(ctx context.Context, preview *store.LinkPreview) error {
+	if preview == nil {
+		return errors.New("preview is nil")
+	}
+	data, err := json.Marshal(preview)
+	if err != nil {
+		return err
+	}
+	return s.rbd.Set(ctx, linkPreviewKey(preview.URL), string(data), LinkPreviewExpDate).Err()
+}
+
+func linkPreviewKey(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return "link-preview-" + hex.EncodeToString(hash[:])
+}
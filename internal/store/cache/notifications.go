@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const UnreadNotificationsExpDate = time.Hour * 24
+
+// UnreadNotificationsStore caches how many unread notifications each user has.
+type UnreadNotificationsStore struct {
+	rbd *redis.Client
+}
+
+// Get returns the cached count and whether there was one.
+func (s *UnreadNotificationsStore) Get(ctx context.Context, userID int64) (int64, bool, error) {
+	count, err := s.rbd.Get(ctx, unreadNotificationsKey(userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return count, true, nil
+}
+
+func (s *UnreadNotificationsStore) Set(ctx context.Context, userID int64, count int64) error {
+	return s.rbd.Set(ctx, unreadNotificationsKey(userID), count, UnreadNotificationsExpDate).Err()
+}
+
+// Delete drops the counts of userIDs, they are recounted on their next read.
+func (s *UnreadNotificationsStore) Delete(ctx context.Context, userIDs ...int64) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	keys := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = unreadNotificationsKey(id)
+	}
+	return s.rbd.Del(ctx, keys...).Err()
+}
+
+func unreadNotificationsKey(userID int64) string {
+	return fmt.Sprintf("unread-notifications-%d", userID)
+}
@@ -12,6 +12,15 @@ type Storage struct {
 		Get(context.Context, int64) (*store.User, error)
 		Set(context.Context, *store.User) error
 	}
+	LinkPreviews interface {
+		Get(context.Context, string) (*store.LinkPreview, error)
+		Set(context.Context, *store.LinkPreview) error
+	}
+	UnreadNotifications interface {
+		Get(context.Context, int64) (int64, bool, error)
+		Set(context.Context, int64, int64) error
+		Delete(context.Context, ...int64) error
+	}
 }
 
 func NewRedisStorage(rbd *redis.Client) Storage {
@@ -19,5 +28,11 @@ func NewRedisStorage(rbd *redis.Client) Storage {
 		Users: &UserStore{
 			rbd: rbd,
 		},
+		LinkPreviews: &LinkPreviewStore{
+			rbd: rbd,
+		},
+		UnreadNotifications: &UnreadNotificationsStore{
+			rbd: rbd,
+		},
 	}
 }
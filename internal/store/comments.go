@@ -1,71 +1,413 @@
 package store
 
 import (
+	"AwesomeProject/internal/markdown"
 	"context"
 	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	MaxCommentDepth       = 5
+	deletedCommentContent = "[deleted]"
+)
+
+var (
+	ErrParentCommentNotFound = errors.New("Parent comment not found")
+	ErrMaxCommentDepth       = errors.New("Maximum reply depth reached")
 )
 
 type Comment struct {
-	ID        int64  `json:"id"`
-	PostID    int64  `json:"post_id"`
-	UserID    int64  `json:"user_id"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	User      User   `json:"user"`
+	ID          int64   `json:"id"`
+	PostID      int64   `json:"post_id"`
+	UserID      int64   `json:"user_id"`
+	ParentID    *int64  `json:"parent_id"`
+	Depth       int     `json:"depth"`
+	Content     string  `json:"content"`
+	ContentHTML string  `json:"content_html"`
+	CreatedAt   string  `json:"created_at"`
+	EditedAt    *string `json:"edited_at"`
+	Deleted     bool    `json:"deleted"`
+	ReplyCount  int     `json:"reply_count"`
+	User        User    `json:"user"`
 }
 
 type CommentStore struct {
-	db *sql.DB
+	db    *sql.DB
+	hooks *Hooks
 }
 
-func (store *CommentStore) GetByPostID(ctx context.Context, postID int64) ([]Comment, error) {
-	query := `
-		SELECT c.id, c.post_id, c.user_id, c.content, c.created_at, users.username, users.id FROM comments c 
+// commentVisible keeps deleted comments that still have a live reply at any
+// depth below them, so they can be shown as a placeholder and the thread keeps
+// its structure. The recursion is bounded by MaxCommentDepth.
+func commentVisible(alias string) string {
+	return `(` + alias + `.deleted_at IS NULL OR EXISTS (
+		WITH RECURSIVE descendants AS (
+			SELECT cr.id, cr.deleted_at FROM comments cr WHERE cr.parent_id = ` + alias + `.id
+			UNION ALL
+			SELECT cr.id, cr.deleted_at FROM comments cr JOIN descendants cd ON cr.parent_id = cd.id
+		)
+		SELECT 1 FROM descendants WHERE deleted_at IS NULL
+	))`
+}
+
+// GetReplies returns one page of the direct replies to parentID, or of the
+// top-level comments of the post when parentID is nil, with their reply counts.
+// Comments of users in a block with viewerID are left out with their replies.
+func (store *CommentStore) GetReplies(ctx context.Context, postID int64, parentID *int64, viewerID int64, query PaginatedCommentsQuery) ([]Comment, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(query.Cursor, query.Sort, "c.created_at", "c.id", 5)
+	sqlQuery := `
+		SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.content_html, c.created_at, c.edited_at,
+			c.deleted_at IS NOT NULL, users.username,
+			(
+				SELECT COUNT(*) FROM comments r
+				WHERE r.parent_id = c.id AND ` + commentVisible("r") + ` AND ` + notBlocked("r.user_id", "$4") + `
+			)
+		FROM comments c
 		JOIN users ON c.user_id = users.id
-		WHERE c.post_id = $1
-		ORDER BY c.created_at DESC;
+		WHERE c.post_id = $1 AND c.parent_id IS NOT DISTINCT FROM $2 AND ` + commentVisible("c") + ` AND
+			` + notBlocked("c.user_id", "$4") + ` AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $3
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	rows, err := store.db.QueryContext(ctx, query, postID)
+	args := append([]any{postID, parentID, query.Limit + 1, viewerID}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, sqlQuery, args...)
 	if err != nil {
-		return nil, err
+		return nil, PageInfo{}, err
 	}
 	defer rows.Close()
 	comments := []Comment{}
 	for rows.Next() {
-		var comment Comment
-		comment.User = User{}
-		err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.Content, &comment.CreatedAt, &comment.User.Username, &comment.User.ID)
+		var (
+			comment    Comment
+			cachedHTML sql.NullString
+			createdAt  time.Time
+			editedAt   sql.NullTime
+		)
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.UserID,
+			&comment.ParentID,
+			&comment.Depth,
+			&comment.Content,
+			&cachedHTML,
+			&createdAt,
+			&editedAt,
+			&comment.Deleted,
+			&comment.User.Username,
+			&comment.ReplyCount,
+		)
 		if err != nil {
-			return nil, err
+			return nil, PageInfo{}, err
+		}
+		comment.User.ID = comment.UserID
+		comment.CreatedAt = createdAt.Format(time.RFC3339)
+		comment.EditedAt = formatNullTime(editedAt)
+		if comment.Deleted {
+			comment.Content = deletedCommentContent
+			comment.ContentHTML = "<p>" + deletedCommentContent + "</p>"
+			comment.UserID = 0
+			comment.User = User{}
+		} else {
+			comment.ContentHTML = contentHTML(comment.Content, cachedHTML)
 		}
 		comments = append(comments, comment)
 	}
-	return comments, nil
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	comments, page := paginate(comments, query.Limit, query.Cursor, func(c Comment) Cursor {
+		return cursorAt(c.CreatedAt, c.ID)
+	})
+	return comments, page, nil
 }
 
+// CreateComments inserts the comment, as a reply when ParentID is set.
+// The parent must be a live comment of the same post within the depth limit.
 func (store *CommentStore) CreateComments(ctx context.Context, comment *Comment) error {
+	if err := store.createComment(ctx, comment); err != nil {
+		return err
+	}
+	store.hooks.commentCreated(ctx, comment)
+	return nil
+}
+
+func (store *CommentStore) createComment(ctx context.Context, comment *Comment) error {
+	// The lock is checked again in the insert, holding the post row, so a
+	// comment can not slip in while the post is being locked.
 	query := `
-		INSERT INTO comments (post_id, user_id, content) VALUES ($1, $2, $3)
+		INSERT INTO comments (post_id, user_id, parent_id, depth, content, content_html)
+		SELECT p.id, $2::bigint, $3::bigint, $4::int, $5::text, $6::text FROM posts p
+		WHERE p.id = $1 AND p.deleted_at IS NULL AND p.comments_locked_at IS NULL
+		FOR SHARE
 		RETURNING id, created_at
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
+	var locked, blocked bool
 	err := store.db.QueryRowContext(
+		ctx,
+		`SELECT p.comments_locked_at IS NOT NULL, NOT `+notBlocked("p.user_id", "$2")+` FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL`,
+		comment.PostID,
+		comment.UserID,
+	).Scan(&locked, &blocked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		default:
+			return err
+		}
+	}
+	if locked {
+		return ErrCommentsLocked
+	}
+	if blocked {
+		return ErrBlocked
+	}
+
+	if comment.ParentID != nil {
+		var parentDepth int
+		err := store.db.QueryRowContext(
+			ctx,
+			`SELECT c.depth, NOT `+notBlocked("c.user_id", "$3")+` FROM comments c WHERE c.id = $1 AND c.post_id = $2 AND c.deleted_at IS NULL`,
+			*comment.ParentID,
+			comment.PostID,
+			comment.UserID,
+		).Scan(&parentDepth, &blocked)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrParentCommentNotFound
+			default:
+				return err
+			}
+		}
+		if blocked {
+			return ErrBlocked
+		}
+		if parentDepth+1 > MaxCommentDepth {
+			return ErrMaxCommentDepth
+		}
+		comment.Depth = parentDepth + 1
+	}
+
+	html, err := markdown.Render(comment.Content)
+	if err != nil {
+		return err
+	}
+	comment.ContentHTML = html
+
+	err = store.db.QueryRowContext(
 		ctx,
 		query,
 		comment.PostID,
 		comment.UserID,
+		comment.ParentID,
+		comment.Depth,
 		comment.Content,
+		comment.ContentHTML,
 	).Scan(
 		&comment.ID,
 		&comment.CreatedAt,
 	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrCommentsLocked
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetByID returns a live comment of postID.
+func (store *CommentStore) GetByID(ctx context.Context, postID int64, id int64) (*Comment, error) {
+	query := `
+		SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.content_html, c.created_at, c.edited_at, users.username
+		FROM comments c
+		JOIN users ON c.user_id = users.id
+		WHERE c.id = $1 AND c.post_id = $2 AND c.deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var (
+		comment    Comment
+		cachedHTML sql.NullString
+		createdAt  time.Time
+		editedAt   sql.NullTime
+	)
+	err := store.db.QueryRowContext(ctx, query, id, postID).Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.ParentID,
+		&comment.Depth,
+		&comment.Content,
+		&cachedHTML,
+		&createdAt,
+		&editedAt,
+		&comment.User.Username,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	comment.User.ID = comment.UserID
+	comment.ContentHTML = contentHTML(comment.Content, cachedHTML)
+	comment.CreatedAt = createdAt.Format(time.RFC3339)
+	comment.EditedAt = formatNullTime(editedAt)
+	return &comment, nil
+}
+
+// Update replaces the content of a live comment and stamps it as edited.
+func (store *CommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `
+		UPDATE comments SET content = $2, content_html = $3, edited_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING edited_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	html, err := markdown.Render(comment.Content)
+	if err != nil {
+		return err
+	}
+	comment.ContentHTML = html
+
+	var editedAt sql.NullTime
+	err = store.db.QueryRowContext(ctx, query, comment.ID, comment.Content, comment.ContentHTML).Scan(&editedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorNotFound
+		default:
+			return err
+		}
+	}
+	comment.EditedAt = formatNullTime(editedAt)
+	return nil
+}
+
+// Delete soft deletes the comment. It can be restored until Purge removes it.
+func (store *CommentStore) Delete(ctx context.Context, id int64) error {
+	if err := store.delete(ctx, id); err != nil {
+		return err
+	}
+	store.hooks.commentDeleted(ctx, id, true)
+	return nil
+}
+
+func (store *CommentStore) delete(ctx context.Context, id int64) error {
+	query := `
+		UPDATE comments SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// GetDeletedByID returns a comment of postID that was soft deleted after deletedAfter.
+func (store *CommentStore) GetDeletedByID(ctx context.Context, postID int64, id int64, deletedAfter time.Time) (*Comment, error) {
+	query := `
+		SELECT id, post_id, user_id, content, content_html, created_at FROM comments
+		WHERE id = $1 AND post_id = $2 AND deleted_at > $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var (
+		comment    Comment
+		cachedHTML sql.NullString
+	)
+	err := store.db.QueryRowContext(ctx, query, id, postID, deletedAfter).Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.Content,
+		&cachedHTML,
+		&comment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	comment.ContentHTML = contentHTML(comment.Content, cachedHTML)
+	return &comment, nil
+}
+
+// Restore undoes a soft delete made after deletedAfter.
+func (store *CommentStore) Restore(ctx context.Context, id int64, deletedAfter time.Time) error {
+	if err := store.restore(ctx, id, deletedAfter); err != nil {
+		return err
+	}
+	store.hooks.commentDeleted(ctx, id, false)
+	return nil
+}
+
+func (store *CommentStore) restore(ctx context.Context, id int64, deletedAfter time.Time) error {
+	query := `
+		UPDATE comments SET deleted_at = NULL WHERE id = $1 AND deleted_at > $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, id, deletedAfter)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
 	return nil
 }
+
+// Purge hard deletes comments soft deleted before deletedBefore. Comments that
+// still have replies are kept as placeholders until their replies are gone.
+func (store *CommentStore) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM comments c WHERE c.deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = c.id)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,588 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	CommunityJoinOpen    = "open"
+	CommunityJoinRequest = "request"
+	CommunityJoinInvite  = "invite"
+)
+
+const (
+	CommunityRoleMember    = "member"
+	CommunityRoleModerator = "moderator"
+	CommunityRoleOwner     = "owner"
+)
+
+// A membership is active once the user is in the community. Pending members
+// asked to join a community that reviews requests, invited ones were asked by
+// a moderator and have not accepted yet.
+const (
+	CommunityMemberActive  = "active"
+	CommunityMemberPending = "pending"
+	CommunityMemberInvited = "invited"
+)
+
+var (
+	ErrDuplicateCommunitySlug = errors.New("Duplicate community slug")
+	ErrCommunityBanned        = errors.New("You are banned from this community")
+	ErrCommunityInviteOnly    = errors.New("This community is invite only")
+	ErrNotCommunityMember     = errors.New("You are not a member of this community")
+	ErrCommunityOwner         = errors.New("The community owner cannot be removed")
+)
+
+type Community struct {
+	ID           int64            `json:"id"`
+	Slug         string           `json:"slug"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description"`
+	Rules        []string         `json:"rules"`
+	JoinPolicy   string           `json:"join_policy"`
+	CreatedBy    *int64           `json:"created_by"`
+	CreatedAt    string           `json:"created_at"`
+	UpdatedAt    string           `json:"updated_at"`
+	MembersCount int64            `json:"members_count"`
+	Membership   *CommunityMember `json:"membership"`
+}
+
+type CommunityMember struct {
+	CommunityID int64  `json:"community_id"`
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	Status      string `json:"status"`
+	InvitedBy   *int64 `json:"invited_by"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type CommunityBan struct {
+	CommunityID int64  `json:"community_id"`
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	BannedBy    *int64 `json:"banned_by"`
+	Reason      string `json:"reason"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type CommunityStore struct {
+	db *sql.DB
+}
+
+// Create adds a community owned by its creator.
+func (store *CommunityStore) Create(ctx context.Context, community *Community) error {
+	if community.JoinPolicy == "" {
+		community.JoinPolicy = CommunityJoinOpen
+	}
+	if community.Rules == nil {
+		community.Rules = []string{}
+	}
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO communities (slug, name, description, rules, join_policy, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id, created_at, updated_at
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			community.Slug,
+			community.Name,
+			community.Description,
+			pq.Array(community.Rules),
+			community.JoinPolicy,
+			community.CreatedBy,
+		).Scan(&community.ID, &community.CreatedAt, &community.UpdatedAt)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrDuplicateCommunitySlug
+			}
+			return err
+		}
+
+		query = `
+			INSERT INTO community_members (community_id, user_id, role, status)
+			VALUES ($1, $2, $3, $4)
+			RETURNING created_at
+		`
+		owner := CommunityMember{
+			CommunityID: community.ID,
+			UserID:      *community.CreatedBy,
+			Role:        CommunityRoleOwner,
+			Status:      CommunityMemberActive,
+		}
+		if err := tx.QueryRowContext(ctx, query, owner.CommunityID, owner.UserID, owner.Role, owner.Status).Scan(&owner.CreatedAt); err != nil {
+			return err
+		}
+		community.MembersCount = 1
+		community.Membership = &owner
+		return nil
+	})
+}
+
+// GetBySlug returns the community with the membership of viewerID, if any.
+func (store *CommunityStore) GetBySlug(ctx context.Context, slug string, viewerID int64) (*Community, error) {
+	communities, err := store.query(ctx, viewerID, `c.slug = $2`, `c.id`, slug)
+	if err != nil {
+		return nil, err
+	}
+	if len(communities) == 0 {
+		return nil, ErrorNotFound
+	}
+	return &communities[0], nil
+}
+
+// GetAll lists the communities, newest first, or only those viewerID belongs
+// to when the query asks for joined ones.
+func (store *CommunityStore) GetAll(ctx context.Context, viewerID int64, cq CommunityQuery) ([]Community, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(cq.Cursor, "desc", "c.created_at", "c.id", 5)
+	condition = `($3::text = '' OR c.name ILIKE '%' || $3 || '%' OR c.slug ILIKE '%' || $3 || '%') AND
+		(NOT $4::boolean OR m.status = 'active') AND ` + condition
+	args := append([]any{cq.Limit + 1, cq.Search, cq.Joined}, cursorArgs...)
+	communities, err := store.query(ctx, viewerID, condition, orderBy+` LIMIT $2`, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	communities, page := paginate(communities, cq.Limit, cq.Cursor, func(c Community) Cursor {
+		return cursorAt(c.CreatedAt, c.ID)
+	})
+	return communities, page, nil
+}
+
+// query reads the communities matching condition as seen by viewerID, who is
+// bound to $1. Extra arguments start at $2.
+func (store *CommunityStore) query(ctx context.Context, viewerID int64, condition string, orderBy string, args ...any) ([]Community, error) {
+	query := `
+		SELECT c.id, c.slug, c.name, c.description, c.rules, c.join_policy, c.created_by, c.created_at, c.updated_at,
+			(SELECT COUNT(*) FROM community_members cm WHERE cm.community_id = c.id AND cm.status = 'active'),
+			m.role, m.status, m.invited_by, m.created_at
+		FROM communities c
+		LEFT JOIN community_members m ON m.community_id = c.id AND m.user_id = $1
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, append([]any{viewerID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	communities := []Community{}
+	for rows.Next() {
+		var (
+			c         Community
+			createdBy sql.NullInt64
+			role      sql.NullString
+			status    sql.NullString
+			invitedBy sql.NullInt64
+			joinedAt  sql.NullString
+		)
+		err := rows.Scan(
+			&c.ID,
+			&c.Slug,
+			&c.Name,
+			&c.Description,
+			pq.Array(&c.Rules),
+			&c.JoinPolicy,
+			&createdBy,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.MembersCount,
+			&role,
+			&status,
+			&invitedBy,
+			&joinedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if createdBy.Valid {
+			c.CreatedBy = &createdBy.Int64
+		}
+		if role.Valid {
+			c.Membership = &CommunityMember{
+				CommunityID: c.ID,
+				UserID:      viewerID,
+				Role:        role.String,
+				Status:      status.String,
+				CreatedAt:   joinedAt.String,
+			}
+			if invitedBy.Valid {
+				c.Membership.InvitedBy = &invitedBy.Int64
+			}
+		}
+		if c.Rules == nil {
+			c.Rules = []string{}
+		}
+		communities = append(communities, c)
+	}
+	return communities, rows.Err()
+}
+
+// Update saves the name, description, rules and join policy of community.
+// Switching to an open community lets its pending members in.
+func (store *CommunityStore) Update(ctx context.Context, community *Community) error {
+	if community.Rules == nil {
+		community.Rules = []string{}
+	}
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE communities SET name = $2, description = $3, rules = $4, join_policy = $5, updated_at = NOW()
+			WHERE id = $1
+			RETURNING updated_at
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			community.ID,
+			community.Name,
+			community.Description,
+			pq.Array(community.Rules),
+			community.JoinPolicy,
+		).Scan(&community.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrorNotFound
+			}
+			return err
+		}
+		if community.JoinPolicy != CommunityJoinOpen {
+			return nil
+		}
+		query = `UPDATE community_members SET status = 'active' WHERE community_id = $1 AND status = 'pending'`
+		_, err = tx.ExecContext(ctx, query, community.ID)
+		return err
+	})
+}
+
+// Join adds userID to community according to its join policy: open communities
+// let them in, request ones record a pending request and invite only ones
+// require an invitation, which joining accepts. Banned users are refused.
+func (store *CommunityStore) Join(ctx context.Context, community *Community, userID int64) (*CommunityMember, error) {
+	status := CommunityMemberActive
+	if community.JoinPolicy == CommunityJoinRequest {
+		status = CommunityMemberPending
+	}
+	query := `
+		INSERT INTO community_members (community_id, user_id, status)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (SELECT 1 FROM community_bans b WHERE b.community_id = $1 AND b.user_id = $2) AND
+			($4::boolean OR EXISTS (
+				SELECT 1 FROM community_members cm WHERE cm.community_id = $1 AND cm.user_id = $2
+			))
+		ON CONFLICT (community_id, user_id) DO UPDATE SET
+			status = CASE WHEN community_members.status = 'invited' THEN 'active' ELSE community_members.status END
+		RETURNING role, status, invited_by, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	member := &CommunityMember{CommunityID: community.ID, UserID: userID}
+	var invitedBy sql.NullInt64
+	err := store.db.QueryRowContext(ctx, query, community.ID, userID, status, community.JoinPolicy != CommunityJoinInvite).Scan(
+		&member.Role,
+		&member.Status,
+		&invitedBy,
+		&member.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		banned, err := store.IsBanned(ctx, community.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, ErrCommunityBanned
+		}
+		return nil, ErrCommunityInviteOnly
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invitedBy.Valid {
+		member.InvitedBy = &invitedBy.Int64
+	}
+	return member, nil
+}
+
+// Invite asks userID to join the community. Inviting a user who asked to join
+// lets them in.
+func (store *CommunityStore) Invite(ctx context.Context, communityID int64, userID int64, invitedBy int64) (*CommunityMember, error) {
+	query := `
+		INSERT INTO community_members (community_id, user_id, status, invited_by)
+		SELECT $1, u.id, 'invited', $3 FROM users u
+		WHERE u.id = $2 AND u.is_activated AND
+			NOT EXISTS (SELECT 1 FROM community_bans b WHERE b.community_id = $1 AND b.user_id = $2)
+		ON CONFLICT (community_id, user_id) DO UPDATE SET
+			status = CASE WHEN community_members.status = 'pending' THEN 'active' ELSE community_members.status END
+		RETURNING role, status, invited_by, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	member := &CommunityMember{CommunityID: communityID, UserID: userID}
+	var invitedByID sql.NullInt64
+	err := store.db.QueryRowContext(ctx, query, communityID, userID, invitedBy).Scan(
+		&member.Role,
+		&member.Status,
+		&invitedByID,
+		&member.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		banned, err := store.IsBanned(ctx, communityID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if banned {
+			return nil, ErrCommunityBanned
+		}
+		return nil, ErrorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invitedByID.Valid {
+		member.InvitedBy = &invitedByID.Int64
+	}
+	return member, nil
+}
+
+// GetMember returns the membership of userID in any status.
+func (store *CommunityStore) GetMember(ctx context.Context, communityID int64, userID int64) (*CommunityMember, error) {
+	members, err := store.queryMembers(ctx, communityID, `m.user_id = $2`, `m.user_id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, ErrorNotFound
+	}
+	return &members[0], nil
+}
+
+// GetMembers lists the members of the community in the status asked for,
+// longest standing first.
+func (store *CommunityStore) GetMembers(ctx context.Context, communityID int64, cq CommunityMemberQuery) ([]CommunityMember, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(cq.Cursor, "asc", "m.created_at", "m.user_id", 4)
+	args := append([]any{cq.Limit + 1, cq.Status}, cursorArgs...)
+	members, err := store.queryMembers(ctx, communityID, `m.status = $3 AND `+condition, orderBy+` LIMIT $2`, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	members, page := paginate(members, cq.Limit, cq.Cursor, func(m CommunityMember) Cursor {
+		return cursorAt(m.CreatedAt, m.UserID)
+	})
+	return members, page, nil
+}
+
+// queryMembers reads the members of communityID, bound to $1, matching
+// condition. Extra arguments start at $2.
+func (store *CommunityStore) queryMembers(ctx context.Context, communityID int64, condition string, orderBy string, args ...any) ([]CommunityMember, error) {
+	query := `
+		SELECT m.community_id, m.user_id, u.username, m.role, m.status, m.invited_by, m.created_at
+		FROM community_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.community_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, append([]any{communityID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []CommunityMember{}
+	for rows.Next() {
+		var (
+			m         CommunityMember
+			invitedBy sql.NullInt64
+		)
+		if err := rows.Scan(&m.CommunityID, &m.UserID, &m.Username, &m.Role, &m.Status, &invitedBy, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		if invitedBy.Valid {
+			m.InvitedBy = &invitedBy.Int64
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// ApproveMember lets a pending member in.
+func (store *CommunityStore) ApproveMember(ctx context.Context, communityID int64, userID int64) error {
+	query := `
+		UPDATE community_members SET status = 'active'
+		WHERE community_id = $1 AND user_id = $2 AND status = 'pending'
+	`
+	return store.execMember(ctx, query, communityID, userID)
+}
+
+// SetMemberRole makes an active member a moderator or a plain member again.
+// The owner keeps their role.
+func (store *CommunityStore) SetMemberRole(ctx context.Context, communityID int64, userID int64, role string) error {
+	query := `
+		UPDATE community_members SET role = $3
+		WHERE community_id = $1 AND user_id = $2 AND status = 'active' AND role <> 'owner'
+	`
+	return store.execMember(ctx, query, communityID, userID, role)
+}
+
+// RemoveMember deletes a membership whatever its status, so it also serves to
+// leave, decline a request and withdraw an invitation. The owner cannot be
+// removed.
+func (store *CommunityStore) RemoveMember(ctx context.Context, communityID int64, userID int64) error {
+	query := `
+		DELETE FROM community_members
+		WHERE community_id = $1 AND user_id = $2 AND role <> 'owner'
+	`
+	err := store.execMember(ctx, query, communityID, userID)
+	if !errors.Is(err, ErrorNotFound) {
+		return err
+	}
+	if _, err := store.GetMember(ctx, communityID, userID); err != nil {
+		return err
+	}
+	return ErrCommunityOwner
+}
+
+func (store *CommunityStore) execMember(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := store.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// CheckCanPost returns ErrCommunityBanned for banned users and
+// ErrNotCommunityMember for anyone else who is not an active member.
+func (store *CommunityStore) CheckCanPost(ctx context.Context, communityID int64, userID int64) error {
+	banned, err := store.IsBanned(ctx, communityID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrCommunityBanned
+	}
+	member, err := store.GetMember(ctx, communityID, userID)
+	if errors.Is(err, ErrorNotFound) || (err == nil && member.Status != CommunityMemberActive) {
+		return ErrNotCommunityMember
+	}
+	return err
+}
+
+func (store *CommunityStore) IsBanned(ctx context.Context, communityID int64, userID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM community_bans WHERE community_id = $1 AND user_id = $2)`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var banned bool
+	err := store.db.QueryRowContext(ctx, query, communityID, userID).Scan(&banned)
+	return banned, err
+}
+
+// Ban removes the user from the community and keeps them from joining again.
+// Banning again updates the reason. The owner cannot be banned.
+func (store *CommunityStore) Ban(ctx context.Context, ban *CommunityBan) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			DELETE FROM community_members
+			WHERE community_id = $1 AND user_id = $2
+			RETURNING role
+		`
+		var role string
+		err := tx.QueryRowContext(ctx, query, ban.CommunityID, ban.UserID).Scan(&role)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if role == CommunityRoleOwner {
+			return ErrCommunityOwner
+		}
+
+		query = `
+			INSERT INTO community_bans (community_id, user_id, banned_by, reason)
+			SELECT $1, u.id, $3, $4 FROM users u WHERE u.id = $2
+			ON CONFLICT (community_id, user_id) DO UPDATE SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason
+			RETURNING (SELECT username FROM users WHERE id = $2), created_at
+		`
+		err = tx.QueryRowContext(ctx, query, ban.CommunityID, ban.UserID, ban.BannedBy, ban.Reason).Scan(&ban.Username, &ban.CreatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrorNotFound
+		}
+		return err
+	})
+}
+
+func (store *CommunityStore) Unban(ctx context.Context, communityID int64, userID int64) error {
+	query := `DELETE FROM community_bans WHERE community_id = $1 AND user_id = $2`
+	return store.execMember(ctx, query, communityID, userID)
+}
+
+// GetBans lists the users banned from the community, most recent first.
+func (store *CommunityStore) GetBans(ctx context.Context, communityID int64, cq CommunityMemberQuery) ([]CommunityBan, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(cq.Cursor, "desc", "b.created_at", "b.user_id", 3)
+	query := `
+		SELECT b.community_id, b.user_id, u.username, b.banned_by, b.reason, b.created_at
+		FROM community_bans b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.community_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{communityID, cq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	bans := []CommunityBan{}
+	for rows.Next() {
+		var (
+			b        CommunityBan
+			bannedBy sql.NullInt64
+		)
+		if err := rows.Scan(&b.CommunityID, &b.UserID, &b.Username, &bannedBy, &b.Reason, &b.CreatedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		if bannedBy.Valid {
+			b.BannedBy = &bannedBy.Int64
+		}
+		bans = append(bans, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	bans, page := paginate(bans, cq.Limit, cq.Cursor, func(b CommunityBan) Cursor {
+		return cursorAt(b.CreatedAt, b.UserID)
+	})
+	return bans, page, nil
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"AwesomeProject/internal/markdown"
+	"database/sql"
+	"html"
+)
+
+// contentHTML returns the HTML cached next to content, rendering it for rows
+// written before content_html existed.
+func contentHTML(content string, cached sql.NullString) string {
+	if cached.Valid {
+		return cached.String
+	}
+	rendered, err := markdown.Render(content)
+	if err != nil {
+		return html.EscapeString(content)
+	}
+	return rendered
+}
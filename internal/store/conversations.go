@@ -0,0 +1,471 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DMPolicyEveryone  = "everyone"
+	DMPolicyFollowing = "following"
+	DMPolicyNobody    = "nobody"
+)
+
+// MaxConversationParticipants bounds group conversations, the creator included.
+const MaxConversationParticipants = 10
+
+var ErrMessagingNotAllowed = errors.New("Recipient does not accept messages from you")
+
+type Conversation struct {
+	ID            int64                     `json:"id"`
+	IsGroup       bool                      `json:"is_group"`
+	Title         string                    `json:"title"`
+	CreatedBy     *int64                    `json:"created_by"`
+	CreatedAt     string                    `json:"created_at"`
+	LastMessageAt string                    `json:"last_message_at"`
+	UnreadCount   int64                     `json:"unread_count"`
+	LastMessage   *Message                  `json:"last_message"`
+	Participants  []ConversationParticipant `json:"participants"`
+}
+
+// ConversationParticipant carries the read receipt of a participant: the last
+// message they read and when.
+type ConversationParticipant struct {
+	UserID            int64   `json:"user_id"`
+	Username          string  `json:"username"`
+	JoinedAt          string  `json:"joined_at"`
+	LastReadMessageID int64   `json:"last_read_message_id"`
+	LastReadAt        *string `json:"last_read_at"`
+}
+
+type Message struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	SenderID       int64  `json:"sender_id"`
+	Content        string `json:"content"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// messageVisibleTo hides the messages aliased m that the user bound to
+// userParam deleted for themselves, and those sent by users on either side of
+// a block with them.
+func messageVisibleTo(userParam string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM message_deletions md WHERE md.message_id = m.id AND md.user_id = %s
+	) AND `, userParam) + notBlocked("m.sender_id", userParam)
+}
+
+type ConversationStore struct {
+	db *sql.DB
+}
+
+// Create starts a conversation between the creator and participantIDs. A 1:1
+// conversation that already exists is returned instead, created reports which
+// one happened.
+func (store *ConversationStore) Create(ctx context.Context, conversation *Conversation, participantIDs []int64) (bool, error) {
+	var directKey *string
+	if !conversation.IsGroup {
+		if len(participantIDs) != 1 {
+			return false, errors.New("direct conversations have exactly one other participant")
+		}
+		key := directConversationKey(*conversation.CreatedBy, participantIDs[0])
+		directKey = &key
+	}
+	var created bool
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO conversations (created_by, is_group, title, direct_key)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (direct_key) DO UPDATE SET direct_key = EXCLUDED.direct_key
+			RETURNING id, created_by, title, created_at, last_message_at, xmax = 0
+		`
+		var createdBy sql.NullInt64
+		err := tx.QueryRowContext(ctx, query, conversation.CreatedBy, conversation.IsGroup, conversation.Title, directKey).Scan(
+			&conversation.ID,
+			&createdBy,
+			&conversation.Title,
+			&conversation.CreatedAt,
+			&conversation.LastMessageAt,
+			&created,
+		)
+		if err != nil {
+			return err
+		}
+		conversation.CreatedBy = nil
+		if createdBy.Valid {
+			conversation.CreatedBy = &createdBy.Int64
+		}
+		if !created {
+			return nil
+		}
+
+		query = `
+			INSERT INTO conversation_participants (conversation_id, user_id)
+			SELECT $1, unnest($2::bigint[])
+			ON CONFLICT DO NOTHING
+		`
+		members := append([]int64{*conversation.CreatedBy}, participantIDs...)
+		_, err = tx.ExecContext(ctx, query, conversation.ID, pq.Array(members))
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return created, store.attachParticipants(ctx, []*Conversation{conversation})
+}
+
+// GetByID returns the conversation as seen by userID, or ErrorNotFound when they
+// do not take part in it.
+func (store *ConversationStore) GetByID(ctx context.Context, id int64, userID int64) (*Conversation, error) {
+	conversations, err := store.query(ctx, userID, `c.id = $2`, `c.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return nil, ErrorNotFound
+	}
+	return &conversations[0], nil
+}
+
+// GetByUser returns the conversations of userID, most recently active first.
+func (store *ConversationStore) GetByUser(ctx context.Context, userID int64, cq ConversationQuery) ([]Conversation, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(cq.Cursor, "desc", "c.last_message_at", "c.id", 3)
+	args := append([]any{cq.Limit + 1}, cursorArgs...)
+	conversations, err := store.query(ctx, userID, condition, orderBy+` LIMIT $2`, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	conversations, page := paginate(conversations, cq.Limit, cq.Cursor, func(c Conversation) Cursor {
+		return cursorAt(c.LastMessageAt, c.ID)
+	})
+	return conversations, page, nil
+}
+
+// query reads the conversations of userID matching condition. The user is
+// bound to $1, extra arguments start at $2.
+func (store *ConversationStore) query(ctx context.Context, userID int64, condition string, orderBy string, args ...any) ([]Conversation, error) {
+	query := `
+		SELECT c.id, c.is_group, c.title, c.created_by, c.created_at, c.last_message_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = c.id AND m.id > cp.last_read_message_id AND m.sender_id <> $1 AND ` + messageVisibleTo("$1") + `
+			),
+			lm.id, lm.sender_id, lm.content, lm.created_at
+		FROM conversation_participants cp
+		JOIN conversations c ON c.id = cp.conversation_id
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.sender_id, m.content, m.created_at FROM messages m
+			WHERE m.conversation_id = c.id AND ` + messageVisibleTo("$1") + `
+			ORDER BY m.id DESC
+			LIMIT 1
+		) lm ON TRUE
+		WHERE cp.user_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, append([]any{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	conversations := []Conversation{}
+	for rows.Next() {
+		var (
+			c         Conversation
+			createdBy sql.NullInt64
+			messageID sql.NullInt64
+			senderID  sql.NullInt64
+			content   sql.NullString
+			sentAt    sql.NullString
+		)
+		err := rows.Scan(
+			&c.ID,
+			&c.IsGroup,
+			&c.Title,
+			&createdBy,
+			&c.CreatedAt,
+			&c.LastMessageAt,
+			&c.UnreadCount,
+			&messageID,
+			&senderID,
+			&content,
+			&sentAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if createdBy.Valid {
+			c.CreatedBy = &createdBy.Int64
+		}
+		if messageID.Valid {
+			c.LastMessage = &Message{
+				ID:             messageID.Int64,
+				ConversationID: c.ID,
+				SenderID:       senderID.Int64,
+				Content:        content.String,
+				CreatedAt:      sentAt.String,
+			}
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	refs := make([]*Conversation, len(conversations))
+	for i := range conversations {
+		refs[i] = &conversations[i]
+	}
+	return conversations, store.attachParticipants(ctx, refs)
+}
+
+func (store *ConversationStore) attachParticipants(ctx context.Context, conversations []*Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(conversations))
+	byID := make(map[int64]*Conversation, len(conversations))
+	for i, c := range conversations {
+		ids[i] = c.ID
+		c.Participants = []ConversationParticipant{}
+		byID[c.ID] = c
+	}
+	query := `
+		SELECT cp.conversation_id, cp.user_id, u.username, cp.joined_at, cp.last_read_message_id, cp.last_read_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = ANY($1)
+		ORDER BY cp.conversation_id, cp.joined_at, cp.user_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			conversationID int64
+			p              ConversationParticipant
+			lastReadAt     sql.NullTime
+		)
+		if err := rows.Scan(&conversationID, &p.UserID, &p.Username, &p.JoinedAt, &p.LastReadMessageID, &lastReadAt); err != nil {
+			return err
+		}
+		p.LastReadAt = formatNullTime(lastReadAt)
+		c := byID[conversationID]
+		c.Participants = append(c.Participants, p)
+	}
+	return rows.Err()
+}
+
+// CheckCanMessage returns ErrorNotFound when a recipient is not an active user
+// and ErrMessagingNotAllowed when a recipient's DM policy refuses senderID or
+// a block stands between them. Users following only accept messages from the
+// people they follow.
+func (store *ConversationStore) CheckCanMessage(ctx context.Context, senderID int64, recipientIDs []int64) error {
+	query := `
+		SELECT u.id,
+			` + notBlocked("u.id", "$1") + ` AND (u.dm_policy = 'everyone' OR (u.dm_policy = 'following' AND EXISTS (
+				SELECT 1 FROM followers f WHERE f.user_id = $1 AND f.follower_id = u.id
+			)))
+		FROM users u
+		WHERE u.id = ANY($2) AND u.is_activated
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, senderID, pq.Array(recipientIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	found := 0
+	refused := false
+	for rows.Next() {
+		var (
+			id      int64
+			allowed bool
+		)
+		if err := rows.Scan(&id, &allowed); err != nil {
+			return err
+		}
+		found++
+		refused = refused || !allowed
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found != len(recipientIDs) {
+		return ErrorNotFound
+	}
+	if refused {
+		return ErrMessagingNotAllowed
+	}
+	return nil
+}
+
+// GetRecipientIDs returns the participants of conversationID who receive what
+// userID sends there: everyone else but the users on either side of a block
+// with them.
+func (store *ConversationStore) GetRecipientIDs(ctx context.Context, conversationID int64, userID int64) ([]int64, error) {
+	query := `
+		SELECT cp.user_id FROM conversation_participants cp
+		WHERE cp.conversation_id = $1 AND cp.user_id <> $2 AND ` + notBlocked("cp.user_id", "$2") + `
+		ORDER BY cp.user_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateMessage adds a message to its conversation, which the sender has then read up to it.
+func (store *ConversationStore) CreateMessage(ctx context.Context, message *Message) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO messages (conversation_id, sender_id, content)
+			VALUES ($1, $2, $3)
+			RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(ctx, query, message.ConversationID, message.SenderID, message.Content).Scan(&message.ID, &message.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE conversations SET last_message_at = $2 WHERE id = $1`, message.ConversationID, message.CreatedAt); err != nil {
+			return err
+		}
+		query = `
+			UPDATE conversation_participants SET last_read_message_id = $3, last_read_at = NOW()
+			WHERE conversation_id = $1 AND user_id = $2
+		`
+		_, err = tx.ExecContext(ctx, query, message.ConversationID, message.SenderID, message.ID)
+		return err
+	})
+}
+
+// GetMessages returns the history of a conversation as seen by userID, newest first.
+func (store *ConversationStore) GetMessages(ctx context.Context, conversationID int64, userID int64, cq ConversationQuery) ([]Message, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(cq.Cursor, "desc", "m.created_at", "m.id", 4)
+	query := `
+		SELECT m.id, m.conversation_id, m.sender_id, m.content, m.created_at
+		FROM messages m
+		WHERE m.conversation_id = $1 AND ` + messageVisibleTo("$2") + ` AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{conversationID, userID, cq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	messages := []Message{}
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.Content, &m.CreatedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	messages, page := paginate(messages, cq.Limit, cq.Cursor, func(m Message) Cursor {
+		return cursorAt(m.CreatedAt, m.ID)
+	})
+	return messages, page, nil
+}
+
+// DeleteMessage hides a message of the conversation from userID only.
+func (store *ConversationStore) DeleteMessage(ctx context.Context, conversationID int64, userID int64, messageID int64) error {
+	query := `
+		INSERT INTO message_deletions (message_id, user_id)
+		SELECT m.id, $3 FROM messages m WHERE m.id = $1 AND m.conversation_id = $2
+		ON CONFLICT DO NOTHING
+		RETURNING message_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var id int64
+	err := store.db.QueryRowContext(ctx, query, messageID, conversationID, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrorNotFound
+	}
+	return err
+}
+
+// MarkRead moves the read receipt of userID forward to messageID, or to the
+// latest message when messageID is zero, and returns where it ended up.
+func (store *ConversationStore) MarkRead(ctx context.Context, conversationID int64, userID int64, messageID int64) (int64, error) {
+	query := `
+		UPDATE conversation_participants cp SET
+			last_read_message_id = GREATEST(cp.last_read_message_id, COALESCE(
+				(SELECT MAX(m.id) FROM messages m WHERE m.conversation_id = $1 AND ($3::bigint = 0 OR m.id <= $3)), 0
+			)),
+			last_read_at = NOW()
+		WHERE cp.conversation_id = $1 AND cp.user_id = $2
+		RETURNING cp.last_read_message_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var lastRead int64
+	err := store.db.QueryRowContext(ctx, query, conversationID, userID, messageID).Scan(&lastRead)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrorNotFound
+	}
+	return lastRead, err
+}
+
+// CountUnread returns how many messages userID has not read across their conversations.
+func (store *ConversationStore) CountUnread(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM conversation_participants cp
+		JOIN messages m ON m.conversation_id = cp.conversation_id
+		WHERE cp.user_id = $1 AND m.id > cp.last_read_message_id AND m.sender_id <> $1 AND ` + messageVisibleTo("$1")
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var count int64
+	err := store.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+func directConversationKey(a int64, b int64) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Cursor is an opaque keyset position on (created_at, id). Backward cursors
+// page towards the start of the list, forward cursors towards its end.
+// Ranked lists page on (score, id) instead and keep the time the scores were
+// computed at in CreatedAt. Lists hiding what the viewer has seen keep when
+// their first page was served in SeenBefore, as Unix seconds, so every page of
+// them hides the same posts.
+type Cursor struct {
+	CreatedAt  time.Time `json:"t"`
+	ID         int64     `json:"i"`
+	Score      float64   `json:"s,omitempty"`
+	Backward   bool      `json:"b,omitempty"`
+	SeenBefore int64     `json:"v,omitempty"`
+}
+
+type PageInfo struct {
+	NextCursor string `json:"next_cursor"`
+	PrevCursor string `json:"prev_cursor"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(encoded string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorAt builds the cursor of a row whose created_at was formatted as RFC 3339.
+func cursorAt(createdAt string, id int64) Cursor {
+	t, _ := time.Parse(time.RFC3339Nano, createdAt)
+	return Cursor{CreatedAt: t, ID: id}
+}
+
+func parseCursor(query url.Values) (*Cursor, error) {
+	encoded := query.Get("cursor")
+	if encoded == "" {
+		return nil, nil
+	}
+	return DecodeCursor(encoded)
+}
+
+// keyset returns the WHERE condition and ORDER BY clause that select the page
+// after cursor in a list sorted by (createdAtCol, idCol) in sort order.
+// The cursor values are bound to $firstArg and $firstArg+1 and returned in args.
+// Backward pages are read in reverse order and must be flipped by paginate.
+func keyset(cursor *Cursor, sort, createdAtCol, idCol string, firstArg int) (string, string, []any) {
+	descending := sort == "desc"
+	direction := sort
+	if cursor != nil && cursor.Backward {
+		descending = !descending
+		if direction == "desc" {
+			direction = "asc"
+		} else {
+			direction = "desc"
+		}
+	}
+	orderBy := fmt.Sprintf("%s %s, %s %s", createdAtCol, direction, idCol, direction)
+	if cursor == nil {
+		return "TRUE", orderBy, nil
+	}
+	operator := ">"
+	if descending {
+		operator = "<"
+	}
+	condition := fmt.Sprintf("(%s, %s) %s ($%d, $%d)", createdAtCol, idCol, operator, firstArg, firstArg+1)
+	return condition, orderBy, []any{cursor.CreatedAt, cursor.ID}
+}
+
+// scoreKeyset is keyset for lists ranked by (scoreCol, idCol), highest first.
+// The cursor's score and ID are bound to $firstArg and $firstArg+1.
+func scoreKeyset(cursor *Cursor, scoreCol, idCol string, firstArg int) (string, string, []any) {
+	direction, operator := "DESC", "<"
+	if cursor != nil && cursor.Backward {
+		direction, operator = "ASC", ">"
+	}
+	orderBy := fmt.Sprintf("%s %s, %s %s", scoreCol, direction, idCol, direction)
+	if cursor == nil {
+		return "TRUE", orderBy, nil
+	}
+	condition := fmt.Sprintf("(%s, %s) %s ($%d, $%d)", scoreCol, idCol, operator, firstArg, firstArg+1)
+	return condition, orderBy, []any{cursor.Score, cursor.ID}
+}
+
+// paginate trims the limit+1 rows read with keyset to a page and builds the
+// cursors of its neighbours. key returns the keyset position of an item.
+func paginate[T any](items []T, limit int, cursor *Cursor, key func(T) Cursor) ([]T, PageInfo) {
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	var page PageInfo
+	if len(items) == 0 {
+		if cursor != nil {
+			flipped := *cursor
+			flipped.Backward = !cursor.Backward
+			if backward {
+				page.NextCursor = flipped.Encode()
+			} else {
+				page.PrevCursor = flipped.Encode()
+			}
+		}
+		return items, page
+	}
+	if hasMore || backward {
+		next := key(items[len(items)-1])
+		page.NextCursor = next.Encode()
+	}
+	if (hasMore && backward) || (cursor != nil && !backward) {
+		prev := key(items[0])
+		prev.Backward = true
+		page.PrevCursor = prev.Encode()
+	}
+	return items, page
+}
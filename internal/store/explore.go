@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ExploreScopePopular = "popular"
+	ExploreScopeLocal   = "local"
+)
+
+// HotScoring configures the explore hot score of a post,
+//
+//	(1 + comments + poll voters + ViewWeight * views) / (age in hours + 2)^Gravity
+//
+// computed for the public posts younger than Window. Public notes received
+// from other servers have no engagement here and score 1 / (age + 2)^Gravity,
+// their age counting from when they were received.
+type HotScoring struct {
+	Gravity    float64
+	ViewWeight float64
+	Window     time.Duration
+}
+
+type ExploreStore struct {
+	db *sql.DB
+}
+
+// RefreshScores replaces the precomputed explore scores and returns how many
+// local and remote posts were scored.
+func (store *ExploreStore) RefreshScores(ctx context.Context, scoring HotScoring) (int64, error) {
+	var scored int64
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration*6)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM explore_scores`); err != nil {
+			return err
+		}
+		query := `
+			INSERT INTO explore_scores (post_id, score)
+			SELECT posts.id,
+				(1 +
+					(SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL) +
+					(SELECT COUNT(DISTINCT v.user_id) FROM polls p JOIN poll_votes v ON v.poll_id = p.id WHERE p.post_id = posts.id) +
+					$2::float8 * (SELECT COALESCE(SUM(d.views), 0) FROM post_views_daily d WHERE d.post_id = posts.id)
+				) / power(extract(epoch FROM (NOW() - posts.created_at)) / 3600 + 2, $1::float8)
+			FROM posts
+			JOIN users ON users.id = posts.user_id
+			WHERE
+				posts.deleted_at IS NULL AND
+				posts.visibility = 'public' AND
+				users.is_activated AND
+				NOT users.is_private AND
+				posts.created_at > NOW() - make_interval(secs => $3::float8)
+		`
+		result, err := tx.ExecContext(ctx, query, scoring.Gravity, scoring.ViewWeight, scoring.Window.Seconds())
+		if err != nil {
+			return err
+		}
+		if scored, err = result.RowsAffected(); err != nil {
+			return err
+		}
+		query = `
+			INSERT INTO explore_scores (remote_post_id, score)
+			SELECT rp.id, 1 / power(extract(epoch FROM (NOW() - rp.created_at)) / 3600 + 2, $1::float8)
+			FROM remote_posts rp
+			WHERE rp.public AND rp.created_at > NOW() - make_interval(secs => $2::float8)
+		`
+		result, err = tx.ExecContext(ctx, query, scoring.Gravity, scoring.Window.Seconds())
+		if err != nil {
+			return err
+		}
+		remote, err := result.RowsAffected()
+		scored += remote
+		return err
+	})
+	return scored, err
+}
+
+// GetExplore returns public posts of active public accounts that viewerID did not write,
+// has not blocked or muted and has not seen since seenSince. Posts seen while paging through the list stay in
+// it: only those seen before its first page was read are hidden.
+//
+// The popular scope ranks them by the precomputed hot score together with the
+// public notes other servers sent, which have Remote set and their remote post
+// ID. The local scope lists only posts written on this server, newest first.
+func (store *ExploreStore) GetExplore(ctx context.Context, viewerID int64, exploreQuery ExploreQuery, seenSince time.Time) ([]PostWithMetadata, PageInfo, error) {
+	// post_seen.seen_at is stored to the second, so the list starts at the
+	// second it was first read and marks made while paging round into it.
+	seenBefore := time.Now().Truncate(time.Second)
+	if exploreQuery.Cursor != nil && exploreQuery.Cursor.SeenBefore > 0 {
+		seenBefore = time.Unix(exploreQuery.Cursor.SeenBefore, 0)
+	}
+	local := `
+		posts.deleted_at IS NULL AND
+		posts.visibility = 'public' AND
+		users.is_activated AND
+		NOT users.is_private AND
+		posts.user_id <> $1 AND
+		` + notBlocked("posts.user_id", "$1") + ` AND
+		` + notMutedBy("$1", "posts.user_id") + ` AND
+		NOT EXISTS (
+			SELECT 1 FROM post_seen ps WHERE ps.user_id = $1 AND ps.post_id = posts.id AND ps.seen_at > $3 AND ps.seen_at < $4
+		)
+	`
+	var query string
+	var condition, orderBy string
+	var cursorArgs []any
+	switch exploreQuery.Scope {
+	case ExploreScopeLocal:
+		condition, orderBy, cursorArgs = keyset(exploreQuery.Cursor, "desc", "posts.created_at", "posts.id", 5)
+		query = `
+			SELECT ` + feedColumns + `, NULL::float8, NULL::bigint, NULL::text, NULL::text, NULL::text
+			FROM posts
+			JOIN users ON posts.user_id = users.id
+			WHERE ` + local + ` AND ` + condition + `
+			ORDER BY ` + orderBy + `
+			LIMIT $2
+		`
+	default:
+		condition, orderBy, cursorArgs = scoreKeyset(exploreQuery.Cursor, "items.score", "items.explore_id", 5)
+		query = `
+			SELECT * FROM (
+				SELECT ` + feedColumns + `, s.score, s.id AS explore_id, NULL::text, NULL::text, NULL::text
+				FROM explore_scores s
+				JOIN posts ON posts.id = s.post_id
+				JOIN users ON posts.user_id = users.id
+				WHERE ` + local + `
+				UNION ALL
+				SELECT rp.id, 0, '', '', rp.content_html, rp.published_at, '{}', 'public', false, NULL,
+					ra.preferred_username, 0, s.score, s.id, ra.uri, rp.uri, rp.url
+				FROM explore_scores s
+				JOIN remote_posts rp ON rp.id = s.remote_post_id
+				JOIN remote_actors ra ON ra.id = rp.remote_actor_id
+				WHERE rp.public
+			) items
+			WHERE ` + condition + `
+			ORDER BY ` + orderBy + `
+			LIMIT $2
+		`
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append([]any{viewerID, exploreQuery.Limit + 1, seenSince, seenBefore}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	type exploreItem struct {
+		PostWithMetadata
+		exploreID int64
+	}
+	items := []exploreItem{}
+	for rows.Next() {
+		var (
+			score                  sql.NullFloat64
+			exploreID              sql.NullInt64
+			actorURI, uri, postURL sql.NullString
+		)
+		p, err := scanFeedPost(rows, &score, &exploreID, &actorURI, &uri, &postURL)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		if score.Valid {
+			p.Score = &score.Float64
+		}
+		if actorURI.Valid {
+			p.Remote = &RemoteOrigin{ActorURI: actorURI.String, URI: uri.String, URL: postURL.String}
+		}
+		items = append(items, exploreItem{p, exploreID.Int64})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	items, page := paginate(items, exploreQuery.Limit, exploreQuery.Cursor, func(item exploreItem) Cursor {
+		c := cursorAt(item.CreatedAt, item.ID)
+		if item.Score != nil {
+			c = Cursor{ID: item.exploreID, Score: *item.Score}
+		}
+		c.SeenBefore = seenBefore.Unix()
+		return c
+	})
+	posts := make([]PostWithMetadata, len(items))
+	for i := range items {
+		posts[i] = items[i].PostWithMetadata
+	}
+	return posts, page, nil
+}
+
+// MarkSeen records that userID was shown postIDs in explore.
+func (store *ExploreStore) MarkSeen(ctx context.Context, userID int64, postIDs []int64) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO post_seen (user_id, post_id)
+		SELECT $1, id FROM posts WHERE id = ANY($2)
+		ON CONFLICT (user_id, post_id) DO UPDATE SET seen_at = NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, userID, pq.Array(postIDs))
+	return err
+}
+
+// PurgeSeen forgets what users saw before seenBefore.
+func (store *ExploreStore) PurgeSeen(ctx context.Context, seenBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM post_seen WHERE seen_at < $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, seenBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testDB connects to the migrated database in STORE_TEST_DB_ADDRESS and skips
+// the test when it is not set.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	addr := os.Getenv("STORE_TEST_DB_ADDRESS")
+	if addr == "" {
+		t.Skip("STORE_TEST_DB_ADDRESS is not set")
+	}
+	db, err := sql.Open("postgres", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createTestUsers creates activated users, deleted with their posts when the
+// test ends.
+func createTestUsers(t *testing.T, db *sql.DB, storage Storage, names ...string) []*User {
+	t.Helper()
+	ctx := context.Background()
+	suffix := time.Now().UnixNano()
+	users := make([]*User, len(names))
+	ids := make([]int64, len(names))
+	err := withTx(db, ctx, func(tx *sql.Tx) error {
+		for i, name := range names {
+			user := &User{
+				Username: fmt.Sprintf("%s%d", name, suffix),
+				Email:    fmt.Sprintf("%s%d@example.com", name, suffix),
+				Role:     Role{Name: "user"},
+			}
+			if err := user.Password.Set("password"); err != nil {
+				return err
+			}
+			if err := storage.Users.Create(ctx, tx, user); err != nil {
+				return err
+			}
+			users[i], ids[i] = user, user.ID
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE users SET is_activated = true WHERE id = ANY($1)`, pq.Array(ids))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// Posts do not cascade with their author.
+		for _, query := range []string{
+			`DELETE FROM posts WHERE user_id = ANY($1)`,
+			`DELETE FROM users WHERE id = ANY($1)`,
+		} {
+			if _, err := db.Exec(query, pq.Array(ids)); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	return users
+}
+
+func TestGetExploreExcludesMutedAuthors(t *testing.T) {
+	db := testDB(t)
+	storage := NewStorage(db)
+	ctx := context.Background()
+	users := createTestUsers(t, db, storage, "viewer", "muted", "other")
+	viewer, muted, other := users[0], users[1], users[2]
+
+	mutedPost := &Post{UserID: muted.ID, Title: "muted", Content: "muted", Visibility: VisibilityPublic}
+	otherPost := &Post{UserID: other.ID, Title: "other", Content: "other", Visibility: VisibilityPublic}
+	for _, post := range []*Post{mutedPost, otherPost} {
+		if err := storage.Posts.Create(ctx, post); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.Mutes.Mute(ctx, viewer.ID, muted.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Only score the posts just written, so both fit on the first page.
+	if _, err := storage.Explore.RefreshScores(ctx, HotScoring{Gravity: 1.8, ViewWeight: 0.1, Window: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scope := range []string{ExploreScopePopular, ExploreScopeLocal} {
+		t.Run(scope, func(t *testing.T) {
+			posts, _, err := storage.Explore.GetExplore(ctx, viewer.ID, ExploreQuery{Scope: scope, Limit: 20}, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := map[int64]bool{}
+			for _, post := range posts {
+				if post.Remote == nil {
+					listed[post.ID] = true
+				}
+			}
+			if listed[mutedPost.ID] {
+				t.Errorf("the post of a muted author is listed")
+			}
+			if !listed[otherPost.ID] {
+				t.Errorf("the post of an author that is not muted is missing")
+			}
+		})
+	}
+}
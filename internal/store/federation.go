@@ -0,0 +1,618 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type ActorKey struct {
+	UserID        int64
+	PublicKeyPEM  string
+	PrivateKeyPEM string
+}
+
+// RemoteActor is an account on another ActivityPub server.
+type RemoteActor struct {
+	ID                int64     `json:"id"`
+	URI               string    `json:"uri"`
+	KeyID             string    `json:"key_id"`
+	Inbox             string    `json:"inbox"`
+	PreferredUsername string    `json:"preferred_username"`
+	PublicKeyPEM      string    `json:"-"`
+	FetchedAt         time.Time `json:"fetched_at"`
+}
+
+// RemotePost is a note received from another server. It replies to the local
+// post PostID, is addressed to local users, or both.
+type RemotePost struct {
+	ID            int64     `json:"id"`
+	RemoteActorID int64     `json:"remote_actor_id"`
+	ActorURI      string    `json:"actor_uri"`
+	ActorUsername string    `json:"actor_username"`
+	URI           string    `json:"uri"`
+	URL           string    `json:"url"`
+	ContentHTML   string    `json:"content_html"`
+	InReplyTo     string    `json:"in_reply_to"`
+	PostID        *int64    `json:"post_id"`
+	Public        bool      `json:"public"`
+	PublishedAt   time.Time `json:"published_at"`
+	ReceivedAt    string    `json:"received_at"`
+}
+
+// RemoteFollowRequest is a remote actor waiting for a private account to approve
+// their follow.
+type RemoteFollowRequest struct {
+	RemoteActorID     int64  `json:"remote_actor_id"`
+	URI               string `json:"uri"`
+	PreferredUsername string `json:"preferred_username"`
+	Inbox             string `json:"-"`
+	FollowActivityID  string `json:"-"`
+	RequestedAt       string `json:"requested_at"`
+}
+
+// Actor returns the requesting actor.
+func (fr *RemoteFollowRequest) Actor() *RemoteActor {
+	return &RemoteActor{ID: fr.RemoteActorID, URI: fr.URI, Inbox: fr.Inbox, PreferredUsername: fr.PreferredUsername}
+}
+
+// Delivery is an activity waiting to be posted to a remote inbox on behalf of UserID.
+type Delivery struct {
+	ID       int64
+	UserID   int64
+	Inbox    string
+	Payload  []byte
+	Attempts int
+}
+
+type FederationStore struct {
+	db *sql.DB
+}
+
+func (store *FederationStore) GetActorKey(ctx context.Context, userID int64) (*ActorKey, error) {
+	query := `
+		SELECT user_id, public_key_pem, private_key_pem FROM actor_keys WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var key ActorKey
+	err := store.db.QueryRowContext(ctx, query, userID).Scan(&key.UserID, &key.PublicKeyPEM, &key.PrivateKeyPEM)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &key, nil
+}
+
+// CreateActorKey stores key unless the user already has one, so concurrent
+// first uses agree on a single keypair.
+func (store *FederationStore) CreateActorKey(ctx context.Context, key *ActorKey) error {
+	query := `
+		INSERT INTO actor_keys (user_id, public_key_pem, private_key_pem) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO NOTHING
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, key.UserID, key.PublicKeyPEM, key.PrivateKeyPEM)
+	return err
+}
+
+// UpsertRemoteActor inserts actor or refreshes the stored copy with the same URI.
+func (store *FederationStore) UpsertRemoteActor(ctx context.Context, actor *RemoteActor) error {
+	query := `
+		INSERT INTO remote_actors (uri, key_id, inbox, preferred_username, public_key_pem)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (uri) DO UPDATE SET
+			key_id = EXCLUDED.key_id,
+			inbox = EXCLUDED.inbox,
+			preferred_username = EXCLUDED.preferred_username,
+			public_key_pem = EXCLUDED.public_key_pem,
+			fetched_at = NOW()
+		RETURNING id, fetched_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return store.db.QueryRowContext(
+		ctx,
+		query,
+		actor.URI,
+		actor.KeyID,
+		actor.Inbox,
+		actor.PreferredUsername,
+		actor.PublicKeyPEM,
+	).Scan(&actor.ID, &actor.FetchedAt)
+}
+
+func (store *FederationStore) GetRemoteActorByKeyID(ctx context.Context, keyID string) (*RemoteActor, error) {
+	return store.getRemoteActor(ctx, "key_id", keyID)
+}
+
+func (store *FederationStore) GetRemoteActorByURI(ctx context.Context, uri string) (*RemoteActor, error) {
+	return store.getRemoteActor(ctx, "uri", uri)
+}
+
+func (store *FederationStore) getRemoteActor(ctx context.Context, column string, value string) (*RemoteActor, error) {
+	query := `
+		SELECT id, uri, key_id, inbox, preferred_username, public_key_pem, fetched_at
+		FROM remote_actors WHERE ` + column + ` = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var actor RemoteActor
+	err := store.db.QueryRowContext(ctx, query, value).Scan(
+		&actor.ID,
+		&actor.URI,
+		&actor.KeyID,
+		&actor.Inbox,
+		&actor.PreferredUsername,
+		&actor.PublicKeyPEM,
+		&actor.FetchedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &actor, nil
+}
+
+// DeleteRemoteActor forgets a remote account together with its follows and posts.
+func (store *FederationStore) DeleteRemoteActor(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM remote_actors WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (store *FederationStore) AddRemoteFollower(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) error {
+	query := `
+		INSERT INTO remote_followers (user_id, remote_actor_id, follow_activity_id) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, remote_actor_id) DO UPDATE SET follow_activity_id = EXCLUDED.follow_activity_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, userID, remoteActorID, followActivityID)
+	return err
+}
+
+// RemoveRemoteFollower stops remoteActorID following userID, or withdraws its
+// pending request.
+func (store *FederationStore) RemoveRemoteFollower(ctx context.Context, userID int64, remoteActorID int64) error {
+	query := `
+		WITH unfollowed AS (
+			DELETE FROM remote_followers WHERE user_id = $1 AND remote_actor_id = $2
+		)
+		DELETE FROM remote_follow_requests WHERE user_id = $1 AND remote_actor_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, userID, remoteActorID)
+	return err
+}
+
+// RequestRemoteFollow records that remoteActorID asks to follow the private
+// account userID. When the actor already follows userID the follow is updated
+// instead and requested is false.
+func (store *FederationStore) RequestRemoteFollow(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) (bool, error) {
+	query := `
+		WITH following AS (
+			UPDATE remote_followers SET follow_activity_id = $3
+			WHERE user_id = $1 AND remote_actor_id = $2
+			RETURNING 1
+		), requested AS (
+			INSERT INTO remote_follow_requests (user_id, remote_actor_id, follow_activity_id)
+			SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM following)
+			ON CONFLICT (user_id, remote_actor_id) DO UPDATE SET follow_activity_id = EXCLUDED.follow_activity_id
+			RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM requested)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var requested bool
+	err := store.db.QueryRowContext(ctx, query, userID, remoteActorID, followActivityID).Scan(&requested)
+	return requested, err
+}
+
+// GetRemoteFollowRequests lists the pending remote follow requests of userID,
+// oldest first.
+func (store *FederationStore) GetRemoteFollowRequests(ctx context.Context, userID int64, fq FollowQuery) ([]RemoteFollowRequest, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(fq.Cursor, "asc", "fr.created_at", "fr.remote_actor_id", 3)
+	query := `
+		SELECT a.id, a.uri, a.preferred_username, a.inbox, fr.follow_activity_id, fr.created_at
+		FROM remote_follow_requests fr
+		JOIN remote_actors a ON a.id = fr.remote_actor_id
+		WHERE fr.user_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{userID, fq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	requests := []RemoteFollowRequest{}
+	for rows.Next() {
+		var fr RemoteFollowRequest
+		if err := rows.Scan(&fr.RemoteActorID, &fr.URI, &fr.PreferredUsername, &fr.Inbox, &fr.FollowActivityID, &fr.RequestedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		requests = append(requests, fr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	requests, page := paginate(requests, fq.Limit, fq.Cursor, func(fr RemoteFollowRequest) Cursor {
+		return cursorAt(fr.RequestedAt, fr.RemoteActorID)
+	})
+	return requests, page, nil
+}
+
+// ApproveRemoteFollowRequest turns the pending request of remoteActorID into a
+// follow of userID and returns it so the follow can be accepted.
+func (store *FederationStore) ApproveRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*RemoteFollowRequest, error) {
+	var request *RemoteFollowRequest
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		request, err = deleteRemoteFollowRequest(ctx, tx, userID, remoteActorID)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO remote_followers (user_id, remote_actor_id, follow_activity_id) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, remote_actor_id) DO UPDATE SET follow_activity_id = EXCLUDED.follow_activity_id
+		`
+		_, err = tx.ExecContext(ctx, query, userID, remoteActorID, request.FollowActivityID)
+		return err
+	})
+	return request, err
+}
+
+// ApproveAllRemoteFollowRequests turns every pending remote request to userID
+// into a follow and returns them so the follows can be accepted.
+func (store *FederationStore) ApproveAllRemoteFollowRequests(ctx context.Context, userID int64) ([]RemoteFollowRequest, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM remote_follow_requests WHERE user_id = $1
+			RETURNING remote_actor_id, follow_activity_id, created_at
+		), followed AS (
+			INSERT INTO remote_followers (user_id, remote_actor_id, follow_activity_id)
+			SELECT $1, remote_actor_id, follow_activity_id FROM deleted
+			ON CONFLICT (user_id, remote_actor_id) DO UPDATE SET follow_activity_id = EXCLUDED.follow_activity_id
+		)
+		SELECT a.id, a.uri, a.preferred_username, a.inbox, d.follow_activity_id, d.created_at
+		FROM deleted d
+		JOIN remote_actors a ON a.id = d.remote_actor_id
+		ORDER BY d.created_at, a.id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	requests := []RemoteFollowRequest{}
+	for rows.Next() {
+		var fr RemoteFollowRequest
+		err := rows.Scan(&fr.RemoteActorID, &fr.URI, &fr.PreferredUsername, &fr.Inbox, &fr.FollowActivityID, &fr.RequestedAt)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, fr)
+	}
+	return requests, rows.Err()
+}
+
+// RejectRemoteFollowRequest drops the pending request of remoteActorID and
+// returns it so the follow can be rejected.
+func (store *FederationStore) RejectRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*RemoteFollowRequest, error) {
+	var request *RemoteFollowRequest
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		var err error
+		request, err = deleteRemoteFollowRequest(ctx, tx, userID, remoteActorID)
+		return err
+	})
+	return request, err
+}
+
+func deleteRemoteFollowRequest(ctx context.Context, tx *sql.Tx, userID int64, remoteActorID int64) (*RemoteFollowRequest, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM remote_follow_requests WHERE user_id = $1 AND remote_actor_id = $2
+			RETURNING remote_actor_id, follow_activity_id, created_at
+		)
+		SELECT a.id, a.uri, a.preferred_username, a.inbox, d.follow_activity_id, d.created_at
+		FROM deleted d
+		JOIN remote_actors a ON a.id = d.remote_actor_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var fr RemoteFollowRequest
+	err := tx.QueryRowContext(ctx, query, userID, remoteActorID).Scan(
+		&fr.RemoteActorID,
+		&fr.URI,
+		&fr.PreferredUsername,
+		&fr.Inbox,
+		&fr.FollowActivityID,
+		&fr.RequestedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &fr, nil
+}
+
+func (store *FederationStore) CountRemoteFollowers(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		SELECT COUNT(*) FROM remote_followers WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var count int64
+	err := store.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// GetRemoteFollowerInboxes returns the distinct inboxes of userID's remote followers.
+func (store *FederationStore) GetRemoteFollowerInboxes(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT DISTINCT a.inbox FROM remote_followers f
+		JOIN remote_actors a ON a.id = f.remote_actor_id
+		WHERE f.user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inboxes := []string{}
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, rows.Err()
+}
+
+// CreateRemotePost stores a note received from a remote server and delivers it
+// to recipientIDs. Redelivered notes only gain the new recipients, and notes
+// whose URI another actor already used are ignored.
+func (store *FederationStore) CreateRemotePost(ctx context.Context, post *RemotePost, recipientIDs []int64) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO remote_posts (remote_actor_id, uri, url, content_html, in_reply_to, post_id, public, published_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (uri) DO UPDATE SET uri = EXCLUDED.uri
+			WHERE remote_posts.remote_actor_id = EXCLUDED.remote_actor_id
+			RETURNING id, created_at
+		`
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			post.RemoteActorID,
+			post.URI,
+			post.URL,
+			post.ContentHTML,
+			post.InReplyTo,
+			post.PostID,
+			post.Public,
+			post.PublishedAt,
+		).Scan(&post.ID, &post.ReceivedAt)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && len(recipientIDs) == 0) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		query = `
+			INSERT INTO remote_post_recipients (remote_post_id, user_id)
+			SELECT $1, unnest($2::bigint[])
+			ON CONFLICT DO NOTHING
+		`
+		_, err = tx.ExecContext(ctx, query, post.ID, pq.Array(recipientIDs))
+		return err
+	})
+}
+
+// GetRemoteReplies lists the public notes replying to postID, oldest first.
+func (store *FederationStore) GetRemoteReplies(ctx context.Context, postID int64, rq RemotePostsQuery) ([]RemotePost, PageInfo, error) {
+	return store.queryRemotePosts(ctx, "rp.post_id = $1 AND rp.public", "asc", postID, rq)
+}
+
+// GetRemoteMentions lists the notes addressed to userID, newest first.
+func (store *FederationStore) GetRemoteMentions(ctx context.Context, userID int64, rq RemotePostsQuery) ([]RemotePost, PageInfo, error) {
+	condition := "EXISTS (SELECT 1 FROM remote_post_recipients r WHERE r.remote_post_id = rp.id AND r.user_id = $1)"
+	return store.queryRemotePosts(ctx, condition, "desc", userID, rq)
+}
+
+// queryRemotePosts lists the remote posts matching condition, which binds $1
+// to id, in sort order of receipt.
+func (store *FederationStore) queryRemotePosts(ctx context.Context, condition string, sort string, id int64, rq RemotePostsQuery) ([]RemotePost, PageInfo, error) {
+	pageCondition, orderBy, cursorArgs := keyset(rq.Cursor, sort, "rp.created_at", "rp.id", 3)
+	query := `
+		SELECT rp.id, rp.remote_actor_id, a.uri, a.preferred_username, rp.uri, rp.url, rp.content_html,
+			rp.in_reply_to, rp.post_id, rp.public, rp.published_at, rp.created_at
+		FROM remote_posts rp
+		JOIN remote_actors a ON a.id = rp.remote_actor_id
+		WHERE ` + condition + ` AND ` + pageCondition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{id, rq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	posts := []RemotePost{}
+	for rows.Next() {
+		var p RemotePost
+		err := rows.Scan(
+			&p.ID,
+			&p.RemoteActorID,
+			&p.ActorURI,
+			&p.ActorUsername,
+			&p.URI,
+			&p.URL,
+			&p.ContentHTML,
+			&p.InReplyTo,
+			&p.PostID,
+			&p.Public,
+			&p.PublishedAt,
+			&p.ReceivedAt,
+		)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		posts = append(posts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	posts, page := paginate(posts, rq.Limit, rq.Cursor, func(p RemotePost) Cursor {
+		return cursorAt(p.ReceivedAt, p.ID)
+	})
+	return posts, page, nil
+}
+
+// DeleteRemotePost removes the post uri if it belongs to remoteActorID.
+func (store *FederationStore) DeleteRemotePost(ctx context.Context, remoteActorID int64, uri string) error {
+	query := `
+		DELETE FROM remote_posts WHERE remote_actor_id = $1 AND uri = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, remoteActorID, uri)
+	return err
+}
+
+// EnqueueDeliveries schedules payload for delivery to every inbox.
+func (store *FederationStore) EnqueueDeliveries(ctx context.Context, userID int64, inboxes []string, payload []byte) error {
+	if len(inboxes) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO deliveries (user_id, inbox, payload)
+		SELECT $1, inbox, $3 FROM unnest($2::text[]) AS inbox
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, userID, pq.Array(inboxes), payload)
+	return err
+}
+
+// ClaimDeliveries leases up to limit due deliveries for lease, so concurrent
+// workers and crashed attempts never lose or double post an activity for long.
+func (store *FederationStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	query := `
+		UPDATE deliveries SET next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM deliveries
+			WHERE failed_at IS NULL AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, inbox, payload, attempts
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Inbox, &d.Payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (store *FederationStore) CompleteDelivery(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM deliveries WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// RetryDelivery records a failed attempt and schedules the next one at
+// nextAttempt. A zero nextAttempt gives up on the delivery.
+func (store *FederationStore) RetryDelivery(ctx context.Context, id int64, nextAttempt time.Time, lastError string) error {
+	query := `
+		UPDATE deliveries SET
+			attempts = attempts + 1,
+			last_error = $3,
+			next_attempt_at = COALESCE($2, next_attempt_at),
+			failed_at = CASE WHEN $2::timestamptz IS NULL THEN NOW() END
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var next *time.Time
+	if !nextAttempt.IsZero() {
+		next = &nextAttempt
+	}
+	_, err := store.db.ExecContext(ctx, query, id, next, lastError)
+	return err
+}
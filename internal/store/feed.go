@@ -0,0 +1,275 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	FeedModeChronological = "chronological"
+	FeedModeRanked        = "ranked"
+)
+
+// FeedRanking holds the weights of the ranked feed. A post scores
+//
+//	RecencyWeight * 0.5^(age / HalfLife)
+//	+ EngagementWeight * ln(1 + comments + poll voters)
+//	+ AffinityWeight * ln(1 + viewer's recent comments on the author's posts)
+//
+// and only posts younger than Window are considered.
+type FeedRanking struct {
+	RecencyWeight    float64
+	EngagementWeight float64
+	AffinityWeight   float64
+	HalfLife         time.Duration
+	Window           time.Duration
+}
+
+// feedConditions selects the posts of $1's feed: their own posts and the posts of
+// the users they follow, narrowed by feedFilters.
+var feedConditions = `
+	(posts.user_id = $1 OR EXISTS (
+		SELECT 1 FROM followers WHERE followers.user_id = posts.user_id AND followers.follower_id = $1
+	)) AND ` + feedFilters
+
+// feedFilters keeps the live posts $1 may see and did not mute the author of,
+// filtered by the tsquery $3, tags $4 and the optional since $5 / until $6 bounds.
+var feedFilters = `
+	posts.deleted_at IS NULL AND
+	` + postVisibleTo("posts", "$1") + ` AND
+	` + notMutedBy("$1", "posts.user_id") + ` AND
+	($5::timestamptz IS NULL OR posts.created_at > $5) AND
+	($6::timestamptz IS NULL OR posts.created_at < $6) AND
+	($3 = '' OR posts.search_vector @@ to_tsquery('english', $3)) AND
+	(posts.tags @> $4 OR $4 = '{}')
+`
+
+const feedColumns = `
+	posts.id,
+	posts.user_id,
+	posts.title,
+	posts.content,
+	posts.content_html,
+	posts.created_at,
+	posts.tags,
+	posts.visibility,
+	posts.comments_locked_at IS NOT NULL,
+	posts.community_id,
+	users.username,
+	(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL) AS comments_count
+`
+
+type TimelineEntry struct {
+	PostID    int64
+	CreatedAt time.Time
+}
+
+// GetUserFeed returns the user's own posts together with the posts of the users
+// they follow, newest first by default, paginated on (created_at, id).
+func (store *PostStore) GetUserFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error) {
+	return store.queryFeed(ctx, userId, feedQuery, feedConditions)
+}
+
+// GetTimelineFeed is GetUserFeed restricted to the materialised timeline postIDs
+// and the posts of authorIDs, whose posts are not fanned out on write.
+func (store *PostStore) GetTimelineFeed(ctx context.Context, userId int64, postIDs []int64, authorIDs []int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error) {
+	return store.queryFeed(ctx, userId, feedQuery, feedConditions+" AND (posts.id = ANY($7) OR posts.user_id = ANY($8))", pq.Array(postIDs), pq.Array(authorIDs))
+}
+
+// GetCommunityFeed returns the posts published into communityID that userId may
+// see, whoever they follow.
+func (store *PostStore) GetCommunityFeed(ctx context.Context, userId int64, communityID int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error) {
+	return store.queryFeed(ctx, userId, feedQuery, feedFilters+" AND posts.community_id = $7", communityID)
+}
+
+// queryFeed reads a page of the posts matching conditions, which bind the feed
+// arguments as feedConditions does and sourceArgs from $7.
+func (store *PostStore) queryFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery, conditions string, sourceArgs ...any) ([]PostWithMetadata, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(feedQuery.Cursor, feedQuery.Sort, "posts.created_at", "posts.id", 7+len(sourceArgs))
+	query := `
+		SELECT ` + feedColumns + `
+		FROM posts
+		JOIN users ON posts.user_id = users.id
+		WHERE ` + conditions + ` AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append(append(feedArgs(userId, feedQuery), sourceArgs...), cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	postsWithMetadata := []PostWithMetadata{}
+	for rows.Next() {
+		p, err := scanFeedPost(rows)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		postsWithMetadata = append(postsWithMetadata, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	postsWithMetadata, page := paginate(postsWithMetadata, feedQuery.Limit, feedQuery.Cursor, func(p PostWithMetadata) Cursor {
+		return cursorAt(p.CreatedAt, p.ID)
+	})
+	return postsWithMetadata, page, nil
+}
+
+// GetTimelineEntries returns the newest limit posts of userId and of the users they
+// follow, leaving out excludedAuthorIDs. It is used to rebuild a timeline.
+func (store *PostStore) GetTimelineEntries(ctx context.Context, userId int64, excludedAuthorIDs []int64, limit int) ([]TimelineEntry, error) {
+	query := `
+		SELECT posts.id, posts.created_at FROM posts
+		WHERE
+			(posts.user_id = $1 OR EXISTS (
+				SELECT 1 FROM followers WHERE followers.user_id = posts.user_id AND followers.follower_id = $1
+			)) AND
+			NOT posts.user_id = ANY($2) AND
+			posts.deleted_at IS NULL
+		ORDER BY posts.created_at DESC, posts.id DESC
+		LIMIT $3
+	`
+	return store.queryTimelineEntries(ctx, query, userId, pq.Array(excludedAuthorIDs), limit)
+}
+
+// GetAuthorTimelineEntries returns the newest limit posts of authorID.
+func (store *PostStore) GetAuthorTimelineEntries(ctx context.Context, authorID int64, limit int) ([]TimelineEntry, error) {
+	query := `
+		SELECT posts.id, posts.created_at FROM posts
+		WHERE posts.user_id = $1 AND posts.deleted_at IS NULL
+		ORDER BY posts.created_at DESC, posts.id DESC
+		LIMIT $2
+	`
+	return store.queryTimelineEntries(ctx, query, authorID, limit)
+}
+
+func (store *PostStore) queryTimelineEntries(ctx context.Context, query string, args ...any) ([]TimelineEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []TimelineEntry{}
+	for rows.Next() {
+		var entry TimelineEntry
+		if err := rows.Scan(&entry.PostID, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetRankedFeed returns the same posts as GetUserFeed ordered by score, highest
+// first. Scores are computed as of the first page's time, which its cursors carry,
+// so paging through the feed stays stable while posts age.
+func (store *PostStore) GetRankedFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery, ranking FeedRanking) ([]PostWithMetadata, PageInfo, error) {
+	asOf := time.Now().UTC()
+	if feedQuery.Cursor != nil {
+		asOf = feedQuery.Cursor.CreatedAt
+	}
+	condition, orderBy, cursorArgs := scoreKeyset(feedQuery.Cursor, "score", "id", 13)
+	query := `
+		WITH ranked AS (
+			SELECT ` + feedColumns + `,
+				$8::float8 * power(0.5, extract(epoch FROM ($7 - posts.created_at)) / $9::float8) +
+				$10::float8 * ln(1 + (
+					SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL
+				) + (
+					SELECT COUNT(DISTINCT v.user_id) FROM polls p JOIN poll_votes v ON v.poll_id = p.id WHERE p.post_id = posts.id
+				)) +
+				$11::float8 * ln(1 + (
+					SELECT COUNT(*) FROM comments c JOIN posts cp ON cp.id = c.post_id
+					WHERE cp.user_id = posts.user_id AND c.user_id = $1 AND posts.user_id <> $1 AND
+						c.deleted_at IS NULL AND c.created_at > $7 - interval '30 days'
+				)) AS score
+			FROM posts
+			JOIN users ON posts.user_id = users.id
+			WHERE ` + feedConditions + ` AND
+				posts.created_at <= $7 AND
+				posts.created_at > $7 - make_interval(secs => $12::float8)
+		)
+		SELECT * FROM ranked
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append(
+		feedArgs(userId, feedQuery),
+		asOf,
+		ranking.RecencyWeight,
+		ranking.HalfLife.Seconds(),
+		ranking.EngagementWeight,
+		ranking.AffinityWeight,
+		ranking.Window.Seconds(),
+	)
+	rows, err := store.db.QueryContext(ctx, query, append(args, cursorArgs...)...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	postsWithMetadata := []PostWithMetadata{}
+	for rows.Next() {
+		var score float64
+		p, err := scanFeedPost(rows, &score)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		p.Score = &score
+		postsWithMetadata = append(postsWithMetadata, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	postsWithMetadata, page := paginate(postsWithMetadata, feedQuery.Limit, feedQuery.Cursor, func(p PostWithMetadata) Cursor {
+		return Cursor{CreatedAt: asOf, ID: p.ID, Score: *p.Score}
+	})
+	return postsWithMetadata, page, nil
+}
+
+func feedArgs(userId int64, feedQuery PaginatedFeedQuery) []any {
+	// A search without terms does not filter the feed.
+	search, _ := buildTSQuery(feedQuery.Search)
+	return []any{userId, feedQuery.Limit + 1, search, pq.Array(feedQuery.Tags), feedQuery.Since, feedQuery.Until}
+}
+
+// scanFeedPost scans a row selected with feedColumns followed by extra columns.
+func scanFeedPost(rows *sql.Rows, extra ...any) (PostWithMetadata, error) {
+	var (
+		p          PostWithMetadata
+		cachedHTML sql.NullString
+		createdAt  time.Time
+	)
+	dest := append([]any{
+		&p.ID,
+		&p.UserID,
+		&p.Title,
+		&p.Content,
+		&cachedHTML,
+		&createdAt,
+		pq.Array(&p.Tags),
+		&p.Visibility,
+		&p.Locked,
+		&p.CommunityID,
+		&p.User.Username,
+		&p.CommentsCount,
+	}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return p, err
+	}
+	p.ContentHTML = contentHTML(p.Content, cachedHTML)
+	p.CreatedAt = createdAt.Format(time.RFC3339)
+	return p, nil
+}
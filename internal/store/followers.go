@@ -3,6 +3,15 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSelfFollow             = errors.New("You cannot follow yourself")
+	ErrAlreadyFollowing       = errors.New("Already following this user")
+	ErrFollowAlreadyRequested = errors.New("Already requested to follow this user")
 )
 
 type Follower struct {
@@ -11,28 +20,380 @@ type Follower struct {
 	CreatedAt  string `json:"created_at"`
 }
 
+// FollowEntry is a user listed among the followers or the followings of another.
+type FollowEntry struct {
+	ID         int64  `json:"id"`
+	Username   string `json:"username"`
+	FollowedAt string `json:"followed_at"`
+}
+
+// FollowRequest is a user waiting for a private account to approve their follow.
+type FollowRequest struct {
+	ID          int64  `json:"id"`
+	Username    string `json:"username"`
+	RequestedAt string `json:"requested_at"`
+}
+
+// Relationship is how the viewer relates to another user. Requested is set
+// while the viewer's follow request to a private account is pending.
+type Relationship struct {
+	Following  bool `json:"following"`
+	FollowedBy bool `json:"followed_by"`
+	Requested  bool `json:"requested"`
+	Blocking   bool `json:"blocking"`
+	Muted      bool `json:"muted"`
+}
+
+// UserProfile is a user as shown to a viewer, with their follow counts and,
+// unless they are the viewer, how the viewer relates to them.
+type UserProfile struct {
+	*User
+	IsPrivate      bool          `json:"is_private"`
+	FollowersCount int64         `json:"followers_count"`
+	FollowingCount int64         `json:"following_count"`
+	Relationship   *Relationship `json:"relationship,omitempty"`
+}
+
 type FollowerStore struct {
-	db *sql.DB
+	db    *sql.DB
+	hooks *Hooks
 }
 
-func (store *FollowerStore) Follow(ctx context.Context, followerID int64, userID int64) error {
+// Follow makes followerID follow userID, or asks to when userID is a private
+// account, which requested reports. It returns ErrSelfFollow, ErrorNotFound
+// when userID is not an active user, ErrBlocked when either blocked the other
+// and ErrAlreadyFollowing or ErrFollowAlreadyRequested when there is nothing
+// left to do.
+func (store *FollowerStore) Follow(ctx context.Context, followerID int64, userID int64) (bool, error) {
+	requested, err := store.follow(ctx, followerID, userID)
+	if err != nil {
+		return false, err
+	}
+	store.hooks.followed(ctx, followerID, userID, requested)
+	return requested, nil
+}
+
+func (store *FollowerStore) follow(ctx context.Context, followerID int64, userID int64) (bool, error) {
+	if followerID == userID {
+		return false, ErrSelfFollow
+	}
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var isPrivate, following, blocked bool
 	query := `
-		INSERT INTO followers(user_id, follower_id) VALUES ($1, $2)
+		SELECT u.is_private, EXISTS (SELECT 1 FROM followers f WHERE f.user_id = u.id AND f.follower_id = $2),
+			NOT ` + notBlocked("u.id", "$2") + `
+		FROM users u
+		WHERE u.id = $1 AND u.is_activated
+	`
+	err := store.db.QueryRowContext(ctx, query, userID, followerID).Scan(&isPrivate, &following, &blocked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrorNotFound
+		}
+		return false, err
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+	if following {
+		return false, ErrAlreadyFollowing
+	}
+
+	query = `INSERT INTO followers(user_id, follower_id) VALUES ($1, $2)`
+	duplicate := ErrAlreadyFollowing
+	if isPrivate {
+		query = `INSERT INTO follow_requests(user_id, requester_id) VALUES ($1, $2)`
+		duplicate = ErrFollowAlreadyRequested
+	}
+	if _, err := store.db.ExecContext(ctx, query, userID, followerID); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return false, duplicate
+		}
+		return false, err
+	}
+	return isPrivate, nil
+}
+
+// Unfollow stops followerID following userID, or withdraws their pending
+// request. It returns ErrorNotFound when there is neither.
+func (store *FollowerStore) Unfollow(ctx context.Context, followerID int64, userID int64) error {
+	query := `
+		WITH unfollowed AS (
+			DELETE FROM followers WHERE user_id = $1 AND follower_id = $2 RETURNING 1
+		), withdrawn AS (
+			DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2 RETURNING 1
+		)
+		SELECT (SELECT COUNT(*) FROM unfollowed) + (SELECT COUNT(*) FROM withdrawn)
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	_, err := store.db.ExecContext(ctx, query, userID, followerID)
-	return err
+	var removed int64
+	if err := store.db.QueryRowContext(ctx, query, userID, followerID).Scan(&removed); err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrorNotFound
+	}
+	return nil
 }
 
-func (store *FollowerStore) Unfollow(ctx context.Context, followerID int64, userID int64) error {
+// GetFollowRequests lists the pending follow requests of userID, oldest first.
+func (store *FollowerStore) GetFollowRequests(ctx context.Context, userID int64, fq FollowQuery) ([]FollowRequest, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(fq.Cursor, "asc", "fr.created_at", "fr.requester_id", 3)
 	query := `
-		DELETE FROM followers WHERE user_id = $1 AND follower_id = $2
+		SELECT u.id, u.username, fr.created_at
+		FROM follow_requests fr
+		JOIN users u ON u.id = fr.requester_id
+		WHERE fr.user_id = $1 AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	_, err := store.db.ExecContext(ctx, query, userID, followerID)
-	return err
+	args := append([]any{userID, fq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	requests := []FollowRequest{}
+	for rows.Next() {
+		var fr FollowRequest
+		if err := rows.Scan(&fr.ID, &fr.Username, &fr.RequestedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		requests = append(requests, fr)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	requests, page := paginate(requests, fq.Limit, fq.Cursor, func(fr FollowRequest) Cursor {
+		return cursorAt(fr.RequestedAt, fr.ID)
+	})
+	return requests, page, nil
+}
+
+// ApproveFollowRequest turns the pending request of requesterID into a follow
+// of userID.
+func (store *FollowerStore) ApproveFollowRequest(ctx context.Context, userID int64, requesterID int64) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2`
+		res, err := tx.ExecContext(ctx, query, userID, requesterID)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrorNotFound
+		}
+		query = `INSERT INTO followers(user_id, follower_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		_, err = tx.ExecContext(ctx, query, userID, requesterID)
+		return err
+	})
+}
+
+// ApproveAllFollowRequests turns every pending request to userID into a follow,
+// as when they stop being a private account, and returns the requesters.
+func (store *FollowerStore) ApproveAllFollowRequests(ctx context.Context, userID int64) ([]int64, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM follow_requests WHERE user_id = $1
+			RETURNING requester_id
+		), followed AS (
+			INSERT INTO followers (user_id, follower_id)
+			SELECT $1, requester_id FROM deleted
+			ON CONFLICT DO NOTHING
+		)
+		SELECT requester_id FROM deleted
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (store *FollowerStore) RejectFollowRequest(ctx context.Context, userID int64, requesterID int64) error {
+	query := `DELETE FROM follow_requests WHERE user_id = $1 AND requester_id = $2`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := store.db.ExecContext(ctx, query, userID, requesterID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// GetFollowers lists the users following userID, most recent first.
+func (store *FollowerStore) GetFollowers(ctx context.Context, userID int64, fq FollowQuery) ([]FollowEntry, PageInfo, error) {
+	return store.queryFollows(ctx, "f.user_id", "f.follower_id", userID, fq)
+}
+
+// GetFollowing lists the users userID follows, most recent first.
+func (store *FollowerStore) GetFollowing(ctx context.Context, userID int64, fq FollowQuery) ([]FollowEntry, PageInfo, error) {
+	return store.queryFollows(ctx, "f.follower_id", "f.user_id", userID, fq)
+}
+
+// queryFollows lists the users in listedCol of the follows whose ownerCol is userID.
+func (store *FollowerStore) queryFollows(ctx context.Context, ownerCol string, listedCol string, userID int64, fq FollowQuery) ([]FollowEntry, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(fq.Cursor, "desc", "f.created_at", listedCol, 3)
+	query := `
+		SELECT u.id, u.username, f.created_at
+		FROM followers f
+		JOIN users u ON u.id = ` + listedCol + `
+		WHERE ` + ownerCol + ` = $1 AND ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{userID, fq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	entries := []FollowEntry{}
+	for rows.Next() {
+		var e FollowEntry
+		if err := rows.Scan(&e.ID, &e.Username, &e.FollowedAt); err != nil {
+			return nil, PageInfo{}, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	entries, page := paginate(entries, fq.Limit, fq.Cursor, func(e FollowEntry) Cursor {
+		return cursorAt(e.FollowedAt, e.ID)
+	})
+	return entries, page, nil
+}
+
+// GetProfile returns user with their follow counts as seen by viewerID. Users
+// who blocked viewerID are reported as ErrorNotFound; users viewerID blocked
+// stay visible, with Blocking set, so they can be unblocked.
+func (store *FollowerStore) GetProfile(ctx context.Context, user *User, viewerID int64) (*UserProfile, error) {
+	query := `
+		SELECT
+			EXISTS (SELECT 1 FROM user_blocks WHERE user_id = $1 AND blocked_id = $2),
+			(SELECT is_private FROM users WHERE id = $1),
+			(SELECT COUNT(*) FROM followers WHERE user_id = $1),
+			(SELECT COUNT(*) FROM followers WHERE follower_id = $1),
+			EXISTS (SELECT 1 FROM followers WHERE user_id = $1 AND follower_id = $2),
+			EXISTS (SELECT 1 FROM followers WHERE user_id = $2 AND follower_id = $1),
+			EXISTS (SELECT 1 FROM follow_requests WHERE user_id = $1 AND requester_id = $2),
+			EXISTS (SELECT 1 FROM user_blocks WHERE user_id = $2 AND blocked_id = $1),
+			EXISTS (SELECT 1 FROM user_mutes WHERE user_id = $2 AND muted_id = $1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	profile := &UserProfile{User: user}
+	var (
+		relationship Relationship
+		blockedBy    bool
+	)
+	err := store.db.QueryRowContext(ctx, query, user.ID, viewerID).Scan(
+		&blockedBy,
+		&profile.IsPrivate,
+		&profile.FollowersCount,
+		&profile.FollowingCount,
+		&relationship.Following,
+		&relationship.FollowedBy,
+		&relationship.Requested,
+		&relationship.Blocking,
+		&relationship.Muted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if blockedBy {
+		return nil, ErrorNotFound
+	}
+	if user.ID != viewerID {
+		profile.Relationship = &relationship
+	}
+	return profile, nil
+}
+
+// GetFollowerIDs returns the IDs of the users following userID.
+func (store *FollowerStore) GetFollowerIDs(ctx context.Context, userID int64) ([]int64, error) {
+	query := `
+		SELECT follower_id FROM followers WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetCelebrities returns the users with at least threshold followers. It reads
+// the whole followers table and is meant for periodic refreshes, not requests.
+func (store *FollowerStore) GetCelebrities(ctx context.Context, threshold int64) ([]int64, error) {
+	query := `
+		SELECT user_id FROM followers GROUP BY user_id HAVING COUNT(*) >= $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration*6)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, threshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
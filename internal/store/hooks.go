@@ -0,0 +1,59 @@
+package store
+
+import "context"
+
+// Hooks let other packages act on store operations, so handlers do not have to
+// remember to call them after each write. A hook runs once its operation has
+// succeeded and handles its own failures. Unset hooks are skipped.
+type Hooks struct {
+	// Followed runs when followerID follows userID, or asks to when requested.
+	Followed func(ctx context.Context, followerID int64, userID int64, requested bool)
+	// PostSaved runs when a post is created or edited.
+	PostSaved func(ctx context.Context, post *Post)
+	// PostDeleted runs when a post is soft deleted, and with deleted false when
+	// it is restored.
+	PostDeleted    func(ctx context.Context, postID int64, deleted bool)
+	CommentCreated func(ctx context.Context, comment *Comment)
+	// CommentDeleted runs when a comment is soft deleted, and with deleted
+	// false when it is restored.
+	CommentDeleted func(ctx context.Context, commentID int64, deleted bool)
+	// Reacted runs when userID first reacts to a post, not when they change
+	// the kind of their reaction.
+	Reacted func(ctx context.Context, reaction *Reaction)
+}
+
+func (h *Hooks) followed(ctx context.Context, followerID int64, userID int64, requested bool) {
+	if h != nil && h.Followed != nil {
+		h.Followed(ctx, followerID, userID, requested)
+	}
+}
+
+func (h *Hooks) postSaved(ctx context.Context, post *Post) {
+	if h != nil && h.PostSaved != nil {
+		h.PostSaved(ctx, post)
+	}
+}
+
+func (h *Hooks) postDeleted(ctx context.Context, postID int64, deleted bool) {
+	if h != nil && h.PostDeleted != nil {
+		h.PostDeleted(ctx, postID, deleted)
+	}
+}
+
+func (h *Hooks) commentCreated(ctx context.Context, comment *Comment) {
+	if h != nil && h.CommentCreated != nil {
+		h.CommentCreated(ctx, comment)
+	}
+}
+
+func (h *Hooks) commentDeleted(ctx context.Context, commentID int64, deleted bool) {
+	if h != nil && h.CommentDeleted != nil {
+		h.CommentDeleted(ctx, commentID, deleted)
+	}
+}
+
+func (h *Hooks) reacted(ctx context.Context, reaction *Reaction) {
+	if h != nil && h.Reacted != nil {
+		h.Reacted(ctx, reaction)
+	}
+}
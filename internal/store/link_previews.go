@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const maxLinksPerPost = 5
+
+var linkRegexp = regexp.MustCompile(`https?://[^\s<>()"'\x60\]\[]+`)
+
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	SiteName    string    `json:"site_name"`
+	Failed      bool      `json:"-"`
+	FetchedAt   time.Time `json:"-"`
+}
+
+type LinkPreviewStore struct {
+	db *sql.DB
+}
+
+// ParseLinks returns up to maxLinksPerPost distinct http(s) URLs found in content.
+func ParseLinks(content string) []string {
+	seen := map[string]bool{}
+	links := []string{}
+	for _, link := range linkRegexp.FindAllString(content, -1) {
+		link = strings.TrimRight(link, ".,;:!?")
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+		if len(links) == maxLinksPerPost {
+			break
+		}
+	}
+	return links
+}
+
+func replacePostLinks(ctx context.Context, tx *sql.Tx, post *Post) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_links WHERE post_id = $1`, post.ID); err != nil {
+		return err
+	}
+	for i, link := range ParseLinks(post.Content) {
+		_, err := tx.ExecContext(ctx, `INSERT INTO post_links (post_id, url, position) VALUES ($1, $2, $3)`, post.ID, link, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *LinkPreviewStore) GetByURL(ctx context.Context, url string) (*LinkPreview, error) {
+	query := `
+		SELECT url, title, description, image, site_name, failed, fetched_at FROM link_previews WHERE url = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var preview LinkPreview
+	err := store.db.QueryRowContext(ctx, query, url).Scan(
+		&preview.URL,
+		&preview.Title,
+		&preview.Description,
+		&preview.Image,
+		&preview.SiteName,
+		&preview.Failed,
+		&preview.FetchedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &preview, nil
+}
+
+func (store *LinkPreviewStore) Upsert(ctx context.Context, preview *LinkPreview) error {
+	query := `
+		INSERT INTO link_previews (url, title, description, image, site_name, failed, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (url) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			image = EXCLUDED.image,
+			site_name = EXCLUDED.site_name,
+			failed = EXCLUDED.failed,
+			fetched_at = EXCLUDED.fetched_at
+		RETURNING fetched_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return store.db.QueryRowContext(
+		ctx,
+		query,
+		preview.URL,
+		preview.Title,
+		preview.Description,
+		preview.Image,
+		preview.SiteName,
+		preview.Failed,
+	).Scan(&preview.FetchedAt)
+}
+
+// GetByPostIDs returns the successfully fetched previews of postIDs keyed by post ID,
+// in the order the links appear in each post.
+func (store *LinkPreviewStore) GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]LinkPreview, error) {
+	previews := map[int64][]LinkPreview{}
+	if len(postIDs) == 0 {
+		return previews, nil
+	}
+	query := `
+		SELECT pl.post_id, lp.url, lp.title, lp.description, lp.image, lp.site_name
+		FROM post_links pl
+		JOIN link_previews lp ON lp.url = pl.url
+		WHERE pl.post_id = ANY($1) AND NOT lp.failed
+		ORDER BY pl.post_id, pl.position
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			postID  int64
+			preview LinkPreview
+		)
+		if err := rows.Scan(&postID, &preview.URL, &preview.Title, &preview.Description, &preview.Image, &preview.SiteName); err != nil {
+			return nil, err
+		}
+		previews[postID] = append(previews[postID], preview)
+	}
+	return previews, rows.Err()
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrSelfMute     = errors.New("You cannot mute yourself")
+	ErrAlreadyMuted = errors.New("Already muted this user")
+)
+
+// notMutedBy is the SQL condition that the user in muterCol did not mute the
+// user in authorCol.
+func notMutedBy(muterCol, authorCol string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1 FROM user_mutes um WHERE um.user_id = %s AND um.muted_id = %s
+	)`, muterCol, authorCol)
+}
+
+// MuteStore keeps the users whose posts and notifications a user does not want
+// to see. Unlike a block, the muted user is not told and can still interact.
+type MuteStore struct {
+	db *sql.DB
+}
+
+// Mute returns ErrSelfMute, ErrorNotFound when mutedID is not an active user
+// and ErrAlreadyMuted.
+func (store *MuteStore) Mute(ctx context.Context, userID int64, mutedID int64) error {
+	if userID == mutedID {
+		return ErrSelfMute
+	}
+	query := `
+		INSERT INTO user_mutes (user_id, muted_id)
+		SELECT $1, u.id FROM users u WHERE u.id = $2 AND u.is_activated
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := store.db.ExecContext(ctx, query, userID, mutedID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrAlreadyMuted
+		}
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// Unmute returns ErrorNotFound when userID did not mute mutedID.
+func (store *MuteStore) Unmute(ctx context.Context, userID int64, mutedID int64) error {
+	query := `DELETE FROM user_mutes WHERE user_id = $1 AND muted_id = $2`
+	return deleteRelation(ctx, store.db, query, userID, mutedID)
+}
+
+// GetMuted lists the users userID muted, most recent first.
+func (store *MuteStore) GetMuted(ctx context.Context, userID int64, fq FollowQuery) ([]RelatedUser, PageInfo, error) {
+	return queryRelatedUsers(ctx, store.db, "user_mutes", "muted_id", userID, fq)
+}
+
+// GetMuterIDs returns the IDs of the users who muted userID.
+func (store *MuteStore) GetMuterIDs(ctx context.Context, userID int64) ([]int64, error) {
+	query := `SELECT user_id FROM user_mutes WHERE muted_id = $1`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
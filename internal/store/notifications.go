@@ -0,0 +1,363 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+const (
+	NotificationFollow        = "follow"
+	NotificationFollowRequest = "follow_request"
+	NotificationComment       = "comment"
+	NotificationReply         = "reply"
+	NotificationMention       = "mention"
+	NotificationReaction      = "reaction"
+)
+
+// NotificationActorsShown is how many of the latest actors a notification lists.
+const NotificationActorsShown = 3
+
+// NotificationEvent is something ActorID did that concerns the recipients.
+// Events with the same GroupKey coalesce into one unread notification per
+// recipient, which then points at the latest post and comment of the group.
+type NotificationEvent struct {
+	Type      string
+	GroupKey  string
+	ActorID   int64
+	PostID    *int64
+	CommentID *int64
+}
+
+type NotificationActor struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type Notification struct {
+	ID         int64               `json:"id"`
+	UserID     int64               `json:"user_id"`
+	Type       string              `json:"type"`
+	PostID     *int64              `json:"post_id"`
+	CommentID  *int64              `json:"comment_id"`
+	Actors     []NotificationActor `json:"actors"`
+	ActorCount int                 `json:"actor_count"`
+	CreatedAt  string              `json:"created_at"`
+	UpdatedAt  string              `json:"updated_at"`
+	ReadAt     *string             `json:"read_at"`
+}
+
+// notificationColumns selects a notification aliased n. Notifications about
+// deleted posts or comments are hidden rather than removed, so restoring the
+// post brings them back.
+const (
+	notificationColumns = `
+		n.id, n.user_id, n.type, n.post_id, n.comment_id, n.created_at, n.updated_at, n.read_at,
+		(SELECT COUNT(*) FROM notification_actors na WHERE na.notification_id = n.id)`
+	notificationVisible = `
+		(n.post_id IS NULL OR EXISTS (SELECT 1 FROM posts np WHERE np.id = n.post_id AND np.deleted_at IS NULL)) AND
+		(n.comment_id IS NULL OR EXISTS (SELECT 1 FROM comments nc WHERE nc.id = n.comment_id AND nc.deleted_at IS NULL))`
+)
+
+type NotificationStore struct {
+	db *sql.DB
+}
+
+// Notify records event for every recipient but the actor and returns the IDs of
+// the notifications it created or coalesced into. A mention is only sent once
+// per recipient and group, so editing a post notifies newly mentioned users only.
+func (store *NotificationStore) Notify(ctx context.Context, event NotificationEvent, recipientIDs []int64) ([]int64, error) {
+	if len(recipientIDs) == 0 {
+		return nil, nil
+	}
+	ids := []int64{}
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO notifications (user_id, type, group_key, post_id, comment_id)
+			SELECT r.id, $2::varchar, $3::varchar, $4::bigint, $5::bigint
+			FROM unnest($1::bigint[]) AS r (id)
+			WHERE
+				r.id <> $6 AND
+				` + notBlocked("r.id", "$6") + ` AND
+				` + notMutedBy("r.id", "$6") + ` AND
+				(NOT $7::boolean OR NOT EXISTS (
+					SELECT 1 FROM notifications o WHERE o.user_id = r.id AND o.group_key = $3
+				))
+			ON CONFLICT (user_id, group_key) WHERE read_at IS NULL
+			DO UPDATE SET updated_at = NOW(), post_id = EXCLUDED.post_id, comment_id = EXCLUDED.comment_id
+			RETURNING id
+		`
+		rows, err := tx.QueryContext(
+			ctx,
+			query,
+			pq.Array(recipientIDs),
+			event.Type,
+			event.GroupKey,
+			event.PostID,
+			event.CommentID,
+			event.ActorID,
+			event.Type == NotificationMention,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		query = `
+			INSERT INTO notification_actors (notification_id, actor_id)
+			SELECT unnest($1::bigint[]), $2
+			ON CONFLICT (notification_id, actor_id) DO UPDATE SET created_at = NOW()
+		`
+		_, err = tx.ExecContext(ctx, query, pq.Array(ids), event.ActorID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetByUser returns the notifications of userID, most recently active first.
+func (store *NotificationStore) GetByUser(ctx context.Context, userID int64, nq NotificationQuery) ([]Notification, PageInfo, error) {
+	condition, orderBy, cursorArgs := keyset(nq.Cursor, "desc", "n.updated_at", "n.id", 4)
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notifications n
+		WHERE
+			n.user_id = $1 AND
+			(NOT $3 OR n.read_at IS NULL) AND
+			` + notificationVisible + ` AND
+			` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	args := append([]any{userID, nq.Limit + 1, nq.Unread}, cursorArgs...)
+	notifications, err := store.query(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	notifications, page := paginate(notifications, nq.Limit, nq.Cursor, func(n Notification) Cursor {
+		return cursorAt(n.UpdatedAt, n.ID)
+	})
+	if err := store.attachActors(ctx, notifications); err != nil {
+		return nil, PageInfo{}, err
+	}
+	return notifications, page, nil
+}
+
+// GetByIDs returns the notifications with the given IDs, whoever they belong to.
+func (store *NotificationStore) GetByIDs(ctx context.Context, ids []int64) ([]Notification, error) {
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notifications n
+		WHERE n.id = ANY($1) AND ` + notificationVisible + `
+		ORDER BY n.id
+	`
+	notifications, err := store.query(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	if err := store.attachActors(ctx, notifications); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (store *NotificationStore) CountUnread(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		SELECT COUNT(*) FROM notifications n
+		WHERE n.user_id = $1 AND n.read_at IS NULL AND ` + notificationVisible
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var count int64
+	err := store.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks a notification of userID as read. Reading it again is a no-op.
+func (store *NotificationStore) MarkRead(ctx context.Context, userID int64, id int64) error {
+	query := `
+		UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks every unread notification of userID as read and returns how many there were.
+func (store *NotificationStore) MarkAllRead(ctx context.Context, userID int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetMentionedUserIDs returns the users mentioned in postID who can see it.
+func (store *NotificationStore) GetMentionedUserIDs(ctx context.Context, postID int64) ([]int64, error) {
+	query := `
+		SELECT m.user_id
+		FROM post_mentions m
+		JOIN posts p ON p.id = m.post_id
+		WHERE m.post_id = $1 AND p.deleted_at IS NULL AND ` + postVisibleTo("p", "m.user_id")
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetUnreadRecipientIDs returns the users with unread notifications about
+// postID or about commentID. Pass 0 for the one that does not apply.
+func (store *NotificationStore) GetUnreadRecipientIDs(ctx context.Context, postID int64, commentID int64) ([]int64, error) {
+	query := `
+		SELECT DISTINCT n.user_id FROM notifications n
+		WHERE n.read_at IS NULL AND (n.post_id = $1 OR n.comment_id = $2)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, postID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (store *NotificationStore) query(ctx context.Context, query string, args ...any) ([]Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notifications := []Notification{}
+	for rows.Next() {
+		var (
+			n         Notification
+			postID    sql.NullInt64
+			commentID sql.NullInt64
+			readAt    sql.NullTime
+		)
+		err := rows.Scan(&n.ID, &n.UserID, &n.Type, &postID, &commentID, &n.CreatedAt, &n.UpdatedAt, &readAt, &n.ActorCount)
+		if err != nil {
+			return nil, err
+		}
+		if postID.Valid {
+			n.PostID = &postID.Int64
+		}
+		if commentID.Valid {
+			n.CommentID = &commentID.Int64
+		}
+		n.ReadAt = formatNullTime(readAt)
+		n.Actors = []NotificationActor{}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// attachActors fills in the latest actors of each notification.
+func (store *NotificationStore) attachActors(ctx context.Context, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(notifications))
+	byID := make(map[int64]*Notification, len(notifications))
+	for i := range notifications {
+		ids[i] = notifications[i].ID
+		byID[notifications[i].ID] = &notifications[i]
+	}
+	query := `
+		SELECT a.notification_id, u.id, u.username
+		FROM (
+			SELECT na.notification_id, na.actor_id,
+				ROW_NUMBER() OVER (PARTITION BY na.notification_id ORDER BY na.created_at DESC, na.actor_id DESC) AS position
+			FROM notification_actors na
+			WHERE na.notification_id = ANY($1)
+		) a
+		JOIN users u ON u.id = a.actor_id
+		WHERE a.position <= $2
+		ORDER BY a.notification_id, a.position
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, pq.Array(ids), NotificationActorsShown)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			notificationID int64
+			actor          NotificationActor
+		)
+		if err := rows.Scan(&notificationID, &actor.ID, &actor.Username); err != nil {
+			return err
+		}
+		n := byID[notificationID]
+		n.Actors = append(n.Actors, actor)
+	}
+	return rows.Err()
+}
@@ -4,14 +4,18 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type PaginatedFeedQuery struct {
-	Limit  int      `json:"sort" validate:"gte=1,lte=20"`
-	Offset int      `json:"sort" validate:"gte=0"`
-	Sort   string   `json:"sort" validate:"oneof=asc desc"`
-	Tags   []string `json:"tags" validate:"max=5"`
-	Search string   `json:"search" validate:"max=100"`
+	Limit  int        `json:"limit" validate:"gte=1,lte=20"`
+	Cursor *Cursor    `json:"cursor"`
+	Sort   string     `json:"sort" validate:"oneof=asc desc"`
+	Tags   []string   `json:"tags" validate:"max=5"`
+	Search string     `json:"search" validate:"max=100"`
+	Since  *time.Time `json:"since"`
+	Until  *time.Time `json:"until"`
+	Mode   string     `json:"mode" validate:"oneof=chronological ranked"`
 }
 
 func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error) {
@@ -24,14 +28,11 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 		}
 		fq.Limit = l
 	}
-	offset := query.Get("offset")
-	if offset != "" {
-		o, err := strconv.Atoi(offset)
-		if err != nil {
-			return fq, err
-		}
-		fq.Offset = o
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return fq, err
 	}
+	fq.Cursor = cursor
 	sort := query.Get("sort")
 	if sort != "" {
 		fq.Sort = sort
@@ -44,5 +45,275 @@ func (fq PaginatedFeedQuery) Parse(r *http.Request) (PaginatedFeedQuery, error)
 	if search != "" {
 		fq.Search = search
 	}
+	since := query.Get("since")
+	if since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return fq, err
+		}
+		fq.Since = &t
+	}
+	until := query.Get("until")
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fq, err
+		}
+		fq.Until = &t
+	}
+	mode := query.Get("mode")
+	if mode != "" {
+		fq.Mode = mode
+	}
+	return fq, nil
+}
+
+type PaginatedCommentsQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+	Sort   string  `json:"sort" validate:"oneof=asc desc"`
+}
+
+func (cq PaginatedCommentsQuery) Parse(r *http.Request) (PaginatedCommentsQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return cq, err
+		}
+		cq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return cq, err
+	}
+	cq.Cursor = cursor
+	sort := query.Get("sort")
+	if sort != "" {
+		cq.Sort = sort
+	}
+	return cq, nil
+}
+
+type SearchQuery struct {
+	Query  string  `json:"q" validate:"required,max=200"`
+	Type   string  `json:"type" validate:"oneof=posts users comments"`
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (sq SearchQuery) Parse(r *http.Request) (SearchQuery, error) {
+	query := r.URL.Query()
+	sq.Query = strings.TrimSpace(query.Get("q"))
+	searchType := query.Get("type")
+	if searchType != "" {
+		sq.Type = searchType
+	}
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return sq, err
+		}
+		sq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return sq, err
+	}
+	sq.Cursor = cursor
+	return sq, nil
+}
+
+type ExploreQuery struct {
+	Scope  string  `json:"scope" validate:"oneof=popular local"`
+	Limit  int     `json:"limit" validate:"gte=1,lte=20"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (eq ExploreQuery) Parse(r *http.Request) (ExploreQuery, error) {
+	query := r.URL.Query()
+	scope := query.Get("scope")
+	if scope != "" {
+		eq.Scope = scope
+	}
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return eq, err
+		}
+		eq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return eq, err
+	}
+	eq.Cursor = cursor
+	return eq, nil
+}
+
+type RemotePostsQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (rq RemotePostsQuery) Parse(r *http.Request) (RemotePostsQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return rq, err
+		}
+		rq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return rq, err
+	}
+	rq.Cursor = cursor
+	return rq, nil
+}
+
+type NotificationQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Unread bool    `json:"unread"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (nq NotificationQuery) Parse(r *http.Request) (NotificationQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return nq, err
+		}
+		nq.Limit = l
+	}
+	unread := query.Get("unread")
+	if unread != "" {
+		u, err := strconv.ParseBool(unread)
+		if err != nil {
+			return nq, err
+		}
+		nq.Unread = u
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return nq, err
+	}
+	nq.Cursor = cursor
+	return nq, nil
+}
+
+// ConversationQuery pages both the conversations of a user and their messages.
+type ConversationQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (cq ConversationQuery) Parse(r *http.Request) (ConversationQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return cq, err
+		}
+		cq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return cq, err
+	}
+	cq.Cursor = cursor
+	return cq, nil
+}
+
+type CommunityQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+	Search string  `json:"search" validate:"max=100"`
+	Joined bool    `json:"joined"`
+}
+
+func (cq CommunityQuery) Parse(r *http.Request) (CommunityQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return cq, err
+		}
+		cq.Limit = l
+	}
+	cq.Search = query.Get("search")
+	joined := query.Get("joined")
+	if joined != "" {
+		j, err := strconv.ParseBool(joined)
+		if err != nil {
+			return cq, err
+		}
+		cq.Joined = j
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return cq, err
+	}
+	cq.Cursor = cursor
+	return cq, nil
+}
+
+type CommunityMemberQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+	Status string  `json:"status" validate:"oneof=active pending invited"`
+}
+
+func (mq CommunityMemberQuery) Parse(r *http.Request) (CommunityMemberQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return mq, err
+		}
+		mq.Limit = l
+	}
+	status := query.Get("status")
+	if status != "" {
+		mq.Status = status
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return mq, err
+	}
+	mq.Cursor = cursor
+	return mq, nil
+}
+
+type FollowQuery struct {
+	Limit  int     `json:"limit" validate:"gte=1,lte=50"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+func (fq FollowQuery) Parse(r *http.Request) (FollowQuery, error) {
+	query := r.URL.Query()
+	limit := query.Get("limit")
+	if limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return fq, err
+		}
+		fq.Limit = l
+	}
+	cursor, err := parseCursor(query)
+	if err != nil {
+		return fq, err
+	}
+	fq.Cursor = cursor
 	return fq, nil
 }
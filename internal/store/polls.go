@@ -0,0 +1,248 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPollClosed        = errors.New("Poll is closed")
+	ErrAlreadyVoted      = errors.New("Already voted in this poll")
+	ErrInvalidPollOption = errors.New("Invalid poll option")
+)
+
+type Poll struct {
+	ID             int64        `json:"id"`
+	PostID         int64        `json:"post_id"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	HideResults    bool         `json:"hide_results"`
+	ClosesAt       time.Time    `json:"closes_at"`
+	Closed         bool         `json:"closed"`
+	TotalVoters    *int64       `json:"total_voters,omitempty"`
+	Options        []PollOption `json:"options"`
+	OwnVotes       []int64      `json:"own_votes"`
+}
+
+type PollOption struct {
+	ID       int64  `json:"id"`
+	Position int    `json:"position"`
+	Text     string `json:"text"`
+	Votes    *int64 `json:"votes,omitempty"`
+}
+
+type PollStore struct {
+	db *sql.DB
+}
+
+func createPoll(ctx context.Context, tx *sql.Tx, poll *Poll) error {
+	query := `
+		INSERT INTO polls (post_id, multiple_choice, hide_results, closes_at) VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(ctx, query, poll.PostID, poll.MultipleChoice, poll.HideResults, poll.ClosesAt).Scan(&poll.ID)
+	if err != nil {
+		return err
+	}
+	for i := range poll.Options {
+		option := &poll.Options[i]
+		option.Position = i
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO poll_options (poll_id, position, text) VALUES ($1, $2, $3) RETURNING id`,
+			poll.ID,
+			option.Position,
+			option.Text,
+		).Scan(&option.ID)
+		if err != nil {
+			return err
+		}
+	}
+	poll.OwnVotes = []int64{}
+	return nil
+}
+
+// GetByPostIDs returns the polls attached to postIDs keyed by post ID, with results
+// aggregated for viewerID. Vote counts stay hidden until close when the poll asks for it.
+func (store *PollStore) GetByPostIDs(ctx context.Context, postIDs []int64, viewerID int64) (map[int64]*Poll, error) {
+	polls := map[int64]*Poll{}
+	if len(postIDs) == 0 {
+		return polls, nil
+	}
+	query := `
+		SELECT p.id, p.post_id, p.multiple_choice, p.hide_results, p.closes_at, p.closes_at <= NOW(),
+			(SELECT COUNT(DISTINCT v.user_id) FROM poll_votes v WHERE v.poll_id = p.id)
+		FROM polls p
+		WHERE p.post_id = ANY($1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := map[int64]*Poll{}
+	pollIDs := []int64{}
+	for rows.Next() {
+		var (
+			poll        Poll
+			totalVoters int64
+		)
+		if err := rows.Scan(&poll.ID, &poll.PostID, &poll.MultipleChoice, &poll.HideResults, &poll.ClosesAt, &poll.Closed, &totalVoters); err != nil {
+			return nil, err
+		}
+		if poll.resultsVisible() {
+			poll.TotalVoters = &totalVoters
+		}
+		poll.Options = []PollOption{}
+		poll.OwnVotes = []int64{}
+		polls[poll.PostID] = &poll
+		byID[poll.ID] = &poll
+		pollIDs = append(pollIDs, poll.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(pollIDs) == 0 {
+		return polls, nil
+	}
+
+	query = `
+		SELECT o.poll_id, o.id, o.position, o.text, COUNT(v.user_id), COALESCE(BOOL_OR(v.user_id = $2), FALSE)
+		FROM poll_options o
+		LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.poll_id = ANY($1)
+		GROUP BY o.id
+		ORDER BY o.poll_id, o.position
+	`
+	optionRows, err := store.db.QueryContext(ctx, query, pq.Array(pollIDs), viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+	for optionRows.Next() {
+		var (
+			pollID int64
+			option PollOption
+			votes  int64
+			voted  bool
+		)
+		if err := optionRows.Scan(&pollID, &option.ID, &option.Position, &option.Text, &votes, &voted); err != nil {
+			return nil, err
+		}
+		poll := byID[pollID]
+		if poll.resultsVisible() {
+			option.Votes = &votes
+		}
+		if voted {
+			poll.OwnVotes = append(poll.OwnVotes, option.ID)
+		}
+		poll.Options = append(poll.Options, option)
+	}
+	return polls, optionRows.Err()
+}
+
+// Vote records userID's choice in the poll of postID. Single choice polls accept
+// exactly one option and every poll accepts a single ballot per user, so
+// changing a vote goes through Unvote.
+func (store *PollStore) Vote(ctx context.Context, postID int64, userID int64, optionIDs []int64) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		var (
+			pollID         int64
+			multipleChoice bool
+			closed         bool
+		)
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT id, multiple_choice, closes_at <= NOW() FROM polls WHERE post_id = $1 FOR SHARE`,
+			postID,
+		).Scan(&pollID, &multipleChoice, &closed)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrorNotFound
+			default:
+				return err
+			}
+		}
+		if closed {
+			return ErrPollClosed
+		}
+		if !multipleChoice && len(optionIDs) != 1 {
+			return ErrInvalidPollOption
+		}
+
+		// The ballot makes a second vote fail even when it selects other options.
+		if _, err := tx.ExecContext(ctx, `INSERT INTO poll_ballots (poll_id, user_id) VALUES ($1, $2)`, pollID, userID); err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrAlreadyVoted
+			}
+			return err
+		}
+		query := `
+			INSERT INTO poll_votes (poll_id, option_id, user_id, single_choice)
+			SELECT o.poll_id, o.id, $3, $4 FROM poll_options o
+			WHERE o.poll_id = $1 AND o.id = ANY($2)
+		`
+		result, err := tx.ExecContext(ctx, query, pollID, pq.Array(optionIDs), userID, !multipleChoice)
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				return ErrAlreadyVoted
+			}
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != int64(len(optionIDs)) {
+			return ErrInvalidPollOption
+		}
+		return nil
+	})
+}
+
+// Unvote removes every vote userID cast in the poll of postID while it is open.
+func (store *PollStore) Unvote(ctx context.Context, postID int64, userID int64) error {
+	query := `
+		WITH ballot AS (
+			DELETE FROM poll_ballots b USING polls p
+			WHERE b.poll_id = p.id AND p.post_id = $1 AND b.user_id = $2 AND p.closes_at > NOW()
+			RETURNING b.poll_id
+		)
+		DELETE FROM poll_votes v USING ballot
+		WHERE v.poll_id = ballot.poll_id AND v.user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, postID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+func (p *Poll) resultsVisible() bool {
+	return !p.HideResults || p.Closed
+}
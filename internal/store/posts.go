@@ -1,6 +1,7 @@
 package store
 
 import (
+	"AwesomeProject/internal/markdown"
 	"context"
 	"database/sql"
 	"errors"
@@ -10,47 +11,108 @@ import (
 )
 
 var (
-	ErrorNotFound        = errors.New("Resource not found")
-	QueryTimeOutDuration = time.Second * 5
+	ErrorNotFound            = errors.New("Resource not found")
+	ErrCommentsLocked        = errors.New("Comments are locked on this post")
+	ErrCommentsLockUnchanged = errors.New("Comments are already in the requested lock state")
+	QueryTimeOutDuration     = time.Second * 5
 )
 
 type Post struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Title     string    `json:"title"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at"`
-	Comments  []Comment `json:"comments"`
-	Version   int64     `json:"version"`
-	User      User      `json:"user"`
+	ID          int64         `json:"id"`
+	Content     string        `json:"content"`
+	ContentHTML string        `json:"content_html"`
+	Title       string        `json:"title"`
+	UserID      int64         `json:"user_id"`
+	Tags        []string      `json:"tags"`
+	Visibility  string        `json:"visibility"`
+	CreatedAt   string        `json:"created_at"`
+	UpdatedAt   string        `json:"updated_at"`
+	Comments    []Comment     `json:"comments"`
+	Version     int64         `json:"version"`
+	User        User          `json:"user"`
+	Poll        *Poll         `json:"poll,omitempty"`
+	Previews    []LinkPreview `json:"link_previews,omitempty"`
+	Locked      bool          `json:"comments_locked"`
+	Lock        *CommentsLock `json:"comments_lock,omitempty"`
+	CommunityID *int64        `json:"community_id"`
+}
+
+type CommentsLock struct {
+	LockedAt string `json:"locked_at"`
+	LockedBy *int64 `json:"locked_by"`
+	Reason   string `json:"reason"`
 }
 
 type PostWithMetadata struct {
 	Post
-	CommentsCount int `json:"comments_count"`
+	CommentsCount int           `json:"comments_count"`
+	Score         *float64      `json:"score,omitempty"`
+	Remote        *RemoteOrigin `json:"remote,omitempty"`
+}
+
+// RemoteOrigin is where a post listed from another server was published.
+// Such posts carry the ID of the remote post, not of a local one.
+type RemoteOrigin struct {
+	ActorURI string `json:"actor_uri"`
+	URI      string `json:"uri"`
+	URL      string `json:"url"`
 }
 
 type PostStore struct {
-	db *sql.DB
+	db    *sql.DB
+	hooks *Hooks
 }
 
 func (store *PostStore) Create(ctx context.Context, post *Post) error {
+	if post.Visibility == "" {
+		post.Visibility = VisibilityPublic
+	}
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		if err := store.create(ctx, tx, post); err != nil {
+			return err
+		}
+		if post.Poll != nil {
+			post.Poll.PostID = post.ID
+			if err := createPoll(ctx, tx, post.Poll); err != nil {
+				return err
+			}
+		}
+		if err := replacePostLinks(ctx, tx, post); err != nil {
+			return err
+		}
+		return replacePostMentions(ctx, tx, post)
+	})
+	if err != nil {
+		return err
+	}
+	store.hooks.postSaved(ctx, post)
+	return nil
+}
+
+func (store *PostStore) create(ctx context.Context, tx *sql.Tx, post *Post) error {
 	query := `
-		INSERT INTO posts (content, title, user_id, tags) VALUES ($1, $2, $3, $4) 
+		INSERT INTO posts (content, content_html, title, user_id, tags, visibility, community_id) VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at, updated_at
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	err := store.db.QueryRowContext(
+	html, err := markdown.Render(post.Content)
+	if err != nil {
+		return err
+	}
+	post.ContentHTML = html
+
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		post.Content,
+		post.ContentHTML,
 		post.Title,
 		post.UserID,
 		pq.Array(post.Tags),
+		post.Visibility,
+		post.CommunityID,
 	).Scan(
 		&post.ID,
 		&post.CreatedAt,
@@ -63,14 +125,21 @@ func (store *PostStore) Create(ctx context.Context, post *Post) error {
 	return nil
 }
 
-func (store *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
+// GetByID returns the post only when viewerID is allowed to see it,
+// otherwise ErrorNotFound so hidden posts are indistinguishable from missing ones.
+func (store *PostStore) GetByID(ctx context.Context, id int64, viewerID int64) (*Post, error) {
 	query := `
-		SELECT id, content, title, user_id, tags, created_at, updated_at, version from posts where id = $1
-    `
+		SELECT p.id, p.content, p.content_html, p.title, p.user_id, p.tags, p.visibility, p.created_at, p.updated_at, p.version,
+			p.comments_locked_at, p.comments_locked_by, p.comments_lock_reason, p.community_id
+		FROM posts p
+		WHERE p.id = $1 AND p.deleted_at IS NULL AND ` + postVisibleTo("p", "$2")
 	var (
-		post      Post
-		createdAt time.Time
-		updatedAt time.Time
+		post       Post
+		cachedHTML sql.NullString
+		createdAt  time.Time
+		updatedAt  time.Time
+		lock       CommentsLock
+		lockedAt   sql.NullTime
 	)
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
@@ -79,15 +148,22 @@ func (store *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 		ctx,
 		query,
 		id,
+		viewerID,
 	).Scan(
 		&post.ID,
 		&post.Content,
+		&cachedHTML,
 		&post.Title,
 		&post.UserID,
 		pq.Array(&post.Tags),
+		&post.Visibility,
 		&createdAt,
 		&updatedAt,
 		&post.Version,
+		&lockedAt,
+		&lock.LockedBy,
+		&lock.Reason,
+		&post.CommunityID,
 	)
 	if err != nil {
 		switch {
@@ -97,21 +173,50 @@ func (store *PostStore) GetByID(ctx context.Context, id int64) (*Post, error) {
 			return nil, err
 		}
 	}
+	if lockedAt.Valid {
+		lock.LockedAt = lockedAt.Time.Format(time.RFC3339)
+		post.Locked = true
+		post.Lock = &lock
+	}
+	post.ContentHTML = contentHTML(post.Content, cachedHTML)
 	post.CreatedAt = createdAt.Format(time.RFC3339)
 	post.UpdatedAt = updatedAt.Format(time.RFC3339)
 	return &post, nil
 }
 
 func (store *PostStore) Update(ctx context.Context, post *Post) error {
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		if err := store.update(ctx, tx, post); err != nil {
+			return err
+		}
+		if err := replacePostLinks(ctx, tx, post); err != nil {
+			return err
+		}
+		return replacePostMentions(ctx, tx, post)
+	})
+	if err != nil {
+		return err
+	}
+	store.hooks.postSaved(ctx, post)
+	return nil
+}
+
+func (store *PostStore) update(ctx context.Context, tx *sql.Tx, post *Post) error {
 	query := `
-		UPDATE posts SET title = $2, content = $3, tags = $4, version = version + 1, updated_at = NOW()
+		UPDATE posts SET title = $2, content = $3, content_html = $7, tags = $4, visibility = $6, version = version + 1, updated_at = NOW()
 		WHERE id = $1 AND version = $5
-		RETURNING version
+		RETURNING version, updated_at
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	err := store.db.QueryRowContext(ctx, query, post.ID, post.Title, post.Content, pq.Array(post.Tags), post.Version).Scan(&post.Version)
+	html, err := markdown.Render(post.Content)
+	if err != nil {
+		return err
+	}
+	post.ContentHTML = html
+
+	err = tx.QueryRowContext(ctx, query, post.ID, post.Title, post.Content, pq.Array(post.Tags), post.Version, post.Visibility, post.ContentHTML).Scan(&post.Version, &post.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -123,9 +228,18 @@ func (store *PostStore) Update(ctx context.Context, post *Post) error {
 	return nil
 }
 
+// Delete soft deletes the post. It can be restored until Purge removes it.
 func (store *PostStore) Delete(ctx context.Context, id int64) error {
+	if err := store.delete(ctx, id); err != nil {
+		return err
+	}
+	store.hooks.postDeleted(ctx, id, true)
+	return nil
+}
+
+func (store *PostStore) delete(ctx context.Context, id int64) error {
 	query := `
-		DELETE FROM posts WHERE id = $1
+		UPDATE posts SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
@@ -144,52 +258,185 @@ func (store *PostStore) Delete(ctx context.Context, id int64) error {
 	return err
 }
 
-func (store *PostStore) GetUserFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, error) {
+// LockComments stops new comments on the post and records who locked it and why.
+func (store *PostStore) LockComments(ctx context.Context, postID int64, actorID int64, reason string) error {
+	query := `
+		UPDATE posts SET comments_locked_at = NOW(), comments_locked_by = $2, comments_lock_reason = $3
+		WHERE id = $1 AND deleted_at IS NULL AND comments_locked_at IS NULL
+	`
+	return store.setCommentsLock(ctx, "lock", postID, actorID, reason, query, postID, actorID, reason)
+}
+
+// UnlockComments allows comments on the post again. Unlocks are audited like locks.
+func (store *PostStore) UnlockComments(ctx context.Context, postID int64, actorID int64, reason string) error {
+	query := `
+		UPDATE posts SET comments_locked_at = NULL, comments_locked_by = NULL, comments_lock_reason = ''
+		WHERE id = $1 AND deleted_at IS NULL AND comments_locked_at IS NOT NULL
+	`
+	return store.setCommentsLock(ctx, "unlock", postID, actorID, reason, query, postID)
+}
+
+// setCommentsLock runs the lock state change query with args and audits it in the same transaction.
+func (store *PostStore) setCommentsLock(ctx context.Context, action string, postID int64, actorID int64, reason string, query string, args ...any) error {
+	return withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrCommentsLockUnchanged
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO post_lock_events (post_id, actor_id, action, reason) VALUES ($1, $2, $3, $4)`,
+			postID,
+			actorID,
+			action,
+			reason,
+		)
+		return err
+	})
+}
+
+// GetDeletedByID returns a post that was soft deleted after deletedAfter.
+func (store *PostStore) GetDeletedByID(ctx context.Context, id int64, deletedAfter time.Time) (*Post, error) {
+	query := `
+		SELECT id, content, content_html, title, user_id, tags, visibility, created_at, updated_at, version, community_id
+		FROM posts
+		WHERE id = $1 AND deleted_at > $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var (
+		post       Post
+		cachedHTML sql.NullString
+	)
+	err := store.db.QueryRowContext(ctx, query, id, deletedAfter).Scan(
+		&post.ID,
+		&post.Content,
+		&cachedHTML,
+		&post.Title,
+		&post.UserID,
+		pq.Array(&post.Tags),
+		&post.Visibility,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+		&post.Version,
+		&post.CommunityID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	post.ContentHTML = contentHTML(post.Content, cachedHTML)
+	return &post, nil
+}
+
+// Restore undoes a soft delete made after deletedAfter.
+func (store *PostStore) Restore(ctx context.Context, id int64, deletedAfter time.Time) error {
+	if err := store.restore(ctx, id, deletedAfter); err != nil {
+		return err
+	}
+	store.hooks.postDeleted(ctx, id, false)
+	return nil
+}
+
+func (store *PostStore) restore(ctx context.Context, id int64, deletedAfter time.Time) error {
+	query := `
+		UPDATE posts SET deleted_at = NULL WHERE id = $1 AND deleted_at > $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, id, deletedAfter)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// Purge hard deletes posts soft deleted before deletedBefore.
+// Everything hanging off a post is removed by ON DELETE CASCADE.
+func (store *PostStore) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM posts WHERE deleted_at < $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPublicByUser returns the newest limit public posts of userID, none when
+// they have a private account, and the last time any of their posts changed,
+// deletions included.
+func (store *PostStore) GetPublicByUser(ctx context.Context, userID int64, limit int) ([]Post, time.Time, error) {
 	query := `
-		SELECT
-			posts.id,
-			posts.user_id,
-			posts.title,
-			posts.content,
-			posts.created_at,
-			posts.tags,
-			users.username,
-			COUNT(comments.id) AS comments_count from posts
-		LEFT JOIN comments ON posts.id = comments.post_id
-		LEFT JOIN users ON posts.user_id = users.id
-    	JOIN followers ON posts.user_id = followers.follower_id OR posts.user_id = $1
-    	WHERE 
-    	    followers.user_id = $1 AND
-    		(posts.title ILIKE '%' || $4 || '%' OR posts.content ILIKE '%' || $4 || '%') AND
-    		(posts.tags @> $5 OR $5 = '{}')
-    	GROUP BY posts.id, users.username
-    	ORDER BY posts.created_at ` + feedQuery.Sort + `
-    	LIMIT $2 OFFSET $3
+		SELECT id, title, content, content_html, tags, created_at, updated_at
+		FROM posts
+		WHERE user_id = $1 AND visibility = 'public' AND deleted_at IS NULL AND
+			NOT EXISTS (SELECT 1 FROM users WHERE id = $1 AND is_private)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
-	rows, err := store.db.QueryContext(ctx, query, userId, feedQuery.Limit, feedQuery.Offset, feedQuery.Search, pq.Array(feedQuery.Tags))
+
+	var lastModified sql.NullTime
+	err := store.db.QueryRowContext(
+		ctx,
+		`SELECT GREATEST(MAX(updated_at), MAX(deleted_at)) FROM posts WHERE user_id = $1`,
+		userID,
+	).Scan(&lastModified)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	rows, err := store.db.QueryContext(ctx, query, userID, limit)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 	defer rows.Close()
-	var postsWithMetadata []PostWithMetadata
+	posts := []Post{}
 	for rows.Next() {
-		var p PostWithMetadata
-		err = rows.Scan(
-			&p.ID,
-			&p.UserID,
-			&p.Title,
-			&p.Content,
-			&p.CreatedAt,
-			pq.Array(&p.Tags),
-			&p.User.Username,
-			&p.CommentsCount,
+		var (
+			post       Post
+			cachedHTML sql.NullString
+			createdAt  time.Time
+			updatedAt  time.Time
 		)
-		if err != nil {
-			return nil, err
+		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &cachedHTML, pq.Array(&post.Tags), &createdAt, &updatedAt); err != nil {
+			return nil, time.Time{}, err
 		}
-		postsWithMetadata = append(postsWithMetadata, p)
+		post.UserID = userID
+		post.Visibility = VisibilityPublic
+		post.ContentHTML = contentHTML(post.Content, cachedHTML)
+		post.CreatedAt = createdAt.Format(time.RFC3339)
+		post.UpdatedAt = updatedAt.Format(time.RFC3339)
+		posts = append(posts, post)
 	}
-	return postsWithMetadata, nil
+	return posts, lastModified.Time, rows.Err()
 }
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+)
+
+type Reaction struct {
+	PostID    int64  `json:"post_id"`
+	UserID    int64  `json:"user_id"`
+	Kind      string `json:"kind"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ReactionStore struct {
+	db    *sql.DB
+	hooks *Hooks
+}
+
+// React sets the reaction of reaction.UserID to reaction.PostID, replacing the
+// kind of an earlier one. It returns ErrorNotFound when the post is gone and
+// ErrBlocked when its author and the user blocked each other.
+func (store *ReactionStore) React(ctx context.Context, reaction *Reaction) error {
+	inserted, err := store.react(ctx, reaction)
+	if err != nil {
+		return err
+	}
+	if inserted {
+		store.hooks.reacted(ctx, reaction)
+	}
+	return nil
+}
+
+func (store *ReactionStore) react(ctx context.Context, reaction *Reaction) (bool, error) {
+	query := `
+		INSERT INTO post_reactions (post_id, user_id, kind)
+		SELECT p.id, $2, $3 FROM posts p
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+		ON CONFLICT (post_id, user_id) DO UPDATE SET kind = EXCLUDED.kind
+		RETURNING created_at, xmax = 0
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var blocked bool
+	err := store.db.QueryRowContext(
+		ctx,
+		`SELECT NOT `+notBlocked("p.user_id", "$2")+` FROM posts p WHERE p.id = $1 AND p.deleted_at IS NULL`,
+		reaction.PostID,
+		reaction.UserID,
+	).Scan(&blocked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrorNotFound
+		default:
+			return false, err
+		}
+	}
+	if blocked {
+		return false, ErrBlocked
+	}
+
+	var inserted bool
+	err = store.db.QueryRowContext(ctx, query, reaction.PostID, reaction.UserID, reaction.Kind).Scan(&reaction.CreatedAt, &inserted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrorNotFound
+		default:
+			return false, err
+		}
+	}
+	return inserted, nil
+}
+
+// Unreact removes the reaction of userID to postID.
+func (store *ReactionStore) Unreact(ctx context.Context, postID int64, userID int64) error {
+	query := `DELETE FROM post_reactions WHERE post_id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	result, err := store.db.ExecContext(ctx, query, postID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// GetCounts returns how many reactions of each kind postID has.
+func (store *ReactionStore) GetCounts(ctx context.Context, postID int64) (map[string]int64, error) {
+	query := `SELECT kind, COUNT(*) FROM post_reactions WHERE post_id = $1 GROUP BY kind`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, query, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int64{}
+	for rows.Next() {
+		var (
+			kind  string
+			count int64
+		)
+		if err := rows.Scan(&kind, &count); err != nil {
+			return nil, err
+		}
+		counts[kind] = count
+	}
+	return counts, rows.Err()
+}
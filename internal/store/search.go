@@ -0,0 +1,361 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SearchTypePosts    = "posts"
+	SearchTypeUsers    = "users"
+	SearchTypeComments = "comments"
+)
+
+var ErrEmptySearchQuery = errors.New("Search query has no terms")
+
+// ts_headline does not escape the text it highlights, so matches are delimited
+// with control characters and turned into <mark> after escaping.
+const (
+	headlineStart   = "\x02"
+	headlineStop    = "\x03"
+	headlineOptions = "StartSel=" + headlineStart + ", StopSel=" + headlineStop + ", MaxFragments=2, MaxWords=30, MinWords=10"
+)
+
+type PostSearchResult struct {
+	Post
+	Headline string  `json:"headline"`
+	Rank     float64 `json:"rank"`
+}
+
+type UserSearchResult struct {
+	ID       int64   `json:"id"`
+	Username string  `json:"username"`
+	Headline string  `json:"headline"`
+	Rank     float64 `json:"rank"`
+}
+
+type CommentSearchResult struct {
+	Comment
+	Headline string  `json:"headline"`
+	Rank     float64 `json:"rank"`
+}
+
+type SearchStore struct {
+	db *sql.DB
+}
+
+// SearchPosts ranks the posts viewerID may see against the query, title matches
+// above content matches.
+func (store *SearchStore) SearchPosts(ctx context.Context, viewerID int64, searchQuery SearchQuery) ([]PostSearchResult, PageInfo, error) {
+	tsQuery, err := buildTSQuery(searchQuery.Query)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	condition, orderBy, cursorArgs := scoreKeyset(searchQuery.Cursor, "rank", "id", 4)
+	query := `
+		WITH matches AS (
+			SELECT
+				posts.id,
+				posts.user_id,
+				posts.title,
+				posts.content,
+				posts.content_html,
+				posts.created_at,
+				posts.tags,
+				posts.visibility,
+				users.username,
+				ts_headline('english', posts.title || E'\n' || posts.content, q, '` + headlineOptions + `') AS headline,
+				ts_rank_cd(posts.search_vector, q)::float8 AS rank
+			FROM posts
+			JOIN users ON posts.user_id = users.id,
+			to_tsquery('english', $1) q
+			WHERE
+				posts.search_vector @@ q AND
+				posts.deleted_at IS NULL AND
+				` + postVisibleTo("posts", "$2") + `
+		)
+		SELECT * FROM matches
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append([]any{tsQuery, viewerID, searchQuery.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	results := []PostSearchResult{}
+	for rows.Next() {
+		var (
+			result     PostSearchResult
+			cachedHTML sql.NullString
+			createdAt  time.Time
+		)
+		err := rows.Scan(
+			&result.ID,
+			&result.UserID,
+			&result.Title,
+			&result.Content,
+			&cachedHTML,
+			&createdAt,
+			pq.Array(&result.Tags),
+			&result.Visibility,
+			&result.User.Username,
+			&result.Headline,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		result.ContentHTML = contentHTML(result.Content, cachedHTML)
+		result.CreatedAt = createdAt.Format(time.RFC3339)
+		result.User.ID = result.UserID
+		result.Headline = highlight(result.Headline)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	results, page := paginate(results, searchQuery.Limit, searchQuery.Cursor, func(r PostSearchResult) Cursor {
+		return Cursor{ID: r.ID, Score: r.Rank}
+	})
+	return results, page, nil
+}
+
+// SearchUsers ranks active users by username, leaving out those on either side
+// of a block with viewerID.
+func (store *SearchStore) SearchUsers(ctx context.Context, viewerID int64, searchQuery SearchQuery) ([]UserSearchResult, PageInfo, error) {
+	tsQuery, err := buildTSQuery(searchQuery.Query)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	condition, orderBy, cursorArgs := scoreKeyset(searchQuery.Cursor, "rank", "id", 4)
+	query := `
+		WITH matches AS (
+			SELECT
+				users.id,
+				users.username,
+				ts_headline('simple', users.username, q, '` + headlineOptions + `') AS headline,
+				ts_rank_cd(users.search_vector, q)::float8 AS rank
+			FROM users, to_tsquery('simple', $1) q
+			WHERE users.search_vector @@ q AND users.is_activated AND ` + notBlocked("users.id", "$3") + `
+		)
+		SELECT * FROM matches
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append([]any{tsQuery, searchQuery.Limit + 1, viewerID}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	results := []UserSearchResult{}
+	for rows.Next() {
+		var result UserSearchResult
+		if err := rows.Scan(&result.ID, &result.Username, &result.Headline, &result.Rank); err != nil {
+			return nil, PageInfo{}, err
+		}
+		result.Headline = highlight(result.Headline)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	results, page := paginate(results, searchQuery.Limit, searchQuery.Cursor, func(r UserSearchResult) Cursor {
+		return Cursor{ID: r.ID, Score: r.Rank}
+	})
+	return results, page, nil
+}
+
+// SearchComments ranks the comments left on posts viewerID may see.
+func (store *SearchStore) SearchComments(ctx context.Context, viewerID int64, searchQuery SearchQuery) ([]CommentSearchResult, PageInfo, error) {
+	tsQuery, err := buildTSQuery(searchQuery.Query)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	condition, orderBy, cursorArgs := scoreKeyset(searchQuery.Cursor, "rank", "id", 4)
+	query := `
+		WITH matches AS (
+			SELECT
+				c.id,
+				c.post_id,
+				c.user_id,
+				c.parent_id,
+				c.depth,
+				c.content,
+				c.content_html,
+				c.created_at,
+				c.edited_at,
+				users.username,
+				ts_headline('english', c.content, q, '` + headlineOptions + `') AS headline,
+				ts_rank_cd(c.search_vector, q)::float8 AS rank
+			FROM comments c
+			JOIN posts ON posts.id = c.post_id
+			JOIN users ON users.id = c.user_id,
+			to_tsquery('english', $1) q
+			WHERE
+				c.search_vector @@ q AND
+				c.deleted_at IS NULL AND
+				posts.deleted_at IS NULL AND
+				` + notBlocked("c.user_id", "$2") + ` AND
+				` + postVisibleTo("posts", "$2") + `
+		)
+		SELECT * FROM matches
+		WHERE ` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+	args := append([]any{tsQuery, viewerID, searchQuery.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	results := []CommentSearchResult{}
+	for rows.Next() {
+		var (
+			result     CommentSearchResult
+			cachedHTML sql.NullString
+			createdAt  time.Time
+			editedAt   sql.NullTime
+		)
+		err := rows.Scan(
+			&result.ID,
+			&result.PostID,
+			&result.UserID,
+			&result.ParentID,
+			&result.Depth,
+			&result.Content,
+			&cachedHTML,
+			&createdAt,
+			&editedAt,
+			&result.User.Username,
+			&result.Headline,
+			&result.Rank,
+		)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		result.ContentHTML = contentHTML(result.Content, cachedHTML)
+		result.CreatedAt = createdAt.Format(time.RFC3339)
+		result.EditedAt = formatNullTime(editedAt)
+		result.User.ID = result.UserID
+		result.Headline = highlight(result.Headline)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	results, page := paginate(results, searchQuery.Limit, searchQuery.Cursor, func(r CommentSearchResult) Cursor {
+		return Cursor{ID: r.ID, Score: r.Rank}
+	})
+	return results, page, nil
+}
+
+// buildTSQuery translates a search box query into to_tsquery syntax. Terms are
+// ANDed, "quoted words" match as a phrase, a trailing * matches a prefix, a
+// leading - excludes a term and OR between terms matches either of them.
+// Every lexeme is quoted, so user input can never inject tsquery operators.
+func buildTSQuery(q string) (string, error) {
+	var (
+		parts []string
+		or    bool
+	)
+	add := func(part string) {
+		if len(parts) > 0 {
+			if or {
+				parts = append(parts, "|")
+			} else {
+				parts = append(parts, "&")
+			}
+		}
+		parts = append(parts, part)
+		or = false
+	}
+	for len(q) > 0 {
+		q = strings.TrimLeft(q, " \t\r\n")
+		if q == "" {
+			break
+		}
+		negate := false
+		if q[0] == '-' {
+			negate = true
+			q = q[1:]
+		}
+		var term string
+		if strings.HasPrefix(q, `"`) {
+			end := strings.Index(q[1:], `"`)
+			var phrase string
+			if end < 0 {
+				phrase, q = q[1:], ""
+			} else {
+				phrase, q = q[1:end+1], q[end+2:]
+			}
+			words := strings.Fields(phrase)
+			if len(words) == 0 {
+				continue
+			}
+			for i, word := range words {
+				words[i] = quoteLexeme(word)
+			}
+			term = "(" + strings.Join(words, " <-> ") + ")"
+		} else {
+			end := strings.IndexAny(q, " \t\r\n")
+			if end < 0 {
+				end = len(q)
+			}
+			word := q[:end]
+			q = q[end:]
+			if word == "OR" && !negate {
+				if len(parts) > 0 {
+					or = true
+				}
+				continue
+			}
+			prefix := strings.HasSuffix(word, "*")
+			word = strings.TrimRight(word, "*")
+			if word == "" {
+				continue
+			}
+			term = quoteLexeme(word)
+			if prefix {
+				term += ":*"
+			}
+		}
+		if negate {
+			term = "!" + term
+		}
+		add(term)
+	}
+	if len(parts) == 0 {
+		return "", ErrEmptySearchQuery
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func quoteLexeme(word string) string {
+	word = strings.ReplaceAll(word, `\`, `\\`)
+	return "'" + strings.ReplaceAll(word, "'", "''") + "'"
+}
+
+// highlight escapes a ts_headline result and marks its matches with <mark>.
+func highlight(headline string) string {
+	headline = html.EscapeString(strings.ReplaceAll(headline, "\n", " "))
+	headline = strings.ReplaceAll(headline, headlineStart, "<mark>")
+	return strings.ReplaceAll(headline, headlineStop, "</mark>")
+}
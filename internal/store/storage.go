@@ -9,10 +9,21 @@ import (
 type Storage struct {
 	Posts interface {
 		Create(ctx context.Context, post *Post) error
-		GetByID(ctx context.Context, id int64) (*Post, error)
+		GetByID(ctx context.Context, id int64, viewerID int64) (*Post, error)
 		Update(ctx context.Context, post *Post) error
 		Delete(ctx context.Context, id int64) error
-		GetUserFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, error)
+		GetDeletedByID(ctx context.Context, id int64, deletedAfter time.Time) (*Post, error)
+		Restore(ctx context.Context, id int64, deletedAfter time.Time) error
+		Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+		LockComments(ctx context.Context, postID int64, actorID int64, reason string) error
+		UnlockComments(ctx context.Context, postID int64, actorID int64, reason string) error
+		GetUserFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error)
+		GetPublicByUser(ctx context.Context, userID int64, limit int) ([]Post, time.Time, error)
+		GetRankedFeed(ctx context.Context, userId int64, feedQuery PaginatedFeedQuery, ranking FeedRanking) ([]PostWithMetadata, PageInfo, error)
+		GetTimelineFeed(ctx context.Context, userId int64, postIDs []int64, authorIDs []int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error)
+		GetTimelineEntries(ctx context.Context, userId int64, excludedAuthorIDs []int64, limit int) ([]TimelineEntry, error)
+		GetAuthorTimelineEntries(ctx context.Context, authorID int64, limit int) ([]TimelineEntry, error)
+		GetCommunityFeed(ctx context.Context, userId int64, communityID int64, feedQuery PaginatedFeedQuery) ([]PostWithMetadata, PageInfo, error)
 	}
 	Users interface {
 		Create(ctx context.Context, tx *sql.Tx, user *User) error
@@ -21,27 +32,173 @@ type Storage struct {
 		Activate(ctx context.Context, token string) error
 		Delete(ctx context.Context, id int64) error
 		GetByEmail(ctx context.Context, email string) (*User, error)
+		GetByUsername(ctx context.Context, username string) (*User, error)
+		GetSettings(ctx context.Context, userID int64) (*UserSettings, error)
+		UpdateSettings(ctx context.Context, userID int64, settings *UserSettings) error
 	}
 	Comments interface {
 		CreateComments(ctx context.Context, comment *Comment) error
-		GetByPostID(ctx context.Context, postID int64) ([]Comment, error)
+		GetReplies(ctx context.Context, postID int64, parentID *int64, viewerID int64, query PaginatedCommentsQuery) ([]Comment, PageInfo, error)
+		GetByID(ctx context.Context, postID int64, id int64) (*Comment, error)
+		Update(ctx context.Context, comment *Comment) error
+		Delete(ctx context.Context, id int64) error
+		GetDeletedByID(ctx context.Context, postID int64, id int64, deletedAfter time.Time) (*Comment, error)
+		Restore(ctx context.Context, id int64, deletedAfter time.Time) error
+		Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
 	}
 	Followers interface {
-		Follow(ctx context.Context, followerID int64, userID int64) error
+		Follow(ctx context.Context, followerID int64, userID int64) (bool, error)
 		Unfollow(ctx context.Context, followerID int64, userID int64) error
+		GetFollowRequests(ctx context.Context, userID int64, query FollowQuery) ([]FollowRequest, PageInfo, error)
+		ApproveFollowRequest(ctx context.Context, userID int64, requesterID int64) error
+		ApproveAllFollowRequests(ctx context.Context, userID int64) ([]int64, error)
+		RejectFollowRequest(ctx context.Context, userID int64, requesterID int64) error
+		GetFollowers(ctx context.Context, userID int64, query FollowQuery) ([]FollowEntry, PageInfo, error)
+		GetFollowing(ctx context.Context, userID int64, query FollowQuery) ([]FollowEntry, PageInfo, error)
+		GetProfile(ctx context.Context, user *User, viewerID int64) (*UserProfile, error)
+		GetFollowerIDs(ctx context.Context, userID int64) ([]int64, error)
+		GetCelebrities(ctx context.Context, threshold int64) ([]int64, error)
 	}
 	Roles interface {
 		GetByName(ctx context.Context, roleName string) (*Role, error)
 	}
+	Polls interface {
+		GetByPostIDs(ctx context.Context, postIDs []int64, viewerID int64) (map[int64]*Poll, error)
+		Vote(ctx context.Context, postID int64, userID int64, optionIDs []int64) error
+		Unvote(ctx context.Context, postID int64, userID int64) error
+	}
+	LinkPreviews interface {
+		GetByURL(ctx context.Context, url string) (*LinkPreview, error)
+		Upsert(ctx context.Context, preview *LinkPreview) error
+		GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]LinkPreview, error)
+	}
+	Analytics interface {
+		AddPostViews(ctx context.Context, counts []PostViewCount) error
+		GetUserAnalytics(ctx context.Context, userID int64, since time.Time) (*UserAnalytics, error)
+	}
+	Search interface {
+		SearchPosts(ctx context.Context, viewerID int64, query SearchQuery) ([]PostSearchResult, PageInfo, error)
+		SearchUsers(ctx context.Context, viewerID int64, query SearchQuery) ([]UserSearchResult, PageInfo, error)
+		SearchComments(ctx context.Context, viewerID int64, query SearchQuery) ([]CommentSearchResult, PageInfo, error)
+	}
+	Explore interface {
+		RefreshScores(ctx context.Context, scoring HotScoring) (int64, error)
+		GetExplore(ctx context.Context, viewerID int64, query ExploreQuery, seenSince time.Time) ([]PostWithMetadata, PageInfo, error)
+		MarkSeen(ctx context.Context, userID int64, postIDs []int64) error
+		PurgeSeen(ctx context.Context, seenBefore time.Time) (int64, error)
+	}
+	Federation interface {
+		GetActorKey(ctx context.Context, userID int64) (*ActorKey, error)
+		CreateActorKey(ctx context.Context, key *ActorKey) error
+		UpsertRemoteActor(ctx context.Context, actor *RemoteActor) error
+		GetRemoteActorByKeyID(ctx context.Context, keyID string) (*RemoteActor, error)
+		GetRemoteActorByURI(ctx context.Context, uri string) (*RemoteActor, error)
+		DeleteRemoteActor(ctx context.Context, id int64) error
+		AddRemoteFollower(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) error
+		RemoveRemoteFollower(ctx context.Context, userID int64, remoteActorID int64) error
+		RequestRemoteFollow(ctx context.Context, userID int64, remoteActorID int64, followActivityID string) (bool, error)
+		GetRemoteFollowRequests(ctx context.Context, userID int64, fq FollowQuery) ([]RemoteFollowRequest, PageInfo, error)
+		ApproveRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*RemoteFollowRequest, error)
+		ApproveAllRemoteFollowRequests(ctx context.Context, userID int64) ([]RemoteFollowRequest, error)
+		RejectRemoteFollowRequest(ctx context.Context, userID int64, remoteActorID int64) (*RemoteFollowRequest, error)
+		CountRemoteFollowers(ctx context.Context, userID int64) (int64, error)
+		GetRemoteFollowerInboxes(ctx context.Context, userID int64) ([]string, error)
+		CreateRemotePost(ctx context.Context, post *RemotePost, recipientIDs []int64) error
+		GetRemoteReplies(ctx context.Context, postID int64, rq RemotePostsQuery) ([]RemotePost, PageInfo, error)
+		GetRemoteMentions(ctx context.Context, userID int64, rq RemotePostsQuery) ([]RemotePost, PageInfo, error)
+		DeleteRemotePost(ctx context.Context, remoteActorID int64, uri string) error
+		EnqueueDeliveries(ctx context.Context, userID int64, inboxes []string, payload []byte) error
+		ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+		CompleteDelivery(ctx context.Context, id int64) error
+		RetryDelivery(ctx context.Context, id int64, nextAttempt time.Time, lastError string) error
+	}
+	Notifications interface {
+		Notify(ctx context.Context, event NotificationEvent, recipientIDs []int64) ([]int64, error)
+		GetByUser(ctx context.Context, userID int64, query NotificationQuery) ([]Notification, PageInfo, error)
+		GetByIDs(ctx context.Context, ids []int64) ([]Notification, error)
+		CountUnread(ctx context.Context, userID int64) (int64, error)
+		MarkRead(ctx context.Context, userID int64, id int64) error
+		MarkAllRead(ctx context.Context, userID int64) (int64, error)
+		GetMentionedUserIDs(ctx context.Context, postID int64) ([]int64, error)
+		GetUnreadRecipientIDs(ctx context.Context, postID int64, commentID int64) ([]int64, error)
+	}
+	Conversations interface {
+		Create(ctx context.Context, conversation *Conversation, participantIDs []int64) (bool, error)
+		GetByID(ctx context.Context, id int64, userID int64) (*Conversation, error)
+		GetByUser(ctx context.Context, userID int64, query ConversationQuery) ([]Conversation, PageInfo, error)
+		CheckCanMessage(ctx context.Context, senderID int64, recipientIDs []int64) error
+		GetRecipientIDs(ctx context.Context, conversationID int64, userID int64) ([]int64, error)
+		CreateMessage(ctx context.Context, message *Message) error
+		GetMessages(ctx context.Context, conversationID int64, userID int64, query ConversationQuery) ([]Message, PageInfo, error)
+		DeleteMessage(ctx context.Context, conversationID int64, userID int64, messageID int64) error
+		MarkRead(ctx context.Context, conversationID int64, userID int64, messageID int64) (int64, error)
+		CountUnread(ctx context.Context, userID int64) (int64, error)
+	}
+	Blocks interface {
+		Block(ctx context.Context, userID int64, blockedID int64) error
+		Unblock(ctx context.Context, userID int64, blockedID int64) error
+		GetBlocked(ctx context.Context, userID int64, query FollowQuery) ([]RelatedUser, PageInfo, error)
+		GetBlockedIDs(ctx context.Context, userID int64) ([]int64, error)
+	}
+	Mutes interface {
+		Mute(ctx context.Context, userID int64, mutedID int64) error
+		Unmute(ctx context.Context, userID int64, mutedID int64) error
+		GetMuted(ctx context.Context, userID int64, query FollowQuery) ([]RelatedUser, PageInfo, error)
+		GetMuterIDs(ctx context.Context, userID int64) ([]int64, error)
+	}
+	Communities interface {
+		Create(ctx context.Context, community *Community) error
+		GetBySlug(ctx context.Context, slug string, viewerID int64) (*Community, error)
+		GetAll(ctx context.Context, viewerID int64, query CommunityQuery) ([]Community, PageInfo, error)
+		Update(ctx context.Context, community *Community) error
+		Join(ctx context.Context, community *Community, userID int64) (*CommunityMember, error)
+		Invite(ctx context.Context, communityID int64, userID int64, invitedBy int64) (*CommunityMember, error)
+		GetMember(ctx context.Context, communityID int64, userID int64) (*CommunityMember, error)
+		GetMembers(ctx context.Context, communityID int64, query CommunityMemberQuery) ([]CommunityMember, PageInfo, error)
+		ApproveMember(ctx context.Context, communityID int64, userID int64) error
+		SetMemberRole(ctx context.Context, communityID int64, userID int64, role string) error
+		RemoveMember(ctx context.Context, communityID int64, userID int64) error
+		CheckCanPost(ctx context.Context, communityID int64, userID int64) error
+		IsBanned(ctx context.Context, communityID int64, userID int64) (bool, error)
+		Ban(ctx context.Context, ban *CommunityBan) error
+		Unban(ctx context.Context, communityID int64, userID int64) error
+		GetBans(ctx context.Context, communityID int64, query CommunityMemberQuery) ([]CommunityBan, PageInfo, error)
+	}
+	Suggestions interface {
+		RefreshSuggestions(ctx context.Context, scoring SuggestionScoring) (int64, error)
+		GetSuggestions(ctx context.Context, userID int64, query FollowQuery) ([]Suggestion, PageInfo, error)
+		Dismiss(ctx context.Context, userID int64, suggestedID int64) error
+	}
+	Reactions interface {
+		React(ctx context.Context, reaction *Reaction) error
+		Unreact(ctx context.Context, postID int64, userID int64) error
+		GetCounts(ctx context.Context, postID int64) (map[string]int64, error)
+	}
+	Hooks *Hooks
 }
 
 func NewStorage(db *sql.DB) Storage {
+	hooks := &Hooks{}
 	return Storage{
-		&PostStore{db},
+		&PostStore{db, hooks},
 		&UserStore{db},
-		&CommentStore{db},
-		&FollowerStore{db},
+		&CommentStore{db, hooks},
+		&FollowerStore{db, hooks},
 		&RolesStore{db},
+		&PollStore{db},
+		&LinkPreviewStore{db},
+		&AnalyticsStore{db},
+		&SearchStore{db},
+		&ExploreStore{db},
+		&FederationStore{db},
+		&NotificationStore{db},
+		&ConversationStore{db},
+		&BlockStore{db},
+		&MuteStore{db},
+		&CommunityStore{db},
+		&SuggestionStore{db},
+		&ReactionStore{db, hooks},
+		hooks,
 	}
 }
 
@@ -56,3 +213,11 @@ func withTx(db *sql.DB, ctx context.Context, f func(tx *sql.Tx) error) error {
 	}
 	return tx.Commit()
 }
+
+func formatNullTime(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	formatted := t.Time.Format(time.RFC3339)
+	return &formatted
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SuggestionScoring configures the who-to-follow score of a candidate,
+//
+//	MutualWeight * followed users who follow them +
+//	TagWeight * shared tags + PopularWeight * ln(1 + followers)
+//
+// where the tags of interest are those of the public posts younger than Window
+// and only accounts that posted within Window count as popular. Candidates are
+// the friends of friends, the users sharing a tag and the PopularAccounts most
+// followed active accounts; the best PerUser of them are kept.
+type SuggestionScoring struct {
+	MutualWeight    float64
+	TagWeight       float64
+	PopularWeight   float64
+	Window          time.Duration
+	PopularAccounts int
+	PerUser         int
+}
+
+// Suggestion is a user suggested to follow, with why they were suggested.
+type Suggestion struct {
+	ID          int64    `json:"id"`
+	Username    string   `json:"username"`
+	IsPrivate   bool     `json:"is_private"`
+	Score       float64  `json:"score"`
+	MutualCount int64    `json:"mutual_count"`
+	SharedTags  []string `json:"shared_tags"`
+}
+
+// suggestable is the SQL condition that the user in suggestedCol can be
+// suggested to the one in userCol: they are not the same user, there is no
+// follow, pending follow request, block or dismissal between them.
+func suggestable(userCol, suggestedCol string) string {
+	return fmt.Sprintf(`%[1]s <> %[2]s AND
+		NOT EXISTS (SELECT 1 FROM followers f WHERE f.user_id = %[2]s AND f.follower_id = %[1]s) AND
+		NOT EXISTS (SELECT 1 FROM follow_requests fr WHERE fr.user_id = %[2]s AND fr.requester_id = %[1]s) AND
+		NOT EXISTS (SELECT 1 FROM suggestion_dismissals sd WHERE sd.user_id = %[1]s AND sd.suggested_id = %[2]s) AND
+		%[3]s`, userCol, suggestedCol, notBlocked(userCol, suggestedCol))
+}
+
+type SuggestionStore struct {
+	db *sql.DB
+}
+
+// suggestionBatchSize is how many users RefreshSuggestions recomputes in one
+// transaction, so each stays short and within its timeout.
+const suggestionBatchSize = 500
+
+// RefreshSuggestions replaces the precomputed suggestions of every active user,
+// a batch of users at a time, and returns how many were stored. Readers see the
+// old suggestions of a user until their batch commits.
+func (store *SuggestionStore) RefreshSuggestions(ctx context.Context, scoring SuggestionScoring) (int64, error) {
+	popularIDs, err := store.getPopularIDs(ctx, scoring)
+	if err != nil {
+		return 0, err
+	}
+	var stored, afterID int64
+	for {
+		userIDs, err := store.getActiveUserIDs(ctx, afterID, suggestionBatchSize)
+		if err != nil || len(userIDs) == 0 {
+			return stored, err
+		}
+		count, err := store.refreshBatch(ctx, scoring, popularIDs, userIDs)
+		if err != nil {
+			return stored, err
+		}
+		stored += count
+		afterID = userIDs[len(userIDs)-1]
+	}
+}
+
+// getPopularIDs returns the PopularAccounts most followed accounts that posted
+// within Window, suggested to everyone.
+func (store *SuggestionStore) getPopularIDs(ctx context.Context, scoring SuggestionScoring) ([]int64, error) {
+	query := `
+		SELECT u.id
+		FROM users u
+		WHERE u.is_activated AND EXISTS (
+			SELECT 1 FROM posts p
+			WHERE p.user_id = u.id AND p.deleted_at IS NULL AND p.created_at > NOW() - make_interval(secs => $1::float8)
+		)
+		ORDER BY (SELECT COUNT(*) FROM followers f WHERE f.user_id = u.id) DESC, u.id DESC
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration*6)
+	defer cancel()
+
+	return store.queryIDs(ctx, query, scoring.Window.Seconds(), scoring.PopularAccounts)
+}
+
+// getActiveUserIDs returns up to limit active users with an ID above afterID.
+func (store *SuggestionStore) getActiveUserIDs(ctx context.Context, afterID int64, limit int) ([]int64, error) {
+	query := `
+		SELECT id FROM users WHERE is_activated AND id > $1 ORDER BY id LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return store.queryIDs(ctx, query, afterID, limit)
+}
+
+// refreshBatch replaces the suggestions of userIDs in one transaction.
+func (store *SuggestionStore) refreshBatch(ctx context.Context, scoring SuggestionScoring, popularIDs []int64, userIDs []int64) (int64, error) {
+	var stored int64
+	err := withTx(store.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration*6)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_suggestions WHERE user_id = ANY($1)`, pq.Array(userIDs)); err != nil {
+			return err
+		}
+		query := `
+			WITH interests AS (
+				SELECT DISTINCT p.user_id, t.tag::text AS tag
+				FROM posts p, unnest(p.tags) AS t(tag)
+				WHERE
+					p.deleted_at IS NULL AND
+					p.visibility = 'public' AND
+					p.created_at > NOW() - make_interval(secs => $4::float8)
+			), mutuals AS (
+				SELECT f1.follower_id AS user_id, f2.user_id AS suggested_id, COUNT(*) AS mutual_count
+				FROM followers f1
+				JOIN followers f2 ON f2.follower_id = f1.user_id
+				WHERE f1.follower_id = ANY($7)
+				GROUP BY f1.follower_id, f2.user_id
+			), shared AS (
+				SELECT a.user_id, b.user_id AS suggested_id, array_agg(a.tag ORDER BY a.tag) AS shared_tags
+				FROM interests a
+				JOIN interests b ON b.tag = a.tag AND b.user_id <> a.user_id
+				WHERE a.user_id = ANY($7)
+				GROUP BY a.user_id, b.user_id
+			), candidates AS (
+				SELECT user_id, suggested_id FROM mutuals
+				UNION
+				SELECT user_id, suggested_id FROM shared
+				UNION
+				SELECT u.id, top.id
+				FROM unnest($7::bigint[]) AS u(id)
+				CROSS JOIN unnest($5::bigint[]) AS top(id)
+			), ranked AS (
+				SELECT
+					c.user_id,
+					c.suggested_id,
+					COALESCE(m.mutual_count, 0) AS mutual_count,
+					COALESCE(s.shared_tags, '{}') AS shared_tags,
+					$1::float8 * COALESCE(m.mutual_count, 0) +
+						$2::float8 * COALESCE(cardinality(s.shared_tags), 0) +
+						$3::float8 * ln(1 + COALESCE(pop.followers_count, 0)) AS score
+				FROM candidates c
+				JOIN users viewer ON viewer.id = c.user_id AND viewer.is_activated
+				JOIN users suggested ON suggested.id = c.suggested_id AND suggested.is_activated
+				LEFT JOIN mutuals m ON m.user_id = c.user_id AND m.suggested_id = c.suggested_id
+				LEFT JOIN shared s ON s.user_id = c.user_id AND s.suggested_id = c.suggested_id
+				LEFT JOIN LATERAL (
+					SELECT COUNT(*) AS followers_count FROM followers f WHERE f.user_id = c.suggested_id
+				) pop ON EXISTS (
+					SELECT 1 FROM posts p
+					WHERE p.user_id = c.suggested_id AND p.deleted_at IS NULL AND p.created_at > NOW() - make_interval(secs => $4::float8)
+				)
+				WHERE ` + suggestable("c.user_id", "c.suggested_id") + `
+			)
+			INSERT INTO user_suggestions (user_id, suggested_id, score, mutual_count, shared_tags)
+			SELECT user_id, suggested_id, score, mutual_count, shared_tags
+			FROM (
+				SELECT *, row_number() OVER (PARTITION BY user_id ORDER BY score DESC, suggested_id DESC) AS position
+				FROM ranked
+			) best
+			WHERE position <= $6
+		`
+		result, err := tx.ExecContext(ctx, query,
+			scoring.MutualWeight,
+			scoring.TagWeight,
+			scoring.PopularWeight,
+			scoring.Window.Seconds(),
+			pq.Array(popularIDs),
+			scoring.PerUser,
+			pq.Array(userIDs),
+		)
+		if err != nil {
+			return err
+		}
+		stored, err = result.RowsAffected()
+		return err
+	})
+	return stored, err
+}
+
+func (store *SuggestionStore) queryIDs(ctx context.Context, query string, args ...any) ([]int64, error) {
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetSuggestions returns the precomputed suggestions of userID, best first.
+// Follows, blocks and dismissals made since the last refresh are left out.
+func (store *SuggestionStore) GetSuggestions(ctx context.Context, userID int64, fq FollowQuery) ([]Suggestion, PageInfo, error) {
+	condition, orderBy, cursorArgs := scoreKeyset(fq.Cursor, "s.score", "s.suggested_id", 3)
+	query := `
+		SELECT u.id, u.username, u.is_private, s.score, s.mutual_count, s.shared_tags
+		FROM user_suggestions s
+		JOIN users u ON u.id = s.suggested_id
+		WHERE
+			s.user_id = $1 AND
+			u.is_activated AND
+			` + suggestable("s.user_id", "s.suggested_id") + ` AND
+			` + condition + `
+		ORDER BY ` + orderBy + `
+		LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	args := append([]any{userID, fq.Limit + 1}, cursorArgs...)
+	rows, err := store.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.ID, &s.Username, &s.IsPrivate, &s.Score, &s.MutualCount, pq.Array(&s.SharedTags)); err != nil {
+			return nil, PageInfo{}, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	suggestions, page := paginate(suggestions, fq.Limit, fq.Cursor, func(s Suggestion) Cursor {
+		return Cursor{ID: s.ID, Score: s.Score}
+	})
+	return suggestions, page, nil
+}
+
+// Dismiss stops suggestedID from being suggested to userID. Dismissing twice is
+// not an error; it returns ErrorNotFound when suggestedID is not a user.
+func (store *SuggestionStore) Dismiss(ctx context.Context, userID int64, suggestedID int64) error {
+	query := `
+		INSERT INTO suggestion_dismissals (user_id, suggested_id)
+		SELECT $1, u.id FROM users u WHERE u.id = $2
+		ON CONFLICT (user_id, suggested_id) DO UPDATE SET created_at = NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := store.db.ExecContext(ctx, query, userID, suggestedID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrorNotFound
+	}
+	return nil
+}
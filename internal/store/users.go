@@ -27,6 +27,13 @@ type User struct {
 	Role      Role     `json:"role"`
 }
 
+// UserSettings are the privacy settings of a user. The posts of a private
+// account only reach the followers it approved.
+type UserSettings struct {
+	DMPolicy  string `json:"dm_policy" validate:"oneof=everyone following nobody"`
+	IsPrivate bool   `json:"is_private"`
+}
+
 type password struct {
 	text *string
 	hash []byte
@@ -142,6 +149,29 @@ func (store *UserStore) GetByEmail(ctx context.Context, email string) (*User, er
 	return &user, nil
 }
 
+func (store *UserStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	query := `SELECT id, username, email, created_at FROM users WHERE username = $1 AND is_activated = true`
+	var (
+		user      User
+		createdAt time.Time
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := store.db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.Email, &createdAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorNotFound
+		default:
+			return nil, err
+		}
+	}
+	user.CreatedAt = createdAt.Format(time.RFC3339)
+	return &user, nil
+}
+
 func (store *UserStore) CreateAndInvite(
 	ctx context.Context,
 	user *User,
@@ -280,3 +310,25 @@ func (store *UserStore) delete(ctx context.Context, tx *sql.Tx, id int64) error
 	}
 	return nil
 }
+
+func (store *UserStore) GetSettings(ctx context.Context, userID int64) (*UserSettings, error) {
+	query := `SELECT dm_policy, is_private FROM users WHERE id = $1`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var settings UserSettings
+	err := store.db.QueryRowContext(ctx, query, userID).Scan(&settings.DMPolicy, &settings.IsPrivate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrorNotFound
+	}
+	return &settings, err
+}
+
+func (store *UserStore) UpdateSettings(ctx context.Context, userID int64, settings *UserSettings) error {
+	query := `UPDATE users SET dm_policy = $2, is_private = $3 WHERE id = $1`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, query, userID, settings.DMPolicy, settings.IsPrivate)
+	return err
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityMentioned = "mentioned"
+)
+
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\w@])@(\w{3,100})`)
+
+// postVisibleTo returns the SQL condition every post read path uses to decide
+// whether the post aliased as postAlias can be seen by the viewer bound to viewerParam.
+// The author always sees their own posts, the posts of a private account only
+// reach its followers and a block hides each user's posts from the other.
+func postVisibleTo(postAlias, viewerParam string) string {
+	return fmt.Sprintf(`(
+		%[1]s.user_id = %[2]s OR (
+			%[3]s AND (
+				NOT EXISTS (SELECT 1 FROM users vu WHERE vu.id = %[1]s.user_id AND vu.is_private) OR
+				EXISTS (SELECT 1 FROM followers vf WHERE vf.user_id = %[1]s.user_id AND vf.follower_id = %[2]s)
+			) AND (
+				%[1]s.visibility = 'public' OR
+				(%[1]s.visibility = 'followers' AND EXISTS (
+					SELECT 1 FROM followers vf WHERE vf.user_id = %[1]s.user_id AND vf.follower_id = %[2]s
+				)) OR
+				(%[1]s.visibility = 'mentioned' AND EXISTS (
+					SELECT 1 FROM post_mentions vm WHERE vm.post_id = %[1]s.id AND vm.user_id = %[2]s
+				))
+			)
+		)
+	)`, postAlias, viewerParam, notBlocked(postAlias+".user_id", viewerParam))
+}
+
+// ParseMentions returns the distinct usernames mentioned with @username in content.
+func ParseMentions(content string) []string {
+	seen := map[string]bool{}
+	mentions := []string{}
+	for _, match := range mentionRegexp.FindAllStringSubmatch(content, -1) {
+		username := strings.ToLower(match[1])
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+		mentions = append(mentions, match[1])
+	}
+	return mentions
+}
+
+func replacePostMentions(ctx context.Context, tx *sql.Tx, post *Post) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_mentions WHERE post_id = $1`, post.ID); err != nil {
+		return err
+	}
+	mentions := ParseMentions(post.Content)
+	if len(mentions) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO post_mentions (post_id, user_id)
+		SELECT $1, users.id FROM users WHERE lower(username) = ANY($2) AND ` + notBlocked("users.id", "$3") + `
+		ON CONFLICT DO NOTHING
+	`
+	lowered := make([]string, len(mentions))
+	for i, m := range mentions {
+		lowered[i] = strings.ToLower(m)
+	}
+	_, err := tx.ExecContext(ctx, query, post.ID, pq.Array(lowered), post.UserID)
+	return err
+}
@@ -0,0 +1,185 @@
+package syndication
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func testFeed() *Feed {
+	published := time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("CET", 3600))
+	return &Feed{
+		Title:       "gopher on GopherSocial",
+		Description: "Public posts by gopher",
+		HomeURL:     "https://gopher.social/users/gopher",
+		FeedURL:     "https://api.gopher.social/users/gopher/feed",
+		AuthorName:  "gopher",
+		AuthorURL:   "https://gopher.social/users/gopher",
+		Updated:     published.Add(2 * time.Hour),
+		Items: []Item{
+			{
+				ID:          "https://gopher.social/posts/2",
+				URL:         "https://gopher.social/posts/2",
+				Title:       "Generics & iterators",
+				ContentHTML: `<p>Range over <code>func</code> &amp; friends</p>`,
+				Tags:        []string{"go", "iterators"},
+				Published:   published.Add(time.Hour),
+				Updated:     published.Add(2 * time.Hour),
+			},
+			{
+				ID:          "tag:gopher.social,2024:posts/1",
+				URL:         "https://gopher.social/posts/1",
+				Title:       "Hello",
+				ContentHTML: `<p>First post</p>`,
+				Published:   published,
+				Updated:     published,
+			},
+		},
+	}
+}
+
+// assertGolden compares got with testdata/name, rewriting it with -update.
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match the golden file:\n%s", name, got)
+	}
+}
+
+func TestAtomGolden(t *testing.T) {
+	body, err := testFeed().Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "feed.atom", body)
+}
+
+func TestRSSGolden(t *testing.T) {
+	body, err := testFeed().RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "feed.rss", body)
+}
+
+func TestJSONGolden(t *testing.T) {
+	body, err := testFeed().JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "feed.json", indented.Bytes())
+}
+
+// TestAtomStructure reads the document back the way a feed reader would, so
+// escaping of the HTML content and the namespace are checked independently of
+// the golden file formatting.
+func TestAtomStructure(t *testing.T) {
+	body, err := testFeed().Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		XMLName xml.Name
+		Entries []struct {
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+			Content struct {
+				Type string `xml:"type,attr"`
+				Body string `xml:",chardata"`
+			} `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.XMLName.Space != "http://www.w3.org/2005/Atom" || doc.XMLName.Local != "feed" {
+		t.Fatalf("root element = %v, want an Atom feed", doc.XMLName)
+	}
+	if len(doc.Entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(doc.Entries))
+	}
+	entry := doc.Entries[0]
+	if entry.Content.Type != "html" || entry.Content.Body != testFeed().Items[0].ContentHTML {
+		t.Errorf("content = %+v, want the escaped item HTML", entry.Content)
+	}
+	if entry.Updated != "2024-03-01T10:30:00Z" {
+		t.Errorf("updated = %s, want the UTC RFC 3339 time", entry.Updated)
+	}
+}
+
+func TestRSSStructure(t *testing.T) {
+	body, err := testFeed().RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Items []struct {
+				GUID struct {
+					IsPermaLink bool   `xml:"isPermaLink,attr"`
+					Value       string `xml:",chardata"`
+				} `xml:"guid"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != "2.0" || len(doc.Channel.Items) != 2 {
+		t.Fatalf("version %q with %d items, want 2.0 with 2", doc.Version, len(doc.Channel.Items))
+	}
+	first, second := doc.Channel.Items[0], doc.Channel.Items[1]
+	if !first.GUID.IsPermaLink || second.GUID.IsPermaLink {
+		t.Errorf("isPermaLink = %v, %v; want true only for the guid equal to the link", first.GUID.IsPermaLink, second.GUID.IsPermaLink)
+	}
+	if _, err := time.Parse(time.RFC1123Z, first.PubDate); err != nil {
+		t.Errorf("pubDate %q is not RFC 1123: %v", first.PubDate, err)
+	}
+	if first.Description != testFeed().Items[0].ContentHTML {
+		t.Errorf("description = %q, want the escaped item HTML", first.Description)
+	}
+}
+
+func TestJSONStructure(t *testing.T) {
+	empty := testFeed()
+	empty.Items = nil
+	body, err := empty.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["version"] != "https://jsonfeed.org/version/1.1" {
+		t.Errorf("version = %v, want JSON Feed 1.1", doc["version"])
+	}
+	// items is required by the specification even when there are none.
+	if items, ok := doc["items"].([]any); !ok || len(items) != 0 {
+		t.Errorf("items = %v, want an empty array", doc["items"])
+	}
+}
@@ -0,0 +1,458 @@
+package timeline
+
+import (
+	"AwesomeProject/internal/store"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultMaxLength          = 800
+	DefaultCelebrityThreshold = 10000
+	DefaultCelebrityRefresh   = time.Minute * 10
+	DefaultTTL                = time.Hour * 24 * 7
+	DefaultQueueSize          = 1000
+
+	// fanOutBatchSize is how many timelines one pipelined round trip pushes to.
+	fanOutBatchSize = 500
+	celebritiesKey  = "timeline-celebrities"
+)
+
+// pushScript adds the scored members in ARGV[2:] to the timeline in KEYS[1] when
+// it exists and trims it to the rank in ARGV[1].
+var pushScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+for i = 2, #ARGV, 2 do
+	redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, ARGV[1])
+return 1
+`)
+
+// marker keeps rebuilt timelines present in Redis even when they hold no posts,
+// so an empty timeline is not mistaken for a missing one. Its +inf score keeps
+// it out of the way of capping.
+const marker = "0"
+
+type Config struct {
+	MaxLength          int
+	CelebrityThreshold int64
+	// CelebrityRefresh is how often the set of celebrities is recomputed.
+	CelebrityRefresh time.Duration
+	TTL              time.Duration
+	QueueSize        int
+}
+
+// Service materialises every user's home timeline as a capped Redis sorted set of
+// post IDs scored by creation time. Posts are fanned out to followers on write,
+// except for celebrities whose posts are merged in on read. Timelines only pick
+// candidate posts: the page itself is still read from Postgres, which applies
+// visibility, deletion and the query's filters.
+//
+// Fan-out runs on background workers started by Run, and who counts as a
+// celebrity is precomputed into a Redis set shared by every API instance.
+type Service struct {
+	rdb    *redis.Client
+	store  *store.Storage
+	cfg    Config
+	logger *zap.SugaredLogger
+	queue  chan fanOut
+}
+
+// fanOut is a post waiting to be pushed into its author's followers' timelines.
+type fanOut struct {
+	authorID int64
+	entry    store.TimelineEntry
+}
+
+func NewService(rdb *redis.Client, storage *store.Storage, cfg Config, logger *zap.SugaredLogger) *Service {
+	if cfg.MaxLength == 0 {
+		cfg.MaxLength = DefaultMaxLength
+	}
+	if cfg.CelebrityThreshold == 0 {
+		cfg.CelebrityThreshold = DefaultCelebrityThreshold
+	}
+	if cfg.CelebrityRefresh == 0 {
+		cfg.CelebrityRefresh = DefaultCelebrityRefresh
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	return &Service{
+		rdb:    rdb,
+		store:  storage,
+		cfg:    cfg,
+		logger: logger,
+		queue:  make(chan fanOut, cfg.QueueSize),
+	}
+}
+
+// Run fans posts out with concurrency goroutines and refreshes the celebrities
+// every CelebrityRefresh until ctx is done.
+func (s *Service) Run(ctx context.Context, concurrency int) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-s.queue:
+					if err := s.fanOut(ctx, job); err != nil {
+						s.logger.Warnw("failed to fan out post", "post_id", job.entry.PostID, "error", err.Error())
+					}
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(s.cfg.CelebrityRefresh)
+	defer ticker.Stop()
+	for {
+		if err := s.RefreshCelebrities(ctx); err != nil {
+			s.logger.Errorw("failed to refresh timeline celebrities", "error", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshCelebrities replaces the set of users whose posts are merged into
+// timelines on read instead of being fanned out. The marker keeps the set
+// present when nobody reaches the threshold.
+func (s *Service) RefreshCelebrities(ctx context.Context) error {
+	ids, err := s.store.Followers.GetCelebrities(ctx, s.cfg.CelebrityThreshold)
+	if err != nil {
+		return err
+	}
+	members := make([]any, 0, len(ids)+1)
+	members = append(members, marker)
+	for _, id := range ids {
+		members = append(members, strconv.FormatInt(id, 10))
+	}
+	staging := celebritiesKey + ":staging"
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, staging)
+	pipe.SAdd(ctx, staging, members...)
+	pipe.Rename(ctx, staging, celebritiesKey)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// celebrities returns the precomputed celebrities, computing them first when
+// the set is missing.
+func (s *Service) celebrities(ctx context.Context) ([]int64, error) {
+	members, err := s.rdb.SMembers(ctx, celebritiesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		if err := s.RefreshCelebrities(ctx); err != nil {
+			return nil, err
+		}
+		if members, err = s.rdb.SMembers(ctx, celebritiesKey).Result(); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]int64, 0, len(members))
+	for _, member := range members {
+		if member == marker {
+			continue
+		}
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("timeline: invalid celebrity %q: %w", member, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *Service) isCelebrity(ctx context.Context, userID int64) (bool, error) {
+	celebrities, err := s.celebrities(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range celebrities {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Feed returns a page of userID's feed, rebuilding their timeline first when it is
+// missing. Pages reaching past the oldest post of a full timeline, and ascending
+// feeds, are read from Postgres alone.
+func (s *Service) Feed(ctx context.Context, userID int64, feedQuery store.PaginatedFeedQuery) ([]store.PostWithMetadata, store.PageInfo, error) {
+	entries, err := s.read(ctx, userID)
+	if err != nil {
+		return nil, store.PageInfo{}, err
+	}
+	full := len(entries) >= s.cfg.MaxLength
+	if full {
+		oldest := entries[len(entries)-1].CreatedAt
+		cursor := feedQuery.Cursor
+		if feedQuery.Sort != "desc" || (cursor != nil && !cursor.Backward && cursor.CreatedAt.Before(oldest)) {
+			return s.store.Posts.GetUserFeed(ctx, userID, feedQuery)
+		}
+	}
+
+	celebrities, err := s.celebrities(ctx)
+	if err != nil {
+		return nil, store.PageInfo{}, err
+	}
+	postIDs := make([]int64, len(entries))
+	for i, entry := range entries {
+		postIDs[i] = entry.PostID
+	}
+	posts, page, err := s.store.Posts.GetTimelineFeed(ctx, userID, postIDs, celebrities, feedQuery)
+	if err != nil {
+		return nil, store.PageInfo{}, err
+	}
+	backward := feedQuery.Cursor != nil && feedQuery.Cursor.Backward
+	if full && !backward && page.NextCursor == "" {
+		// The page ran into the end of the timeline, older posts are only in Postgres.
+		return s.store.Posts.GetUserFeed(ctx, userID, feedQuery)
+	}
+	return posts, page, nil
+}
+
+// PostCreated pushes post into its author's timeline and queues the fan-out to
+// their followers. When the queue is full the fan-out runs in the caller.
+func (s *Service) PostCreated(ctx context.Context, post *store.Post) error {
+	createdAt, err := time.Parse(time.RFC3339Nano, post.CreatedAt)
+	if err != nil {
+		return err
+	}
+	entry := store.TimelineEntry{PostID: post.ID, CreatedAt: createdAt}
+	if err := s.push(ctx, []int64{post.UserID}, entry); err != nil {
+		return err
+	}
+	job := fanOut{authorID: post.UserID, entry: entry}
+	select {
+	case s.queue <- job:
+		return nil
+	default:
+		s.logger.Warnw("timeline fan-out queue is full, fanning out inline", "post_id", post.ID)
+		return s.fanOut(ctx, job)
+	}
+}
+
+// fanOut pushes a post into the timelines of its author's followers unless the
+// author is a celebrity. Followers without a timeline are skipped, theirs is
+// rebuilt with the post on their next read.
+func (s *Service) fanOut(ctx context.Context, job fanOut) error {
+	celebrity, err := s.isCelebrity(ctx, job.authorID)
+	if err != nil || celebrity {
+		return err
+	}
+	followerIDs, err := s.store.Followers.GetFollowerIDs(ctx, job.authorID)
+	if err != nil {
+		return err
+	}
+	return s.push(ctx, followerIDs, job.entry)
+}
+
+// VisibilityChanged brings post in line with its new visibility in the
+// timelines of its author's followers: it is pushed to those who may see it and
+// removed from the others. Posts of celebrities are only ever removed, they are
+// merged in on read.
+func (s *Service) VisibilityChanged(ctx context.Context, post *store.Post) error {
+	createdAt, err := time.Parse(time.RFC3339Nano, post.CreatedAt)
+	if err != nil {
+		return err
+	}
+	followerIDs, err := s.store.Followers.GetFollowerIDs(ctx, post.UserID)
+	if err != nil {
+		return err
+	}
+	allowed, removed := followerIDs, []int64{}
+	if post.Visibility == store.VisibilityMentioned {
+		mentionedIDs, err := s.store.Notifications.GetMentionedUserIDs(ctx, post.ID)
+		if err != nil {
+			return err
+		}
+		mentioned := make(map[int64]bool, len(mentionedIDs))
+		for _, id := range mentionedIDs {
+			mentioned[id] = true
+		}
+		allowed = []int64{}
+		for _, id := range followerIDs {
+			if mentioned[id] {
+				allowed = append(allowed, id)
+			} else {
+				removed = append(removed, id)
+			}
+		}
+	}
+	if err := s.remove(ctx, removed, post.ID); err != nil {
+		return err
+	}
+	celebrity, err := s.isCelebrity(ctx, post.UserID)
+	if err != nil || celebrity {
+		return err
+	}
+	return s.push(ctx, allowed, store.TimelineEntry{PostID: post.ID, CreatedAt: createdAt})
+}
+
+// Followed merges the recent posts of userID into followerID's timeline.
+func (s *Service) Followed(ctx context.Context, followerID int64, userID int64) error {
+	celebrity, err := s.isCelebrity(ctx, userID)
+	if err != nil || celebrity {
+		return err
+	}
+	entries, err := s.store.Posts.GetAuthorTimelineEntries(ctx, userID, s.cfg.MaxLength)
+	if err != nil {
+		return err
+	}
+	return s.push(ctx, []int64{followerID}, entries...)
+}
+
+// Unfollowed removes the posts of userID from followerID's timeline.
+func (s *Service) Unfollowed(ctx context.Context, followerID int64, userID int64) error {
+	entries, err := s.store.Posts.GetAuthorTimelineEntries(ctx, userID, s.cfg.MaxLength)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	postIDs := make([]int64, len(entries))
+	for i, entry := range entries {
+		postIDs[i] = entry.PostID
+	}
+	return s.remove(ctx, []int64{followerID}, postIDs...)
+}
+
+// read returns the timeline of userID newest first, rebuilding it when missing.
+func (s *Service) read(ctx context.Context, userID int64) ([]store.TimelineEntry, error) {
+	key := timelineKey(userID)
+	members, err := s.rdb.ZRevRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	// A timeline without its marker expired while a post was pushed into it
+	// and holds only that post.
+	if len(members) == 0 || members[0].Member != marker {
+		return s.rebuild(ctx, userID)
+	}
+	if err := s.rdb.Expire(ctx, key, s.cfg.TTL).Err(); err != nil {
+		return nil, err
+	}
+	entries := make([]store.TimelineEntry, 0, len(members))
+	for _, member := range members {
+		id, _ := member.Member.(string)
+		if id == marker {
+			continue
+		}
+		postID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("timeline: invalid member %q: %w", id, err)
+		}
+		entries = append(entries, store.TimelineEntry{PostID: postID, CreatedAt: time.UnixMicro(int64(member.Score))})
+	}
+	return entries, nil
+}
+
+func (s *Service) rebuild(ctx context.Context, userID int64) ([]store.TimelineEntry, error) {
+	celebrities, err := s.celebrities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.store.Posts.GetTimelineEntries(ctx, userID, celebrities, s.cfg.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+	key := timelineKey(userID)
+	members := []redis.Z{{Score: math.Inf(1), Member: marker}}
+	for _, entry := range entries {
+		members = append(members, member(entry))
+	}
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZAdd(ctx, key, members...)
+	pipe.Expire(ctx, key, s.cfg.TTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// push adds entries to the timelines of userIDs that exist and trims them to
+// MaxLength, pipelining fanOutBatchSize timelines per round trip.
+func (s *Service) push(ctx context.Context, userIDs []int64, entries ...store.TimelineEntry) error {
+	if len(userIDs) == 0 || len(entries) == 0 {
+		return nil
+	}
+	args := make([]any, 0, 1+2*len(entries))
+	args = append(args, -s.cfg.MaxLength-2)
+	for _, entry := range entries {
+		z := member(entry)
+		args = append(args, z.Score, z.Member)
+	}
+	// Pipelined EVALSHA can not fall back to EVAL, so make sure the script is cached.
+	if err := pushScript.Load(ctx, s.rdb).Err(); err != nil {
+		return err
+	}
+	for start := 0; start < len(userIDs); start += fanOutBatchSize {
+		end := min(start+fanOutBatchSize, len(userIDs))
+		pipe := s.rdb.Pipeline()
+		for _, userID := range userIDs[start:end] {
+			pushScript.EvalSha(ctx, pipe, []string{timelineKey(userID)}, args...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove takes postIDs out of the timelines of userIDs, pipelining
+// fanOutBatchSize timelines per round trip.
+func (s *Service) remove(ctx context.Context, userIDs []int64, postIDs ...int64) error {
+	if len(userIDs) == 0 || len(postIDs) == 0 {
+		return nil
+	}
+	members := make([]any, len(postIDs))
+	for i, id := range postIDs {
+		members[i] = strconv.FormatInt(id, 10)
+	}
+	for start := 0; start < len(userIDs); start += fanOutBatchSize {
+		end := min(start+fanOutBatchSize, len(userIDs))
+		pipe := s.rdb.Pipeline()
+		for _, userID := range userIDs[start:end] {
+			pipe.ZRem(ctx, timelineKey(userID), members...)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func member(entry store.TimelineEntry) redis.Z {
+	return redis.Z{
+		Score:  float64(entry.CreatedAt.UnixMicro()),
+		Member: strconv.FormatInt(entry.PostID, 10),
+	}
+}
+
+func timelineKey(userID int64) string {
+	return fmt.Sprintf("timeline-%v", userID)
+}
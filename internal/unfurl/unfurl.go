@@ -0,0 +1,263 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	DefaultTimeout      = 5 * time.Second
+	DefaultMaxRedirects = 3
+	DefaultMaxBodyBytes = 512 * 1024
+)
+
+var (
+	ErrBlockedAddress  = errors.New("unfurl: address is not publicly routable")
+	ErrTooManyRedirect = errors.New("unfurl: too many redirects")
+	ErrNotHTML         = errors.New("unfurl: response is not html")
+	ErrUnsupportedURL  = errors.New("unfurl: only http and https urls are supported")
+)
+
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+)
+
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+type Config struct {
+	Timeout      time.Duration
+	MaxRedirects int
+	MaxBodyBytes int64
+}
+
+// Fetcher downloads pages and reads their OpenGraph metadata. Connections to
+// private, loopback and link-local addresses are refused after DNS resolution,
+// so redirects and rebinding can not be used to reach internal services.
+type Fetcher struct {
+	client       *http.Client
+	maxBodyBytes int64
+}
+
+func NewFetcher(cfg Config) *Fetcher {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = DefaultMaxRedirects
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	transport := NewPublicTransport(cfg.Timeout)
+	return &Fetcher{
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > cfg.MaxRedirects {
+					return ErrTooManyRedirect
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxBodyBytes: cfg.MaxBodyBytes,
+	}
+}
+
+// NewPublicTransport returns a transport that refuses connections to private,
+// loopback and link-local addresses after DNS resolution. It is shared by every
+// client that fetches URLs supplied by users or remote servers.
+func NewPublicTransport(timeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+}
+
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "GopherSocialBot/1.0 (+link preview)")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, ErrNotHTML
+	}
+
+	preview := parse(io.LimitReader(resp.Body, f.maxBodyBytes), resp.Request.URL)
+	preview.URL = rawURL
+	return preview, nil
+}
+
+func parse(r io.Reader, base *url.URL) *Preview {
+	var (
+		preview      Preview
+		title        string
+		description  string
+		inTitle      bool
+		tokenizer    = html.NewTokenizer(r)
+		ogProperties = map[string]*string{
+			"og:title":       &preview.Title,
+			"og:description": &preview.Description,
+			"og:image":       &preview.Image,
+			"og:site_name":   &preview.SiteName,
+		}
+	)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return finish(&preview, title, description, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+			case "meta":
+				key, content := metaAttrs(token)
+				if field, ok := ogProperties[key]; ok && *field == "" {
+					*field = content
+				}
+				if key == "description" && description == "" {
+					description = content
+				}
+			case "body":
+				return finish(&preview, title, description, base)
+			}
+		case html.TextToken:
+			if inTitle && title == "" {
+				title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+func finish(preview *Preview, title, description string, base *url.URL) *Preview {
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = base.Hostname()
+	}
+	if preview.Image != "" {
+		if image, err := base.Parse(preview.Image); err == nil && (image.Scheme == "http" || image.Scheme == "https") {
+			preview.Image = image.String()
+		} else {
+			preview.Image = ""
+		}
+	}
+	return preview
+}
+
+func metaAttrs(token html.Token) (string, string) {
+	var key, content string
+	for _, attr := range token.Attr {
+		switch strings.ToLower(attr.Key) {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(attr.Val)
+			}
+		case "content":
+			content = strings.TrimSpace(attr.Val)
+		}
+	}
+	return key, content
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedURL
+	}
+	if u.Hostname() == "" {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,227 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestFetcher returns a fetcher that can reach server despite it listening
+// on loopback, keeping the redirect policy and body cap of NewFetcher.
+func newTestFetcher(server *httptest.Server, cfg Config) *Fetcher {
+	f := NewFetcher(cfg)
+	f.client.Transport = server.Client().Transport
+	return f
+}
+
+func serveHTML(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, body)
+	}
+}
+
+func TestFetchBlocksLoopbackAfterResolution(t *testing.T) {
+	server := httptest.NewServer(serveHTML(`<title>internal</title>`))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	f := NewFetcher(Config{})
+	for _, rawURL := range []string{server.URL, "http://localhost:" + port + "/"} {
+		if _, err := f.Fetch(context.Background(), rawURL); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Fetch(%s) error = %v, want %v", rawURL, err, ErrBlockedAddress)
+		}
+	}
+}
+
+func TestFetchBlocksRedirectToLoopback(t *testing.T) {
+	internal := httptest.NewServer(serveHTML(`<title>internal</title>`))
+	defer internal.Close()
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusFound)
+	}))
+	defer public.Close()
+
+	// Only the first hop goes through a transport that may reach loopback.
+	f := NewFetcher(Config{})
+	first := true
+	publicTransport := f.client.Transport
+	f.client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if first {
+			first = false
+			return public.Client().Transport.RoundTrip(req)
+		}
+		return publicTransport.RoundTrip(req)
+	})
+	if _, err := f.Fetch(context.Background(), public.URL); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("Fetch error = %v, want %v", err, ErrBlockedAddress)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::6810:85e5", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"64:ff9b::7f00:1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestFetchRejectsUnsupportedURLs(t *testing.T) {
+	f := NewFetcher(Config{})
+	for _, rawURL := range []string{"ftp://example.com/", "file:///etc/passwd", "http:///path"} {
+		if _, err := f.Fetch(context.Background(), rawURL); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("Fetch(%s) error = %v, want %v", rawURL, err, ErrUnsupportedURL)
+		}
+	}
+}
+
+func TestFetchRedirectLimit(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loop/", func(w http.ResponseWriter, r *http.Request) {
+		var hop int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/loop/"), "%d", &hop)
+		if hop == 2 {
+			serveHTML(`<title>arrived</title>`)(w, r)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/loop/%d", hop+1), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	preview, err := newTestFetcher(server, Config{MaxRedirects: 2}).Fetch(context.Background(), server.URL+"/loop/0")
+	if err != nil {
+		t.Fatalf("Fetch within the limit: %v", err)
+	}
+	if preview.Title != "arrived" {
+		t.Errorf("Title = %q, want %q", preview.Title, "arrived")
+	}
+
+	_, err = newTestFetcher(server, Config{MaxRedirects: 1}).Fetch(context.Background(), server.URL+"/loop/0")
+	if !errors.Is(err, ErrTooManyRedirect) {
+		t.Fatalf("Fetch past the limit error = %v, want %v", err, ErrTooManyRedirect)
+	}
+}
+
+func TestFetchBodySizeCap(t *testing.T) {
+	padding := strings.Repeat("x", 4096)
+	server := httptest.NewServer(serveHTML(`<html><head><!--` + padding + `--><meta property="og:title" content="late"></head></html>`))
+	defer server.Close()
+
+	preview, err := newTestFetcher(server, Config{MaxBodyBytes: 1024}).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "" {
+		t.Errorf("Title = %q, metadata past the body cap must not be read", preview.Title)
+	}
+
+	preview, err = newTestFetcher(server, Config{MaxBodyBytes: 8192}).Fetch(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if preview.Title != "late" {
+		t.Errorf("Title = %q, want %q", preview.Title, "late")
+	}
+}
+
+func TestFetchRejectsNonHTML(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	if _, err := newTestFetcher(server, Config{}).Fetch(context.Background(), server.URL); !errors.Is(err, ErrNotHTML) {
+		t.Fatalf("Fetch error = %v, want %v", err, ErrNotHTML)
+	}
+}
+
+func TestFetchParsesOpenGraph(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Preview
+	}{
+		{
+			name: "open graph",
+			body: `<html><head>
+				<title>Page title</title>
+				<meta name="description" content="Page description">
+				<meta property="og:title" content=" OG title ">
+				<meta property="og:description" content="OG description">
+				<meta property="og:image" content="/images/cover.png">
+				<meta property="og:site_name" content="Example">
+			</head><body><meta property="og:title" content="ignored"></body></html>`,
+			want: Preview{
+				Title:       "OG title",
+				Description: "OG description",
+				Image:       "/images/cover.png",
+				SiteName:    "Example",
+			},
+		},
+		{
+			name: "fallbacks",
+			body: `<html><head>
+				<title> Page title </title>
+				<META NAME="Description" CONTENT="Page description">
+				<meta property="og:image" content="javascript:alert(1)">
+			</head></html>`,
+			want: Preview{
+				Title:       "Page title",
+				Description: "Page description",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(serveHTML(tt.body))
+			defer server.Close()
+
+			preview, err := newTestFetcher(server, Config{}).Fetch(context.Background(), server.URL)
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			want := tt.want
+			want.URL = server.URL
+			if want.Image != "" {
+				want.Image = server.URL + want.Image
+			}
+			if want.SiteName == "" {
+				want.SiteName = "127.0.0.1"
+			}
+			if *preview != want {
+				t.Errorf("preview = %+v, want %+v", *preview, want)
+			}
+		})
+	}
+}
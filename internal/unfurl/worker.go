@@ -0,0 +1,114 @@
+package unfurl
+
+import (
+	"AwesomeProject/internal/store"
+	"AwesomeProject/internal/store/cache"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const RefreshAfter = time.Hour * 24
+
+// Worker unfurls post links in the background so post writes never wait on remote sites.
+type Worker struct {
+	fetcher *Fetcher
+	store   *store.Storage
+	cache   *cache.Storage
+	logger  *zap.SugaredLogger
+	queue   chan string
+}
+
+// NewWorker creates a worker. cacheStorage may be nil when Redis is disabled.
+func NewWorker(fetcher *Fetcher, storage *store.Storage, cacheStorage *cache.Storage, logger *zap.SugaredLogger, queueSize int) *Worker {
+	return &Worker{
+		fetcher: fetcher,
+		store:   storage,
+		cache:   cacheStorage,
+		logger:  logger,
+		queue:   make(chan string, queueSize),
+	}
+}
+
+// Enqueue schedules urls for unfurling. URLs are dropped when the queue is full;
+// they are picked up again the next time a post containing them is written.
+func (w *Worker) Enqueue(urls ...string) {
+	for _, url := range urls {
+		select {
+		case w.queue <- url:
+		default:
+			w.logger.Warnf("link preview queue is full, dropping %s", url)
+		}
+	}
+}
+
+// Run processes the queue with concurrency goroutines until ctx is done.
+func (w *Worker) Run(ctx context.Context, concurrency int) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case url := <-w.queue:
+					w.process(ctx, url)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Worker) process(ctx context.Context, url string) {
+	if w.cache != nil {
+		cached, err := w.cache.LinkPreviews.Get(ctx, url)
+		if err != nil {
+			w.logger.Warnw("failed to read link preview from cache", "url", url, "error", err.Error())
+		}
+		if cached != nil {
+			return
+		}
+	}
+
+	existing, err := w.store.LinkPreviews.GetByURL(ctx, url)
+	if err != nil && !errors.Is(err, store.ErrorNotFound) {
+		w.logger.Errorw("failed to read link preview", "url", url, "error", err.Error())
+		return
+	}
+	if existing != nil && time.Since(existing.FetchedAt) < RefreshAfter {
+		w.cachePreview(ctx, existing)
+		return
+	}
+
+	preview := &store.LinkPreview{URL: url}
+	fetched, err := w.fetcher.Fetch(ctx, url)
+	if err != nil {
+		w.logger.Infow("failed to unfurl link", "url", url, "error", err.Error())
+		preview.Failed = true
+	} else {
+		preview.Title = fetched.Title
+		preview.Description = fetched.Description
+		preview.Image = fetched.Image
+		preview.SiteName = fetched.SiteName
+	}
+	if err := w.store.LinkPreviews.Upsert(ctx, preview); err != nil {
+		w.logger.Errorw("failed to save link preview", "url", url, "error", err.Error())
+		return
+	}
+	w.cachePreview(ctx, preview)
+}
+
+func (w *Worker) cachePreview(ctx context.Context, preview *store.LinkPreview) {
+	if w.cache == nil || preview.Failed {
+		return
+	}
+	if err := w.cache.LinkPreviews.Set(ctx, preview); err != nil {
+		w.logger.Warnw("failed to cache link preview", "url", preview.URL, "error", err.Error())
+	}
+}